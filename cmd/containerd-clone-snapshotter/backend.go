@@ -0,0 +1,101 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/containerd/containerd/snapshots/overlay"
+)
+
+// backendOptions tune the inner snapshotter.  Only the overlay backend has
+// options.
+type backendOptions struct {
+	// asyncRemove defers removing the data of removed snapshots until
+	// containerd calls Cleanup after garbage collection.
+	asyncRemove bool
+
+	// upperdirLabel records the upperdir of each snapshot in its labels.
+	upperdirLabel bool
+
+	// mountOptions are added to the options of every overlay mount.
+	mountOptions []string
+}
+
+// overlayOpts returns the overlay snapshotter options for o.
+func (o backendOptions) overlayOpts() []overlay.Opt {
+	var opts []overlay.Opt
+	if o.asyncRemove {
+		opts = append(opts, overlay.AsynchronousRemove)
+	}
+	if o.upperdirLabel {
+		opts = append(opts, overlay.WithUpperdirLabel)
+	}
+	if len(o.mountOptions) > 0 {
+		opts = append(opts, overlay.WithMountOptions(o.mountOptions))
+	}
+	return opts
+}
+
+// parseBackendOptions checks the -overlay-* flag values against -backend
+// and returns the options they select.  mountOptions is a comma-separated
+// list of overlay mount options; the directory options the overlay
+// snapshotter sets for each snapshot cannot be among them.
+func parseBackendOptions(backend string, asyncRemove, upperdirLabel bool, mountOptions string) (backendOptions, error) {
+	opts := backendOptions{asyncRemove: asyncRemove, upperdirLabel: upperdirLabel}
+	for _, opt := range strings.Split(mountOptions, ",") {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(opt, "=")
+		switch name {
+		case "lowerdir", "upperdir", "workdir":
+			return backendOptions{}, fmt.Errorf("-overlay-mount-options %q: %s is set by the overlay snapshotter", mountOptions, name)
+		}
+		opts.mountOptions = append(opts.mountOptions, opt)
+	}
+	if backend != "overlay" && (opts.asyncRemove || opts.upperdirLabel || len(opts.mountOptions) > 0) {
+		return backendOptions{}, fmt.Errorf("-overlay-async-remove, -overlay-upperdir-label and -overlay-mount-options require -backend overlay, not %q", backend)
+	}
+	return opts, nil
+}
+
+// backends maps each -backend name to a constructor for the inner
+// snapshotter, which stores its data under the given root directory.
+var backends = map[string]func(root string, opts backendOptions) (snapshots.Snapshotter, error){
+	"overlay": func(root string, opts backendOptions) (snapshots.Snapshotter, error) {
+		return overlay.NewSnapshotter(root, opts.overlayOpts()...)
+	},
+	"native": func(root string, _ backendOptions) (snapshots.Snapshotter, error) {
+		return native.NewSnapshotter(root)
+	},
+}
+
+// backendNames returns the accepted -backend values in sorted order.
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newInnerSnapshotter creates the inner snapshotter selected by -backend,
+// rooted at root and tuned by opts.
+func newInnerSnapshotter(backend, root string, opts backendOptions) (snapshots.Snapshotter, error) {
+	newFn, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (supported: %s)", backend, strings.Join(backendNames(), ", "))
+	}
+	sn, err := newFn(root, opts)
+	if err != nil {
+		return nil, fmt.Errorf("create %s snapshotter: %w", backend, err)
+	}
+	return sn, nil
+}
@@ -0,0 +1,120 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestNewInnerSnapshotter_Native verifies that -backend=native yields a
+// working inner snapshotter by round-tripping a Prepare and Commit through
+// the clone snapshotter wrapping it.
+func TestNewInnerSnapshotter_Native(t *testing.T) {
+	ctx := context.Background()
+	inner, err := newInnerSnapshotter("native", t.TempDir(), backendOptions{})
+	if err != nil {
+		t.Fatalf("newInnerSnapshotter: %v", err)
+	}
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "active", ""); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if err := sn.Commit(ctx, "committed", "active"); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	info, err := sn.Stat(ctx, "committed")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Kind != snapshots.KindCommitted {
+		t.Errorf("snapshot kind = %v, want KindCommitted", info.Kind)
+	}
+}
+
+// TestNewInnerSnapshotter_Unknown verifies that an unknown backend is
+// rejected with an error naming the supported ones.
+func TestNewInnerSnapshotter_Unknown(t *testing.T) {
+	_, err := newInnerSnapshotter("zfs", t.TempDir(), backendOptions{})
+	if err == nil {
+		t.Fatal("newInnerSnapshotter(\"zfs\") succeeded, want an error")
+	}
+	if msg := err.Error(); !strings.Contains(msg, `"zfs"`) || !strings.Contains(msg, "native, overlay") {
+		t.Errorf("error %q should name the backend and list the supported ones", msg)
+	}
+}
+
+// TestNewInnerSnapshotter_OverlayAsyncRemove verifies that
+// -overlay-async-remove reaches the overlay snapshotter: a removed
+// snapshot's data is kept until Cleanup, which the clone snapshotter passes
+// through.
+func TestNewInnerSnapshotter_OverlayAsyncRemove(t *testing.T) {
+	ctx := context.Background()
+	for _, async := range []bool{false, true} {
+		root := t.TempDir()
+		inner, err := newInnerSnapshotter("overlay", root, backendOptions{asyncRemove: async})
+		if err != nil {
+			t.Fatalf("newInnerSnapshotter: %v", err)
+		}
+		sn := snapshotter.New(inner)
+		defer sn.Close()
+
+		if _, err := sn.Prepare(ctx, "active", ""); err != nil {
+			t.Fatalf("Prepare: %v", err)
+		}
+		if err := sn.Remove(ctx, "active"); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+		left := snapshotDirs(t, root)
+		if async && left != 1 || !async && left != 0 {
+			t.Errorf("async remove %t: %d snapshot directories left after Remove", async, left)
+		}
+		if err := sn.Cleanup(ctx); err != nil {
+			t.Fatalf("Cleanup: %v", err)
+		}
+		if left := snapshotDirs(t, root); left != 0 {
+			t.Errorf("async remove %t: %d snapshot directories left after Cleanup, want 0", async, left)
+		}
+	}
+}
+
+// snapshotDirs returns the number of snapshot directories of the overlay
+// snapshotter rooted at root.
+func snapshotDirs(t *testing.T, root string) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(root, "snapshots"))
+	if err != nil {
+		t.Fatalf("read snapshots directory: %v", err)
+	}
+	return len(entries)
+}
+
+// TestParseBackendOptions verifies that the overlay flags are rejected with
+// other backends and that mount options the overlay snapshotter sets
+// itself are refused.
+func TestParseBackendOptions(t *testing.T) {
+	opts, err := parseBackendOptions("overlay", true, false, "volatile, index=off")
+	if err != nil {
+		t.Fatalf("parseBackendOptions: %v", err)
+	}
+	if !opts.asyncRemove || len(opts.mountOptions) != 2 || opts.mountOptions[1] != "index=off" {
+		t.Errorf("options = %+v, want async remove and mount options [volatile index=off]", opts)
+	}
+	if _, err := parseBackendOptions("native", false, true, ""); err == nil || !strings.Contains(err.Error(), "-backend overlay") {
+		t.Errorf("overlay flag with -backend native error = %v, want one requiring -backend overlay", err)
+	}
+	if _, err := parseBackendOptions("overlay", false, false, "volatile,upperdir=/tmp"); err == nil || !strings.Contains(err.Error(), "upperdir") {
+		t.Errorf("upperdir mount option error = %v, want it refused", err)
+	}
+	if _, err := parseBackendOptions("native", false, false, ""); err != nil {
+		t.Errorf("no overlay flags with -backend native: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/proxy"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runClone implements the clone subcommand: it connects to the snapshots
+// service on a Unix socket, either the plugin's own or containerd's, and
+// prepares a clone of a snapshot, writing the new snapshot's mounts to out.
+func runClone(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("clone", flag.ContinueOnError)
+	address := flags.String(
+		"address",
+		"/run/containerd-clone-snapshotter/containerd-clone-snapshotter.sock",
+		"Unix socket of the plugin, or of containerd together with -snapshotter",
+	)
+	snapshotterName := flags.String("snapshotter", "clone", "Snapshotter name, used when -address is containerd's socket")
+	namespace := flags.String("namespace", namespaces.Default, "containerd namespace of the snapshots")
+	source := flags.String("source", "", "Key of the snapshot to clone")
+	dest := flags.String("dest", "", "Key of the clone to create")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *source == "" || *dest == "" {
+		return errors.New("-source and -dest are required")
+	}
+
+	conn, err := grpc.Dial("unix://"+*address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", *address, err)
+	}
+	defer conn.Close()
+
+	sn := proxy.NewSnapshotter(snapshotsapi.NewSnapshotsClient(conn), *snapshotterName)
+	ctx = namespaces.WithNamespace(ctx, *namespace)
+	mounts, err := sn.Prepare(ctx, *dest, "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: *source,
+	}))
+	if err != nil {
+		return fmt.Errorf("clone %q to %q: %w", *source, *dest, err)
+	}
+
+	for _, m := range mounts {
+		if _, err := fmt.Fprintf(out, "%s %s %s\n", m.Type, m.Source, strings.Join(m.Options, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TestRunClone verifies that the clone subcommand prepares a clone through
+// the plugin's socket and prints its mounts.
+func TestRunClone(t *testing.T) {
+	socketPath := serveTestSocket(t)
+	conn, err := grpc.Dial("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial %s: %v", socketPath, err)
+	}
+	defer conn.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	sn := proxy.NewSnapshotter(snapshotsapi.NewSnapshotsClient(conn), "clone")
+	mounts, err := sn.Prepare(ctx, "source", "")
+	if err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(mounts[0].Source, "data.txt"), []byte("cloned"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runClone(context.Background(), []string{"-address", socketPath, "-source", "source", "-dest", "copy"}, &out); err != nil {
+		t.Fatalf("runClone: %v", err)
+	}
+
+	fields := strings.Fields(out.String())
+	if len(fields) != 3 || fields[0] != "bind" {
+		t.Fatalf("runClone output = %q, want one bind mount", out.String())
+	}
+	got, err := os.ReadFile(filepath.Join(fields[1], "data.txt"))
+	if err != nil || string(got) != "cloned" {
+		t.Errorf("data.txt in clone = %q, %v; want %q", got, err, "cloned")
+	}
+	info, err := sn.Stat(ctx, "copy")
+	if err != nil {
+		t.Fatalf("Stat copy: %v", err)
+	}
+	if info.Kind != snapshots.KindActive {
+		t.Errorf("clone kind = %v, want KindActive", info.Kind)
+	}
+}
+
+// TestRunClone_MissingFlags verifies that -source and -dest are required.
+func TestRunClone_MissingFlags(t *testing.T) {
+	err := runClone(context.Background(), []string{"-source", "source"}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "-dest") {
+		t.Errorf("runClone without -dest = %v, want an error about -dest", err)
+	}
+}
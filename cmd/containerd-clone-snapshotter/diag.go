@@ -0,0 +1,95 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// zfsSuperMagic is the statfs magic of ZFS, which x/sys does not define.
+const zfsSuperMagic = 0x2fc12fc1
+
+// filesystems names the filesystems recognised by their statfs magic.
+var filesystems = map[int64]string{
+	unix.BCACHEFS_SUPER_MAGIC:  "bcachefs",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.EXT4_SUPER_MAGIC:      "ext4",
+	unix.F2FS_SUPER_MAGIC:      "f2fs",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlayfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	zfsSuperMagic:              "zfs",
+}
+
+// filesystemName returns the name of the filesystem holding path, or its
+// statfs magic in hex if it is not a known one.
+func filesystemName(path string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", fmt.Errorf("statfs %s: %w", path, err)
+	}
+	if name, ok := filesystems[int64(st.Type)]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("unknown (magic 0x%x)", st.Type), nil
+}
+
+// reflinkSupported reports whether files in dir can be reflinked, by
+// cloning a scratch file with FICLONE.  The scratch files are removed.
+func reflinkSupported(dir string) (bool, error) {
+	src, err := os.CreateTemp(dir, ".reflink-probe-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(src.Name())
+	defer src.Close()
+	if _, err := src.Write([]byte("probe")); err != nil {
+		return false, err
+	}
+	dst, err := os.CreateTemp(dir, ".reflink-probe-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(dst.Name())
+	defer dst.Close()
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())) == nil, nil
+}
+
+// logStartup logs the environment the plugin serves in: the address it
+// listens on, with Unix socket paths made absolute, the backend, the
+// filesystem of root and whether it supports reflinks.  It warns when the
+// copy method cannot work as configured there.  Failures to probe are
+// logged, not fatal.
+func logStartup(l *log.Logger, addr net.Addr, backend, root string, method snapshotter.CopyMethod) {
+	where := addr.String()
+	if addr.Network() == "unix" {
+		if abs, err := filepath.Abs(where); err == nil {
+			where = abs
+		}
+	}
+	l.Printf("containerd-clone-snapshotter listening on %s (%s)", where, addr.Network())
+
+	fs, err := filesystemName(root)
+	if err != nil {
+		l.Printf("backend %s, root %s: %v", backend, root, err)
+		return
+	}
+	reflink, err := reflinkSupported(root)
+	if err != nil {
+		l.Printf("backend %s, root %s on %s, reflink support unknown: %v", backend, root, fs, err)
+		return
+	}
+	l.Printf("backend %s, root %s on %s, reflink supported: %t", backend, root, fs, reflink)
+	if method == snapshotter.CopyMethodReflink && !reflink {
+		l.Printf("warning: -copy-method %s is not supported by %s; clones will copy file data instead", method, fs)
+	}
+}
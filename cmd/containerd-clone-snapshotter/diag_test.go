@@ -0,0 +1,56 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// TestLogStartup verifies that the startup log names the absolute socket
+// path, the backend and the filesystem of root, and warns when reflink
+// clones are configured on a filesystem without reflinks.
+func TestLogStartup(t *testing.T) {
+	root := t.TempDir()
+	if err := unix.Mount("tmpfs", root, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("mount tmpfs: %v", err)
+	}
+	defer unix.Unmount(root, 0)
+
+	var buf bytes.Buffer
+	addr := &net.UnixAddr{Name: "plugin.sock", Net: "unix"}
+	logStartup(log.New(&buf, "", 0), addr, "native", root, snapshotter.CopyMethodReflink)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"listening on " + filepath.Join(cwd, "plugin.sock"),
+		"backend native, root " + root + " on tmpfs",
+		"reflink supported: false",
+		"warning: -copy-method reflink is not supported by tmpfs",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("startup log does not contain %q:\n%s", want, out)
+		}
+	}
+	if entries, err := os.ReadDir(root); err != nil || len(entries) != 0 {
+		t.Errorf("reflink probe left %d entries in root (%v)", len(entries), err)
+	}
+
+	buf.Reset()
+	logStartup(log.New(&buf, "", 0), addr, "native", root, snapshotter.CopyMethodCopy)
+	if strings.Contains(buf.String(), "warning") {
+		t.Errorf("startup log warns about the copy method:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdsPerClone is how many file descriptors a clone holds at once: the
+// source and copy of the file being copied, the directory being walked and
+// one to read or set inode flags.
+const fdsPerClone = 4
+
+// fdHeadroom is left for the gRPC server, its connections and the inner
+// snapshotter.
+const fdHeadroom = 64
+
+// checkFileLimit logs lim, the RLIMIT_NOFILE the plugin runs with, and warns
+// if it is low for the number of clones that may run at once and the files
+// they may hold open.  Go raises the soft limit to the hard one at startup,
+// so lim.Cur is normally the hard limit already; raise that, e.g. with
+// LimitNOFILE in the systemd unit, if it is low.
+func checkFileLimit(l *log.Logger, lim unix.Rlimit, maxClones, maxOpenFiles int) {
+	l.Printf("open file limit %d (hard %d)", lim.Cur, lim.Max)
+
+	var need uint64
+	switch {
+	case maxOpenFiles > 0:
+		// Only file copies are bounded; every clone still walks
+		// directories and sets flags.
+		need = uint64(maxOpenFiles) + uint64(maxClones)*(fdsPerClone-2) + fdHeadroom
+	case maxClones > 0:
+		need = uint64(maxClones)*fdsPerClone + fdHeadroom
+	default:
+		l.Printf("warning: with -max-concurrent-clones 0 and -max-open-files 0 nothing bounds the files clones hold open")
+		return
+	}
+	if lim.Cur < need {
+		l.Printf("warning: open file limit %d is below the %d concurrent clones may need; lower -max-concurrent-clones, set -max-open-files or raise the limit",
+			lim.Cur, need)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCheckFileLimit verifies that the open file limit is logged and that a
+// limit too low for the allowed clones and open files is warned about.
+func TestCheckFileLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name                    string
+		cur                     uint64
+		maxClones, maxOpenFiles int
+		warn                    bool
+	}{
+		{"enough", 1024, 4, 0, false},
+		{"too few for clones", 64, 4, 0, true},
+		{"enough for open files", 200, 10, 64, false},
+		{"too few for open files", 200, 4, 256, true},
+		{"unbounded", 1 << 20, 0, 0, true},
+	} {
+		var buf bytes.Buffer
+		checkFileLimit(log.New(&buf, "", 0), unix.Rlimit{Cur: tc.cur, Max: 1 << 20}, tc.maxClones, tc.maxOpenFiles)
+		out := buf.String()
+		if !strings.Contains(out, "open file limit") {
+			t.Errorf("%s: limit not logged: %q", tc.name, out)
+		}
+		if got := strings.Contains(out, "warning:"); got != tc.warn {
+			t.Errorf("%s: warned %t, want %t: %q", tc.name, got, tc.warn, out)
+		}
+	}
+}
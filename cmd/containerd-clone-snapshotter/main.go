@@ -28,27 +28,79 @@
 //	containerd-clone-snapshotter [flags]
 //
 //	Flags:
-//	  -socket  string  Unix socket path (default: /run/containerd-clone-snapshotter/containerd-clone-snapshotter.sock)
-//	  -root    string  Root directory for snapshot storage (default: /var/lib/containerd-clone-snapshotter)
+//	  -socket           string    Unix socket path (default: /run/containerd-clone-snapshotter/containerd-clone-snapshotter.sock)
+//	  -socket-mode      string    Octal permissions for the Unix socket, e.g. 0660 (default: left as created)
+//	  -socket-owner     string    Owner of the Unix socket and its directory as user[:group] (default: unchanged)
+//	  -allowed-uids     string    Comma-separated users whose processes may call the plugin over the Unix socket (default: any)
+//	  -allowed-gids     string    Comma-separated groups whose processes may call the plugin over the Unix socket (default: any)
+//	  -root             string    Root directory for snapshot storage (default: /var/lib/containerd-clone-snapshotter)
+//	  -root-mode        string    Octal permissions for the root directory (default: 0700)
+//	  -root-owner       string    Owner of the root directory as user[:group] (default: unchanged)
+//	  -backend          string    Inner snapshotter: overlay or native (default: overlay)
+//	  -overlay-async-remove       Defer deleting removed overlay snapshots until containerd's cleanup (default: off)
+//	  -overlay-upperdir-label     Record each overlay snapshot's upperdir in its labels (default: off)
+//	  -overlay-mount-options string  Comma-separated options added to every overlay mount (default: none)
+//	  -allowed-prefixes string    Comma-separated directories -socket and -root must lie under (default: any)
+//	  -pprof-addr       string    Serve net/http/pprof on this TCP address, e.g. 127.0.0.1:6060 (default: disabled)
+//	  -log-rpcs         string    Log snapshots service calls with duration, peer and error: off, errors or all (default: off)
+//	  -listen-tcp       string    Serve on this TCP address with mutual TLS instead of the Unix socket
+//	  -tls-cert         string    Server certificate (PEM) for -listen-tcp
+//	  -tls-key          string    Server private key (PEM) for -listen-tcp
+//	  -tls-client-ca    string    CA bundle (PEM) that client certificates must chain to for -listen-tcp
+//	  -staging-dir      string    Directory for in-progress clone work, on the filesystem of -root (default: -root/staging)
+//	  -copy-method      string    How clones copy files: copy, reflink or hardlink (default: copy)
+//	  -max-concurrent-clones int  Maximum number of clones copying at once; further clones wait (default: 4, 0: unlimited)
+//	  -max-open-files   int       Maximum number of file descriptors clones hold open at once to copy files (default: 0, unlimited)
+//	  -archive-dir      string    Directory the archives named by the clone-from-tar label must lie under (default: disabled)
+//	  -namespace-quotas string    Comma-separated namespace=bytes caps on the bytes clones may copy per namespace (default: none)
+//	  -post-clone-hook  string    Command run with each clone's writable directory before it is returned (default: none)
+//	  -ignore-files     string    Comma-separated patterns of runtime-managed files clones leave out (default: etc/hostname,etc/hosts,etc/resolv.conf)
+//	  -stat-clone-size            Report the disk usage of clones in the clone-size label of Stat (default: off)
+//	  -shutdown-timeout duration  How long to wait for in-flight clones on SIGINT/SIGTERM (default: 30s)
+//	  -validate                   Check that the plugin can start with these flags, print a report and exit without serving
+//	  -version                    Print the build version, Go version and commit, then exit
+//
+// # Subcommands
+//
+//	containerd-clone-snapshotter clone -source <key> -dest <key> [-address <socket>] [-namespace <ns>] [-snapshotter <name>]
+//
+// clone prepares a clone of an existing snapshot through a running plugin,
+// or through containerd when -address is containerd's socket, and prints the
+// new snapshot's mounts.  It is meant for testing and operational repairs.
+//
+// # Socket activation
+//
+// When started by systemd with a matching .socket unit, the listening socket
+// is inherited via the LISTEN_PID/LISTEN_FDS protocol and -socket is ignored.
 package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
 	"github.com/containerd/containerd/contrib/snapshotservice"
 	"github.com/containerd/containerd/namespaces"
-	"github.com/containerd/containerd/snapshots/overlay"
+	"github.com/containerd/containerd/snapshots"
 	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
 )
 
 // namespaceUnaryInterceptor extracts the containerd namespace from incoming
@@ -65,7 +117,92 @@ func namespaceUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.Una
 	return handler(ctx, req)
 }
 
+// listenFdsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START).  It is a variable so tests can point it
+// at a descriptor they own.
+var listenFdsStart = 3
+
+// activationListener returns the listener passed in by systemd socket
+// activation, or nil if the process was not socket-activated.  The
+// activation environment variables are cleared so that they are not
+// inherited by child processes.
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if nfds > 1 {
+		log.Printf("socket activation passed %d fds, using only the first", nfds)
+	}
+	f := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("use socket-activated fd %d: %w", listenFdsStart, err)
+	}
+	return l, nil
+}
+
+// listen returns the listener the gRPC server should serve on: the
+// socket-activated one when present, otherwise a fresh Unix socket at
+// socketPath.  created reports whether listen created the socket, as
+// opposed to taking over the socket-activated one.
+func listen(socketPath string) (l net.Listener, created bool, err error) {
+	if l, err := activationListener(); err != nil || l != nil {
+		return l, false, err
+	}
+
+	// Ensure the socket directory exists.
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return nil, false, fmt.Errorf("create socket directory: %w", err)
+	}
+
+	// Remove a stale socket from a previous run.
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	l, err = net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("listen on %q: %w", socketPath, err)
+	}
+	return l, true, nil
+}
+
+// newServer builds the gRPC server exposing sn as the snapshots service,
+// together with the standard gRPC health service so that orchestrators can
+// probe the plugin, and with server reflection so that tools such as grpcurl
+// can introspect it.  The health status starts out NOT_SERVING; the caller
+// flips it to SERVING once the server is about to accept connections.
+func newServer(sn snapshots.Snapshotter, opts ...grpc.ServerOption) (*grpc.Server, *health.Server) {
+	opts = append(opts, grpc.UnaryInterceptor(namespaceUnaryInterceptor))
+	grpcServer := grpc.NewServer(opts...)
+	snapshotsapi.RegisterSnapshotsServer(grpcServer, snapshotservice.FromSnapshotter(sn))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	reflection.Register(grpcServer)
+
+	return grpcServer, healthServer
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "clone" {
+		if err := runClone(context.Background(), os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatalf("clone: %v", err)
+		}
+		return
+	}
+
 	socketPath := flag.String(
 		"socket",
 		"/run/containerd-clone-snapshotter/containerd-clone-snapshotter.sock",
@@ -76,56 +213,324 @@ func main() {
 		"/var/lib/containerd-clone-snapshotter",
 		"Root directory used to store snapshot data",
 	)
+	backend := flag.String(
+		"backend",
+		"overlay",
+		"Inner snapshotter to wrap: "+strings.Join(backendNames(), " or "),
+	)
+	overlayAsyncRemove := flag.Bool(
+		"overlay-async-remove",
+		false,
+		"Defer deleting the data of removed snapshots until containerd calls Cleanup after garbage collection (overlay backend only)",
+	)
+	overlayUpperdirLabel := flag.Bool(
+		"overlay-upperdir-label",
+		false,
+		"Record the upperdir of each snapshot in its containerd.io/snapshot/overlay.upperdir label (overlay backend only)",
+	)
+	overlayMountOptions := flag.String(
+		"overlay-mount-options",
+		"",
+		"Comma-separated options added to every overlay mount, e.g. volatile (overlay backend only)",
+	)
+	pprofAddr := flag.String(
+		"pprof-addr",
+		"",
+		"TCP address to serve net/http/pprof profiling endpoints on (disabled if empty)",
+	)
+	listenTCP := flag.String(
+		"listen-tcp",
+		"",
+		"TCP address to serve on with mutual TLS instead of the Unix socket",
+	)
+	tlsCert := flag.String("tls-cert", "", "Server certificate (PEM) used with -listen-tcp")
+	tlsKey := flag.String("tls-key", "", "Server private key (PEM) used with -listen-tcp")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA bundle (PEM) client certificates must chain to, used with -listen-tcp")
+	allowedPrefixes := flag.String(
+		"allowed-prefixes",
+		"",
+		"Comma-separated directories that -socket and -root must lie under (any if empty)",
+	)
+	stagingDir := flag.String(
+		"staging-dir",
+		"",
+		"Directory for in-progress clone work, on the same filesystem as -root (default: staging under -root)",
+	)
+	copyMethod := flag.String(
+		"copy-method",
+		string(snapshotter.CopyMethodCopy),
+		"How clones copy files: copy, reflink or hardlink",
+	)
+	maxConcurrentClones := flag.Int(
+		"max-concurrent-clones",
+		4,
+		"Maximum number of clones copying at the same time; further clones wait (0: unlimited)",
+	)
+	maxOpenFiles := flag.Int(
+		"max-open-files",
+		0,
+		"Maximum number of file descriptors clones hold open at once to copy files; further files wait (0: unlimited)",
+	)
+	archiveDir := flag.String(
+		"archive-dir",
+		"",
+		"Directory the archives named by the containerd.io/snapshot/clone-from-tar label must lie under (disabled if empty)",
+	)
+	namespaceQuotas := flag.String(
+		"namespace-quotas",
+		"",
+		"Comma-separated namespace=bytes list capping the bytes clones in each namespace may copy while the plugin runs",
+	)
+	postCloneHook := flag.String(
+		"post-clone-hook",
+		"",
+		"Command run with each clone's writable directory as argument and CLONE_KEY, CLONE_SOURCE_KEY and CLONE_NAMESPACE set, before the clone is returned; a failure fails the clone",
+	)
+	ignoreFiles := flag.String(
+		"ignore-files",
+		strings.Join(snapshotter.DefaultIgnoreFiles, ","),
+		"Comma-separated patterns, relative to the container root, of runtime-managed files clones leave out (empty: copy every file)",
+	)
+	statCloneSize := flag.Bool(
+		"stat-clone-size",
+		false,
+		"Report the disk usage of clones in the containerd.io/snapshot/clone-size label of Stat",
+	)
+	shutdownTimeout := flag.Duration(
+		"shutdown-timeout",
+		30*time.Second,
+		"How long to wait for in-flight clones to finish on SIGINT/SIGTERM before stopping anyway",
+	)
+	allowedUIDs := flag.String(
+		"allowed-uids",
+		"",
+		"Comma-separated users, names or IDs, whose processes may call the plugin over the Unix socket (default: anyone who can connect)",
+	)
+	allowedGIDs := flag.String(
+		"allowed-gids",
+		"",
+		"Comma-separated groups, names or IDs, whose processes may call the plugin over the Unix socket (default: anyone who can connect)",
+	)
+	logRPCs := flag.String(
+		"log-rpcs",
+		"off",
+		"Log snapshots service calls with their duration, peer and error: off, errors or all",
+	)
+	socketMode := flag.String("socket-mode", "", "Octal permissions for the Unix socket, e.g. 0660 (default: left as created)")
+	socketOwner := flag.String("socket-owner", "", "Owner of the Unix socket and its directory as user[:group], names or IDs")
+	rootMode := flag.String("root-mode", "", "Octal permissions for the root directory (default: 0700)")
+	rootOwner := flag.String("root-owner", "", "Owner of the root directory as user[:group], names or IDs")
+	validateOnly := flag.Bool("validate", false, "Check that the plugin can start with these flags, print a report and exit without serving")
+	showVersion := flag.Bool("version", false, "Print the build version, Go version and commit, then exit")
 	flag.Parse()
 
-	// Ensure the socket directory exists.
-	if err := os.MkdirAll(filepath.Dir(*socketPath), 0700); err != nil {
-		log.Fatalf("create socket directory: %v", err)
+	if *showVersion {
+		if err := writeVersion(os.Stdout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
 	}
 
-	// Remove a stale socket from a previous run.
-	if err := os.Remove(*socketPath); err != nil && !os.IsNotExist(err) {
-		log.Fatalf("remove stale socket: %v", err)
+	// Both paths are created, and a stale socket removed, below; refuse
+	// suspicious ones up front.
+	prefixes := splitPrefixes(*allowedPrefixes)
+	if err := validatePath("socket", *socketPath, prefixes); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := validatePath("root", *rootDir, prefixes); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *stagingDir != "" {
+		if err := validatePath("staging-dir", *stagingDir, prefixes); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	socketPerms, err := parsePerms("socket", *socketMode, *socketOwner)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	rootPerms, err := parsePerms("root", *rootMode, *rootOwner)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	method, err := snapshotter.ParseCopyMethod(*copyMethod)
+	if err != nil {
+		log.Fatalf("-copy-method: %v", err)
+	}
+	quotas, err := parseQuotas(*namespaceQuotas)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	backendOpts, err := parseBackendOptions(*backend, *overlayAsyncRemove, *overlayUpperdirLabel, *overlayMountOptions)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Resolve transport security before touching any state.
+	var serverOpts []grpc.ServerOption
+	if *listenTCP != "" {
+		tlsConfig, err := serverTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	} else if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		log.Fatalf("-tls-cert, -tls-key and -tls-client-ca require -listen-tcp")
+	}
+	rpcLogLevel, err := parseRPCLogLevel(*logRPCs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if rpcLogLevel != rpcLogOff {
+		// Installed first so that calls refused below are logged too.
+		logger := &rpcLogger{l: log.Default(), level: rpcLogLevel}
+		serverOpts = append(serverOpts, logger.serverOptions()...)
+	}
+	authorizer, err := parsePeerAuthorizer(*allowedUIDs, *allowedGIDs)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if authorizer != nil {
+		// Peer credentials only exist on Unix sockets; TCP callers are
+		// authenticated by their client certificates.
+		if *listenTCP != "" {
+			log.Fatalf("-allowed-uids and -allowed-gids cannot be used with -listen-tcp")
+		}
+		serverOpts = append(serverOpts, authorizer.serverOptions()...)
+	}
+
+	if *validateOnly {
+		opts := validateOptions{root: *rootDir, staging: *stagingDir, backend: *backend, backendOpts: backendOpts, method: method}
+		if *listenTCP == "" {
+			opts.socket = *socketPath
+		}
+		if err := validate(os.Stdout, opts); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+
+	// Profiling is opt-in: it exposes process internals to anyone who can
+	// reach the address.
+	if *pprofAddr != "" {
+		addr, err := servePprof(*pprofAddr)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("serving pprof on http://%s/debug/pprof/", addr)
 	}
 
 	// Create the root storage directory.
 	if err := os.MkdirAll(*rootDir, 0700); err != nil {
 		log.Fatalf("create root directory: %v", err)
 	}
+	if err := rootPerms.apply(*rootDir); err != nil {
+		log.Fatalf("set root directory permissions: %v", err)
+	}
+	staging, err := resolveStagingDir(*stagingDir, *rootDir)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	// Initialise the underlying overlayfs snapshotter.
-	inner, err := overlay.NewSnapshotter(*rootDir)
+	// Initialise the underlying snapshotter.
+	inner, err := newInnerSnapshotter(*backend, *rootDir, backendOpts)
 	if err != nil {
-		log.Fatalf("create overlayfs snapshotter: %v", err)
+		log.Fatalf("%v", err)
 	}
 
 	// Wrap it with the clone-aware snapshotter.
 	sn := snapshotter.New(inner)
+	sn.CopyMethod = method
+	sn.StatCloneSize = *statCloneSize
+	sn.PostCloneHook = *postCloneHook
+	// An empty -ignore-files copies every file, where a nil list would
+	// mean the defaults.
+	sn.IgnoreFiles = []string{}
+	for _, p := range strings.Split(*ignoreFiles, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p != "" {
+			sn.IgnoreFiles = append(sn.IgnoreFiles, p)
+		}
+	}
+	sn.ArchiveDir = *archiveDir
+	sn.NamespaceQuotas = quotas
+	sn.MaxConcurrentClones = *maxConcurrentClones
+	sn.MaxOpenFiles = *maxOpenFiles
+	sn.StagingDir = staging
+	// No clone runs yet, so anything staged is left over from a crash.
+	if err := sn.SweepStaging(); err != nil {
+		log.Printf("sweep clone staging directory: %v", err)
+	}
 
-	// Build the gRPC snapshots service from the snapshotter.
-	service := snapshotservice.FromSnapshotter(sn)
-
-	// Listen on the Unix socket.
-	listener, err := net.Listen("unix", *socketPath)
+	// Listen on TCP, on the Unix socket, or take over the socket-activated one.
+	var listener net.Listener
+	var createdSocket bool
+	if *listenTCP != "" {
+		listener, err = net.Listen("tcp", *listenTCP)
+	} else {
+		listener, createdSocket, err = listen(*socketPath)
+	}
 	if err != nil {
-		log.Fatalf("listen on %q: %v", *socketPath, err)
+		log.Fatalf("%v", err)
+	}
+	if createdSocket {
+		// A socket-activated socket is systemd's to configure.
+		if err := applySocketPerms(*socketPath, socketPerms); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
-	// Register the service and start serving.
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(namespaceUnaryInterceptor))
-	snapshotsapi.RegisterSnapshotsServer(grpcServer, service)
+	// Build the gRPC server with the snapshots and health services.
+	grpcServer, healthServer := newServer(sn, serverOpts...)
 
-	// Graceful shutdown on SIGINT / SIGTERM.
+	// Graceful shutdown on SIGINT / SIGTERM.  Health checks report
+	// NOT_SERVING while in-flight clones drain, then the server stops and
+	// the snapshotter is closed.  The shutdown timeout bounds all three:
+	// clones still copying after it are abandoned.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	shutdown := make(chan struct{})
 	go func() {
+		defer close(shutdown)
 		sig := <-sigCh
 		log.Printf("received signal %v, shutting down", sig)
-		grpcServer.GracefulStop()
+		healthServer.Shutdown()
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := sn.Drain(ctx); err != nil {
+			log.Printf("in-flight clones did not finish within %v, stopping anyway", *shutdownTimeout)
+		}
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+			<-stopped
+		}
+		if err := sn.CloseContext(ctx); err != nil {
+			log.Printf("close snapshotter: %v", err)
+		}
 	}()
 
-	log.Printf("containerd-clone-snapshotter listening on %s (root: %s)", *socketPath, *rootDir)
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	logStartup(log.Default(), listener.Addr(), *backend, *rootDir, method)
+	var nofile unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &nofile); err != nil {
+		log.Printf("read open file limit: %v", err)
+	} else {
+		checkFileLimit(log.Default(), nofile, *maxConcurrentClones, *maxOpenFiles)
+	}
 	if err := grpcServer.Serve(listener); err != nil {
 		log.Printf("gRPC server stopped: %v", err)
+		if err := sn.Close(); err != nil {
+			log.Printf("close snapshotter: %v", err)
+		}
+		return
 	}
+	// Serve returns as soon as a shutdown starts; wait for it to finish.
+	<-shutdown
 }
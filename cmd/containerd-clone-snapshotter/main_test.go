@@ -0,0 +1,167 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+// startTestServer serves a clone snapshotter backed by the native snapshotter
+// on a Unix socket in a temporary directory and returns a client connection
+// to it, passing opts to the server.  The server is stopped when the test
+// finishes.
+func startTestServer(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	socketPath := serveTestSocket(t, opts...)
+	conn, err := grpc.Dial("unix://"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial %s: %v", socketPath, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// serveTestSocket serves a clone snapshotter backed by the native
+// snapshotter on a Unix socket in a temporary directory and returns the
+// socket's path, passing opts to the server.  The server is stopped when the
+// test finishes.
+func serveTestSocket(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+	dir := t.TempDir()
+	inner, err := native.NewSnapshotter(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	grpcServer, healthServer := newServer(snapshotter.New(inner), opts...)
+
+	socketPath := filepath.Join(dir, "plugin.sock")
+	l, _, err := listen(socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	go grpcServer.Serve(l)
+	t.Cleanup(grpcServer.Stop)
+	return socketPath
+}
+
+// TestServer_HealthCheck verifies that the health service is registered on
+// the plugin's socket and reports SERVING.
+func TestServer_HealthCheck(t *testing.T) {
+	conn := startTestServer(t)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health status = %v, want SERVING", resp.Status)
+	}
+}
+
+// TestServer_Reflection verifies that gRPC server reflection is served on the
+// plugin's socket and lists the snapshots service.
+func TestServer_Reflection(t *testing.T) {
+	conn := startTestServer(t)
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerReflectionInfo: %v", err)
+	}
+	defer stream.CloseSend()
+	if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatalf("send list services request: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("receive list services response: %v", err)
+	}
+
+	var services []string
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		services = append(services, svc.Name)
+		if svc.Name == "containerd.services.snapshots.v1.Snapshots" {
+			return
+		}
+	}
+	t.Errorf("reflection lists services %v, want the snapshots service among them", services)
+}
+
+// TestListen_SocketActivation verifies that a listener passed via the
+// systemd LISTEN_PID/LISTEN_FDS protocol is used instead of creating the
+// configured socket.
+func TestListen_SocketActivation(t *testing.T) {
+	dir := t.TempDir()
+	activated, err := net.Listen("unix", filepath.Join(dir, "activated.sock"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer activated.Close()
+	f, err := activated.(*net.UnixListener).File()
+	if err != nil {
+		t.Fatalf("listener file: %v", err)
+	}
+	defer f.Close()
+
+	prev := listenFdsStart
+	listenFdsStart = int(f.Fd())
+	defer func() { listenFdsStart = prev }()
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	fallback := filepath.Join(dir, "fallback.sock")
+	l, created, err := listen(fallback)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	if created {
+		t.Error("listen reported creating the socket-activated socket")
+	}
+
+	if got, want := l.Addr().String(), activated.Addr().String(); got != want {
+		t.Errorf("listener address = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(fallback); !os.IsNotExist(err) {
+		t.Errorf("fallback socket should not be created, stat err = %v", err)
+	}
+	if v := os.Getenv("LISTEN_FDS"); v != "" {
+		t.Errorf("LISTEN_FDS = %q, want it cleared", v)
+	}
+}
+
+// TestListen_NoActivation verifies that listen creates the Unix socket when
+// the process was not socket-activated, including when LISTEN_PID names a
+// different process.
+func TestListen_NoActivation(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	socketPath := filepath.Join(t.TempDir(), "sub", "plugin.sock")
+	l, created, err := listen(socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	if !created {
+		t.Error("listen did not report creating the socket")
+	}
+
+	if got := l.Addr().String(); got != socketPath {
+		t.Errorf("listener address = %q, want %q", got, socketPath)
+	}
+}
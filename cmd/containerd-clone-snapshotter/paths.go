@@ -0,0 +1,78 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// validatePath checks the path given for flag name before anything is
+// created or removed there.  The path must be absolute, must not contain
+// ".." elements, and, if prefixes is not empty, must be one of them or lie
+// below one of them.
+func validatePath(name, path string, prefixes []string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("-%s %q: path must be absolute", name, path)
+	}
+	for _, elem := range strings.Split(path, "/") {
+		if elem == ".." {
+			return fmt.Errorf("-%s %q: path must not contain \"..\"", name, path)
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	clean := filepath.Clean(path)
+	for _, prefix := range prefixes {
+		prefix = filepath.Clean(prefix)
+		if clean == prefix || strings.HasPrefix(clean, strings.TrimSuffix(prefix, "/")+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("-%s %q: path is not under any of the allowed prefixes %s", name, path, strings.Join(prefixes, ", "))
+}
+
+// splitPrefixes parses the comma-separated -allowed-prefixes value.
+func splitPrefixes(v string) []string {
+	var prefixes []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// stagingSubdir is the subdirectory of -root used for staging when
+// -staging-dir is not set.
+const stagingSubdir = "staging"
+
+// resolveStagingDir returns the clone staging directory for the
+// -staging-dir value dir, defaulting to a subdirectory of root, and creates
+// it.  The directory must be on root's filesystem so that staged data can be
+// moved into a snapshot by renaming it, which fails across filesystems.
+func resolveStagingDir(dir, root string) (string, error) {
+	if dir == "" {
+		dir = filepath.Join(root, stagingSubdir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("create staging directory: %w", err)
+	}
+
+	var rootSt, dirSt syscall.Stat_t
+	if err := syscall.Stat(root, &rootSt); err != nil {
+		return "", fmt.Errorf("stat root directory: %w", err)
+	}
+	if err := syscall.Stat(dir, &dirSt); err != nil {
+		return "", fmt.Errorf("stat staging directory: %w", err)
+	}
+	if rootSt.Dev != dirSt.Dev {
+		return "", fmt.Errorf("-staging-dir %q: must be on the same filesystem as -root %q", dir, root)
+	}
+	return dir, nil
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestValidatePath verifies that relative paths, paths with ".." elements
+// and paths outside the allowed prefixes are rejected.
+func TestValidatePath(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		prefixes []string
+		wantErr  string
+	}{
+		{path: "/run/clone/plugin.sock"},
+		{path: "/run/clone/plugin.sock", prefixes: []string{"/var/lib", "/run/clone"}},
+		{path: "/run/clone", prefixes: []string{"/run/clone/"}},
+		{path: "/", prefixes: []string{"/"}},
+		{path: "/anything/at/all", prefixes: []string{"/"}},
+		{path: "run/clone/plugin.sock", wantErr: "must be absolute"},
+		{path: "/run/clone/../../etc/shadow", wantErr: `must not contain ".."`},
+		{path: "/run/clone/..", prefixes: []string{"/run/clone"}, wantErr: `must not contain ".."`},
+		{path: "/etc/plugin.sock", prefixes: []string{"/run/clone"}, wantErr: "allowed prefixes"},
+		{path: "/run/clone-evil/plugin.sock", prefixes: []string{"/run/clone"}, wantErr: "allowed prefixes"},
+	} {
+		err := validatePath("socket", tc.path, tc.prefixes)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("validatePath(%q, %q): %v", tc.path, tc.prefixes, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("validatePath(%q, %q) = %v, want error containing %q", tc.path, tc.prefixes, err, tc.wantErr)
+		}
+	}
+}
+
+// TestSplitPrefixes verifies parsing of the -allowed-prefixes value.
+func TestSplitPrefixes(t *testing.T) {
+	got := splitPrefixes(" /run/clone, ,/var/lib/clone,")
+	if len(got) != 2 || got[0] != "/run/clone" || got[1] != "/var/lib/clone" {
+		t.Errorf("splitPrefixes = %q, want [/run/clone /var/lib/clone]", got)
+	}
+	if got := splitPrefixes(""); got != nil {
+		t.Errorf("splitPrefixes(\"\") = %q, want nil", got)
+	}
+}
+
+// TestResolveStagingDir verifies that staging defaults to a directory under
+// root and that a staging directory on another filesystem is rejected.
+func TestResolveStagingDir(t *testing.T) {
+	root := t.TempDir()
+
+	dir, err := resolveStagingDir("", root)
+	if err != nil {
+		t.Fatalf("resolveStagingDir default: %v", err)
+	}
+	if want := filepath.Join(root, "staging"); dir != want {
+		t.Errorf("default staging directory = %q, want %q", dir, want)
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		t.Errorf("staging directory not created: %v", err)
+	}
+
+	sameFS := filepath.Join(root, "other", "staging")
+	if dir, err := resolveStagingDir(sameFS, root); err != nil || dir != sameFS {
+		t.Errorf("resolveStagingDir(%q) = %q, %v", sameFS, dir, err)
+	}
+
+	other := t.TempDir()
+	if err := unix.Mount("tmpfs", other, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("mount tmpfs: %v", err)
+	}
+	defer unix.Unmount(other, 0)
+	if _, err := resolveStagingDir(other, root); err == nil || !strings.Contains(err.Error(), "same filesystem") {
+		t.Errorf("resolveStagingDir on another filesystem error = %v, want a same-filesystem error", err)
+	}
+}
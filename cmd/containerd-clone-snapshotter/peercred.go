@@ -0,0 +1,168 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/user"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// peerCredentials are the transport credentials of the Unix socket when
+// callers are restricted by -allowed-uids or -allowed-gids.  The handshake
+// adds no security of its own: it records the credentials of the connecting
+// process, read with SO_PEERCRED, for peerAuthorizer to check.
+type peerCredentials struct{}
+
+// peerInfo is the AuthInfo of a connection accepted with peerCredentials.
+type peerInfo struct {
+	credentials.CommonAuthInfo
+	cred unix.Ucred
+}
+
+// AuthType implements credentials.AuthInfo.
+func (peerInfo) AuthType() string { return "peercred" }
+
+// ServerHandshake reads the peer credentials of conn, which must be a Unix
+// socket connection.
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil, fmt.Errorf("peer credentials need a Unix socket connection, not %T", conn)
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, nil, err
+	}
+	if credErr != nil {
+		return nil, nil, fmt.Errorf("read peer credentials: %w", credErr)
+	}
+	info := peerInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}, cred: *cred}
+	return conn, info, nil
+}
+
+// ClientHandshake implements credentials.TransportCredentials; the
+// credentials are only used by the server.
+func (peerCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are server-side only")
+}
+
+// Info implements credentials.TransportCredentials.
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+// Clone implements credentials.TransportCredentials.
+func (peerCredentials) Clone() credentials.TransportCredentials { return peerCredentials{} }
+
+// OverrideServerName implements credentials.TransportCredentials.
+func (peerCredentials) OverrideServerName(string) error { return nil }
+
+// peerAuthorizer rejects calls from processes whose uid and gid are both
+// not allowed by -allowed-uids and -allowed-gids.
+type peerAuthorizer struct {
+	uids, gids map[uint32]bool
+}
+
+// parsePeerAuthorizer parses the -allowed-uids and -allowed-gids flag
+// values, comma-separated lists of user and group names or numeric IDs.  It
+// returns nil if both are empty, allowing every caller.
+func parsePeerAuthorizer(uids, gids string) (*peerAuthorizer, error) {
+	a := &peerAuthorizer{uids: map[uint32]bool{}, gids: map[uint32]bool{}}
+	for _, v := range strings.Split(uids, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		id, err := lookupID(v, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("-allowed-uids %q: %w", v, err)
+		}
+		a.uids[uint32(id)] = true
+	}
+	for _, v := range strings.Split(gids, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		id, err := lookupID(v, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("-allowed-gids %q: %w", v, err)
+		}
+		a.gids[uint32(id)] = true
+	}
+	if len(a.uids) == 0 && len(a.gids) == 0 {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// serverOptions returns the gRPC server options that read the peer
+// credentials of each connection and check them on every call.
+func (a *peerAuthorizer) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.Creds(peerCredentials{}),
+		grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(a.streamInterceptor),
+	}
+}
+
+// authorize returns a PermissionDenied error unless the caller of method,
+// whose connection is described by ctx, has an allowed uid or gid.
+func (a *peerAuthorizer) authorize(ctx context.Context, method string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "%s: no peer credentials", method)
+	}
+	info, ok := p.AuthInfo.(peerInfo)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "%s: no peer credentials", method)
+	}
+	if a.uids[info.cred.Uid] || a.gids[info.cred.Gid] {
+		return nil
+	}
+	log.Printf("rejected %s from pid %d, uid %d, gid %d", method, info.cred.Pid, info.cred.Uid, info.cred.Gid)
+	return status.Errorf(codes.PermissionDenied, "%s: uid %d and gid %d are not allowed", method, info.cred.Uid, info.cred.Gid)
+}
+
+func (a *peerAuthorizer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *peerAuthorizer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,99 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TestParsePeerAuthorizer verifies parsing of the -allowed-uids and
+// -allowed-gids values.
+func TestParsePeerAuthorizer(t *testing.T) {
+	if a, err := parsePeerAuthorizer("", " , "); err != nil || a != nil {
+		t.Errorf("parsePeerAuthorizer with no IDs = %v, %v, want nil", a, err)
+	}
+	a, err := parsePeerAuthorizer("root, 1000", "0")
+	if err != nil {
+		t.Fatalf("parsePeerAuthorizer: %v", err)
+	}
+	if want := map[uint32]bool{0: true, 1000: true}; !maps.Equal(a.uids, want) {
+		t.Errorf("uids = %v, want %v", a.uids, want)
+	}
+	if want := map[uint32]bool{0: true}; !maps.Equal(a.gids, want) {
+		t.Errorf("gids = %v, want %v", a.gids, want)
+	}
+	if _, err := parsePeerAuthorizer("no-such-user-here", ""); err == nil {
+		t.Error("parsePeerAuthorizer with an unknown user succeeded")
+	}
+	if _, err := parsePeerAuthorizer("", "no-such-group-here"); err == nil {
+		t.Error("parsePeerAuthorizer with an unknown group succeeded")
+	}
+}
+
+// TestPeerAuthorizer verifies the authorization decision for callers with
+// allowed and disallowed peer credentials.
+func TestPeerAuthorizer(t *testing.T) {
+	a := &peerAuthorizer{uids: map[uint32]bool{1000: true}, gids: map[uint32]bool{50: true}}
+	withCred := func(uid, gid uint32) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: peerInfo{cred: unix.Ucred{Uid: uid, Gid: gid}}})
+	}
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		allowed bool
+	}{
+		{"allowed uid", withCred(1000, 1000), true},
+		{"allowed gid", withCred(2000, 50), true},
+		{"disallowed", withCred(2000, 2000), false},
+		{"no peer", context.Background(), false},
+		{"no credentials", peer.NewContext(context.Background(), &peer.Peer{}), false},
+	} {
+		var called bool
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			return nil, nil
+		}
+		_, err := a.unaryInterceptor(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test/Method"}, handler)
+		if tc.allowed && (err != nil || !called) {
+			t.Errorf("%s: call rejected: %v", tc.name, err)
+		}
+		if !tc.allowed && (status.Code(err) != codes.PermissionDenied || called) {
+			t.Errorf("%s: error = %v, handler called %t, want PermissionDenied", tc.name, err, called)
+		}
+	}
+}
+
+// TestPeerAuthorizer_Socket verifies that the credentials of a process
+// connecting to the plugin's socket are read and checked.
+func TestPeerAuthorizer_Socket(t *testing.T) {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	for _, tc := range []struct {
+		name    string
+		a       *peerAuthorizer
+		allowed bool
+	}{
+		{"allowed", &peerAuthorizer{uids: map[uint32]bool{uid: true}}, true},
+		{"disallowed", &peerAuthorizer{uids: map[uint32]bool{uid + 1: true}, gids: map[uint32]bool{gid + 1: true}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := startTestServer(t, tc.a.serverOptions()...)
+			_, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+			if tc.allowed && err != nil {
+				t.Errorf("Check: %v", err)
+			}
+			if !tc.allowed && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("Check error = %v, want PermissionDenied", err)
+			}
+		})
+	}
+}
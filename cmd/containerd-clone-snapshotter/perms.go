@@ -0,0 +1,107 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// perms are the mode and ownership applied to the socket or root directory.
+// The zero value changes nothing.
+type perms struct {
+	mode     os.FileMode
+	setMode  bool
+	uid, gid int // -1 leaves the owner or group unchanged
+}
+
+// parsePerms parses the -<name>-mode and -<name>-owner flag values.  mode is
+// an octal permission such as "0660"; owner is "user", "user:group" or
+// ":group", where user and group are names or numeric IDs.  Empty values
+// leave the mode or ownership unchanged.
+func parsePerms(name, mode, owner string) (perms, error) {
+	p := perms{uid: -1, gid: -1}
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m&^uint64(os.ModePerm) != 0 {
+			return perms{}, fmt.Errorf("-%s-mode %q: want an octal permission such as 0660", name, mode)
+		}
+		p.mode, p.setMode = os.FileMode(m), true
+	}
+	if owner == "" {
+		return p, nil
+	}
+
+	u, g, _ := strings.Cut(owner, ":")
+	var err error
+	if u != "" {
+		if p.uid, err = lookupID(u, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return perms{}, fmt.Errorf("-%s-owner %q: %w", name, owner, err)
+		}
+	}
+	if g != "" {
+		if p.gid, err = lookupID(g, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return perms{}, fmt.Errorf("-%s-owner %q: %w", name, owner, err)
+		}
+	}
+	return p, nil
+}
+
+// lookupID returns v as a numeric ID, resolving it with lookup if it is a
+// name.
+func lookupID(v string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(v); err == nil && id >= 0 {
+		return id, nil
+	}
+	id, err := lookup(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// apply sets the mode and ownership of path.
+func (p perms) apply(path string) error {
+	if p.uid != -1 || p.gid != -1 {
+		if err := os.Chown(path, p.uid, p.gid); err != nil {
+			return err
+		}
+	}
+	if p.setMode {
+		if err := os.Chmod(path, p.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySocketPerms applies p to the Unix socket at path.  The socket's
+// directory gets the same owner so that it can reach the socket, but keeps
+// its mode.
+func applySocketPerms(path string, p perms) error {
+	dir := p
+	dir.setMode = false
+	if err := dir.apply(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("set socket directory permissions: %w", err)
+	}
+	if err := p.apply(path); err != nil {
+		return fmt.Errorf("set socket permissions: %w", err)
+	}
+	return nil
+}
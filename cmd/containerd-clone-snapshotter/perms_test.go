@@ -0,0 +1,79 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestApplySocketPerms verifies that the socket created by listen ends up
+// with the requested mode and owner.
+func TestApplySocketPerms(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "sub", "plugin.sock")
+	l, _, err := listen(socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	owner := strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	p, err := parsePerms("socket", "0660", owner)
+	if err != nil {
+		t.Fatalf("parsePerms: %v", err)
+	}
+	if err := applySocketPerms(socketPath, p); err != nil {
+		t.Fatalf("applySocketPerms: %v", err)
+	}
+
+	fi, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0660 {
+		t.Errorf("socket mode = %#o, want 0660", got)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		t.Errorf("socket mode = %v, want a socket", fi.Mode())
+	}
+	dir, err := os.Stat(filepath.Dir(socketPath))
+	if err != nil {
+		t.Fatalf("stat socket directory: %v", err)
+	}
+	if got := dir.Mode().Perm(); got != 0700 {
+		t.Errorf("socket directory mode = %#o, want it left at 0700", got)
+	}
+}
+
+// TestParsePerms verifies the accepted -*-mode and -*-owner values.
+func TestParsePerms(t *testing.T) {
+	for _, tc := range []struct {
+		mode, owner string
+		want        perms
+		wantErr     string
+	}{
+		{want: perms{uid: -1, gid: -1}},
+		{mode: "0660", want: perms{mode: 0660, setMode: true, uid: -1, gid: -1}},
+		{mode: "755", want: perms{mode: 0755, setMode: true, uid: -1, gid: -1}},
+		{owner: "0", want: perms{uid: 0, gid: -1}},
+		{owner: "1000:1001", want: perms{uid: 1000, gid: 1001}},
+		{owner: ":1001", want: perms{uid: -1, gid: 1001}},
+		{owner: "root:root", want: perms{uid: 0, gid: 0}},
+		{mode: "rw-rw----", wantErr: "octal permission"},
+		{mode: "04755", wantErr: "octal permission"},
+		{owner: "no-such-user-xyz", wantErr: "-socket-owner"},
+	} {
+		got, err := parsePerms("socket", tc.mode, tc.owner)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("parsePerms(%q, %q): %v", tc.mode, tc.owner, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("parsePerms(%q, %q) error = %v, want it to contain %q", tc.mode, tc.owner, err, tc.wantErr)
+		case tc.wantErr == "" && got != tc.want:
+			t.Errorf("parsePerms(%q, %q) = %+v, want %+v", tc.mode, tc.owner, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// servePprof starts an HTTP server exposing the net/http/pprof handlers under
+// /debug/pprof/ on addr.  The listener is bound before servePprof returns so
+// that address errors are reported at startup; requests are served in the
+// background.  The returned address is the one actually bound, which matters
+// when addr uses port 0.
+func servePprof(addr string) (net.Addr, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen for pprof on %q: %w", addr, err)
+	}
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Printf("pprof server stopped: %v", err)
+		}
+	}()
+	return l.Addr(), nil
+}
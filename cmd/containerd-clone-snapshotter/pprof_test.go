@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestServePprof verifies that the pprof index is served on the requested
+// address.
+func TestServePprof(t *testing.T) {
+	addr, err := servePprof("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("servePprof: %v", err)
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/debug/pprof/")
+	if err != nil {
+		t.Fatalf("GET /debug/pprof/: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "goroutine") {
+		t.Errorf("pprof index does not list the goroutine profile:\n%s", body)
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/identifiers"
+)
+
+// parseQuotas parses the -namespace-quotas value, a comma-separated list of
+// namespace=bytes entries, into a quota by namespace.  An empty value means
+// no quotas.
+func parseQuotas(v string) (map[string]int64, error) {
+	quotas := map[string]int64{}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ns, bytes, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("-namespace-quotas entry %q: want namespace=bytes", entry)
+		}
+		if err := identifiers.Validate(ns); err != nil {
+			return nil, fmt.Errorf("-namespace-quotas entry %q: %w", entry, err)
+		}
+		if _, dup := quotas[ns]; dup {
+			return nil, fmt.Errorf("-namespace-quotas: namespace %q given twice", ns)
+		}
+		n, err := strconv.ParseInt(bytes, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("-namespace-quotas entry %q: bytes must be a non-negative integer", entry)
+		}
+		quotas[ns] = n
+	}
+	return quotas, nil
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+// TestParseQuotas verifies parsing of the -namespace-quotas value.
+func TestParseQuotas(t *testing.T) {
+	got, err := parseQuotas(" tenant-a=1000, ,k8s.io=0,")
+	if err != nil {
+		t.Fatalf("parseQuotas: %v", err)
+	}
+	if want := map[string]int64{"tenant-a": 1000, "k8s.io": 0}; !maps.Equal(got, want) {
+		t.Errorf("parseQuotas = %v, want %v", got, want)
+	}
+	if got, err := parseQuotas(""); err != nil || len(got) != 0 {
+		t.Errorf("parseQuotas(\"\") = %v, %v, want no quotas", got, err)
+	}
+	for _, v := range []string{"tenant", "tenant=", "tenant=-1", "tenant=1k", "=10", "bad/ns=10", "a=1,a=2"} {
+		if _, err := parseQuotas(v); err == nil {
+			t.Errorf("parseQuotas(%q) succeeded", v)
+		}
+	}
+}
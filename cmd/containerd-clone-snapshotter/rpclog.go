@@ -0,0 +1,101 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// rpcLogLevel selects the snapshots service calls logged by rpcLogger.
+type rpcLogLevel int
+
+const (
+	rpcLogOff    rpcLogLevel = iota // log nothing
+	rpcLogErrors                    // log failed calls
+	rpcLogAll                       // log every call
+)
+
+// parseRPCLogLevel parses the -log-rpcs flag value: off, errors or all.
+func parseRPCLogLevel(v string) (rpcLogLevel, error) {
+	switch v {
+	case "off", "":
+		return rpcLogOff, nil
+	case "errors":
+		return rpcLogErrors, nil
+	case "all":
+		return rpcLogAll, nil
+	}
+	return 0, fmt.Errorf("-log-rpcs %q: want off, errors or all", v)
+}
+
+// rpcLogger logs calls to the snapshots service with their duration, peer
+// and error, for auditing and debugging.  Calls to other services, such as
+// health checks, are not logged.
+type rpcLogger struct {
+	l     *log.Logger
+	level rpcLogLevel
+}
+
+// serverOptions returns the gRPC server options that log calls.
+func (r *rpcLogger) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(r.unaryInterceptor),
+		grpc.ChainStreamInterceptor(r.streamInterceptor),
+	}
+}
+
+func (r *rpcLogger) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	r.log(ctx, info.FullMethod, req, time.Since(start), err)
+	return resp, err
+}
+
+func (r *rpcLogger) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	r.log(ss.Context(), info.FullMethod, nil, time.Since(start), err)
+	return err
+}
+
+// log logs the call of method with request req, if any, that took d and
+// failed with err, if its level calls for it.  A Prepare that clones is
+// logged with its clone source.
+func (r *rpcLogger) log(ctx context.Context, method string, req interface{}, d time.Duration, err error) {
+	if r.level == rpcLogOff || r.level == rpcLogErrors && err == nil {
+		return
+	}
+	if !strings.HasPrefix(method, "/"+snapshotsapi.Snapshots_ServiceDesc.ServiceName+"/") {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "rpc %s duration=%v", method, d)
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(peerInfo); ok {
+			fmt.Fprintf(&b, " peer=pid:%d,uid:%d,gid:%d", info.cred.Pid, info.cred.Uid, info.cred.Gid)
+		} else if p.Addr != nil && p.Addr.String() != "" {
+			fmt.Fprintf(&b, " peer=%s", p.Addr)
+		}
+	}
+	if k, ok := req.(interface{ GetKey() string }); ok {
+		fmt.Fprintf(&b, " key=%q", k.GetKey())
+	}
+	if prepare, ok := req.(*snapshotsapi.PrepareSnapshotRequest); ok {
+		if source, ok := prepare.Labels[snapshotter.LabelCloneSource]; ok {
+			fmt.Fprintf(&b, " clone-source=%q", source)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(&b, " error=%q", err)
+	}
+	r.l.Print(b.String())
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// TestParseRPCLogLevel verifies parsing of the -log-rpcs value.
+func TestParseRPCLogLevel(t *testing.T) {
+	for v, want := range map[string]rpcLogLevel{"": rpcLogOff, "off": rpcLogOff, "errors": rpcLogErrors, "all": rpcLogAll} {
+		if got, err := parseRPCLogLevel(v); err != nil || got != want {
+			t.Errorf("parseRPCLogLevel(%q) = %v, %v, want %v", v, got, err, want)
+		}
+	}
+	if _, err := parseRPCLogLevel("debug"); err == nil {
+		t.Error("parseRPCLogLevel(\"debug\") succeeded")
+	}
+}
+
+// TestRPCLogger verifies that snapshots service calls made through the
+// plugin's socket are logged with their method and duration, clones with
+// their source, and that other services are not logged.
+func TestRPCLogger(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := &rpcLogger{l: log.New(&buf, "", 0), level: rpcLogAll}
+	conn := startTestServer(t, logger.serverOptions()...)
+	client := snapshotsapi.NewSnapshotsClient(conn)
+
+	if _, err := client.Prepare(ctx, &snapshotsapi.PrepareSnapshotRequest{Snapshotter: "clone", Key: "source"}); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if _, err := client.Prepare(ctx, &snapshotsapi.PrepareSnapshotRequest{
+		Snapshotter: "clone",
+		Key:         "clone",
+		Labels:      map[string]string{snapshotter.LabelCloneSource: "source"},
+	}); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if _, err := client.Stat(ctx, &snapshotsapi.StatSnapshotRequest{Snapshotter: "clone", Key: "missing"}); err == nil {
+		t.Fatal("Stat of a missing snapshot succeeded")
+	}
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("logged %d lines, want 3:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []string{
+		`^rpc /containerd.services.snapshots.v1.Snapshots/Prepare duration=\S+ .*key="source"$`,
+		`^rpc /containerd.services.snapshots.v1.Snapshots/Prepare duration=\S+ .*key="clone" clone-source="source"$`,
+		`^rpc /containerd.services.snapshots.v1.Snapshots/Stat duration=\S+ .*key="missing" error=".*not found.*"$`,
+	} {
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("line %d = %q, want a match for %s", i, lines[i], want)
+		}
+	}
+
+	// Only failures are logged at the errors level.
+	buf.Reset()
+	logger.level = rpcLogErrors
+	if _, err := client.Stat(ctx, &snapshotsapi.StatSnapshotRequest{Snapshotter: "clone", Key: "source"}); err != nil {
+		t.Fatalf("Stat source: %v", err)
+	}
+	if _, err := client.Stat(ctx, &snapshotsapi.StatSnapshotRequest{Snapshotter: "clone", Key: "missing"}); err == nil {
+		t.Fatal("Stat of a missing snapshot succeeded")
+	}
+	if out := buf.String(); strings.Count(out, "\n") != 1 || !strings.Contains(out, `key="missing"`) {
+		t.Errorf("errors level logged %q, want only the failed Stat", out)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA is a throwaway certificate authority for TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA for the given
+// extended key usage.
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// startTLSServer serves the plugin over TCP with mutual TLS using a server
+// certificate issued by ca and trusting client certificates issued by ca.
+func startTLSServer(t *testing.T, ca *testCA) string {
+	t.Helper()
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	files := map[string][]byte{"server.pem": certPEM, "server-key.pem": keyPEM, "ca.pem": ca.pem}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	tlsConfig, err := serverTLSConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("serverTLSConfig: %v", err)
+	}
+	inner, err := native.NewSnapshotter(filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	grpcServer, healthServer := newServer(snapshotter.New(inner), grpc.Creds(credentials.NewTLS(tlsConfig)))
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go grpcServer.Serve(l)
+	t.Cleanup(grpcServer.Stop)
+	return l.Addr().String()
+}
+
+// checkHealthTLS calls the health service at addr presenting a client
+// certificate issued by clientCA and trusting serverCA.
+func checkHealthTLS(t *testing.T, addr string, serverCA, clientCA *testCA) error {
+	t.Helper()
+	certPEM, keyPEM := clientCA.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("client key pair: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCA.cert)
+
+	creds := credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots})
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+// TestServer_MutualTLS verifies that a client with a certificate from the
+// trusted CA is served over TCP while a client with an untrusted certificate
+// is rejected.
+func TestServer_MutualTLS(t *testing.T) {
+	ca := newTestCA(t, "trusted-ca")
+	addr := startTLSServer(t, ca)
+
+	if err := checkHealthTLS(t, addr, ca, ca); err != nil {
+		t.Fatalf("Check with trusted client certificate: %v", err)
+	}
+
+	rogue := newTestCA(t, "rogue-ca")
+	if err := checkHealthTLS(t, addr, ca, rogue); err == nil {
+		t.Fatal("Check with untrusted client certificate succeeded, want rejection")
+	}
+}
+
+// TestServerTLSConfig_RequiresAllFiles verifies that TCP listening refuses to
+// start without the full set of TLS files.
+func TestServerTLSConfig_RequiresAllFiles(t *testing.T) {
+	if _, err := serverTLSConfig("server.pem", "server-key.pem", ""); err == nil {
+		t.Fatal("expected error without a client CA, got nil")
+	}
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// validateOptions are the settings -validate checks.
+type validateOptions struct {
+	// socket is the Unix socket path, or "" when serving over TCP.
+	socket      string
+	root        string
+	staging     string
+	backend     string
+	backendOpts backendOptions
+	method      snapshotter.CopyMethod
+}
+
+// validate runs the startup checks for opts without serving and without
+// leaving anything behind: it checks that the root directory and the socket
+// can be created, creates the backend and probes the filesystem's
+// capabilities in a scratch directory next to where root will be, and
+// removes what it created.  The real socket is never bound.  It writes a
+// line per check to w and returns an error if any check failed.
+func validate(w io.Writer, opts validateOptions) error {
+	var failed int
+	report := func(name, detail string, err error) {
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "ok    %s: %s\n", name, detail)
+	}
+	done := func() error {
+		if failed > 0 {
+			return fmt.Errorf("%d startup checks failed", failed)
+		}
+		fmt.Fprintln(w, "all startup checks passed")
+		return nil
+	}
+
+	// The scratch directory stands in for root: it is created where root
+	// is, or in the closest directory that exists on the way to it.
+	scratch, err := scratchDir(opts.root)
+	report("root", "can be created at "+opts.root, err)
+	if err != nil {
+		return done()
+	}
+	defer os.RemoveAll(scratch)
+
+	if opts.socket != "" {
+		report("socket", "can be created at "+opts.socket, probeSocket(opts.socket))
+	}
+
+	if opts.staging != "" {
+		report("staging-dir", "is on the filesystem of -root", sameFilesystem(opts.staging, scratch))
+	}
+
+	report("backend", opts.backend+" snapshotter works", probeBackend(opts.backend, filepath.Join(scratch, "backend"), opts.backendOpts))
+
+	fs, err := filesystemName(scratch)
+	if err == nil {
+		var reflink bool
+		if reflink, err = reflinkSupported(scratch); err == nil {
+			report("filesystem", fmt.Sprintf("%s, reflink supported: %t", fs, reflink), nil)
+			if opts.method == snapshotter.CopyMethodReflink && !reflink {
+				fmt.Fprintf(w, "warning: -copy-method %s is not supported by %s; clones will copy file data instead\n", opts.method, fs)
+			}
+		}
+	}
+	if err != nil {
+		report("filesystem", "", err)
+	}
+	return done()
+}
+
+// scratchDir creates a scratch directory in the directory dir or, if dir
+// does not exist yet, in the closest existing directory above it.
+func scratchDir(dir string) (string, error) {
+	parent, err := existingAncestor(dir)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, ".clone-validate-")
+}
+
+// existingAncestor returns path if it is an existing directory, or else
+// its closest existing ancestor, which must be a directory.
+func existingAncestor(path string) (string, error) {
+	for {
+		fi, err := os.Stat(path)
+		if err == nil {
+			if !fi.IsDir() {
+				return "", fmt.Errorf("%s is not a directory", path)
+			}
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || path == filepath.Dir(path) {
+			return "", err
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// probeSocket checks that a Unix socket can be bound next to the socket
+// path, by binding and closing a scratch socket in its directory or the
+// closest existing directory above it.
+func probeSocket(path string) error {
+	dir, err := existingAncestor(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, fmt.Sprintf(".clone-validate-%d.sock", os.Getpid())))
+	if err != nil {
+		return err
+	}
+	// Closing the listener removes the socket file.
+	return l.Close()
+}
+
+// probeBackend creates the backend snapshotter rooted at root with opts and
+// prepares and removes a snapshot with it.
+func probeBackend(backend, root string, opts backendOptions) error {
+	sn, err := newInnerSnapshotter(backend, root, opts)
+	if err != nil {
+		return err
+	}
+	defer sn.Close()
+
+	ctx := context.Background()
+	if _, err := sn.Prepare(ctx, "validate", ""); err != nil {
+		return fmt.Errorf("prepare snapshot: %w", err)
+	}
+	if err := sn.Remove(ctx, "validate"); err != nil {
+		return fmt.Errorf("remove snapshot: %w", err)
+	}
+	return nil
+}
+
+// sameFilesystem checks that dir, or the closest existing directory above
+// it, is on the filesystem of root.
+func sameFilesystem(dir, root string) error {
+	existing, err := existingAncestor(dir)
+	if err != nil {
+		return err
+	}
+	var rootSt, dirSt syscall.Stat_t
+	if err := syscall.Stat(root, &rootSt); err != nil {
+		return fmt.Errorf("stat root directory: %w", err)
+	}
+	if err := syscall.Stat(existing, &dirSt); err != nil {
+		return fmt.Errorf("stat staging directory: %w", err)
+	}
+	if rootSt.Dev != dirSt.Dev {
+		return fmt.Errorf("-staging-dir %q: must be on the same filesystem as -root", dir)
+	}
+	return nil
+}
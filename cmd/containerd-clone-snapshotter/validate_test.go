@@ -0,0 +1,60 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestValidate verifies that -validate reports success for a usable
+// environment without creating the root directory or the socket, and
+// reports the checks that fail.
+func TestValidate(t *testing.T) {
+	base := t.TempDir()
+	opts := validateOptions{
+		socket:  filepath.Join(base, "run", "plugin.sock"),
+		root:    filepath.Join(base, "lib", "root"),
+		backend: "native",
+		method:  snapshotter.CopyMethodCopy,
+	}
+
+	var buf bytes.Buffer
+	if err := validate(&buf, opts); err != nil {
+		t.Fatalf("validate: %v\n%s", err, buf.String())
+	}
+	out := buf.String()
+	for _, want := range []string{"ok    root:", "ok    socket:", "ok    backend:", "ok    filesystem:", "all startup checks passed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatalf("read %s: %v", base, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("validate left %d entries behind in %s", len(entries), base)
+	}
+
+	// A root below a regular file and an unknown backend cannot work.
+	if err := os.WriteFile(filepath.Join(base, "file"), nil, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	buf.Reset()
+	opts.root = filepath.Join(base, "file", "root")
+	if err := validate(&buf, opts); err == nil || !strings.Contains(buf.String(), "FAIL  root:") {
+		t.Errorf("validate with root below a file = %v, report:\n%s", err, buf.String())
+	}
+	buf.Reset()
+	opts.root = filepath.Join(base, "lib", "root")
+	opts.backend = "nonexistent"
+	if err := validate(&buf, opts); err == nil || !strings.Contains(buf.String(), "FAIL  backend:") {
+		t.Errorf("validate with unknown backend = %v, report:\n%s", err, buf.String())
+	}
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// buildVersion returns the module version the binary was built from, or
+// "(devel)" when it carries none, e.g. for a plain go build in a checkout.
+func buildVersion(info *debug.BuildInfo) string {
+	if info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// writeVersion writes the build's module version, Go version and VCS commit
+// to w, one per line, as printed by -version.
+func writeVersion(w io.Writer) error {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		_, err := fmt.Fprintf(w, "containerd-clone-snapshotter (no build info)\ngo: %s\n", runtime.Version())
+		return err
+	}
+
+	commit, modified := "unknown", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			commit = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified {
+		commit += " (modified)"
+	}
+	_, err := fmt.Fprintf(w, "containerd-clone-snapshotter %s\ngo: %s\ncommit: %s\n",
+		buildVersion(info), info.GoVersion, commit)
+	return err
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// TestWriteVersion verifies that the -version output reports the build's
+// module version and Go version.
+func TestWriteVersion(t *testing.T) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		t.Skip("binary has no build info")
+	}
+
+	var buf bytes.Buffer
+	if err := writeVersion(&buf); err != nil {
+		t.Fatalf("writeVersion: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"containerd-clone-snapshotter " + buildVersion(info) + "\n",
+		"go: " + runtime.Version() + "\n",
+		"commit: ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("version output %q does not contain %q", out, want)
+		}
+	}
+}
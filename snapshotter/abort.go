@@ -0,0 +1,64 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// errCloneAborted is the cancellation cause of a clone stopped by
+// AbortClone.
+var errCloneAborted = errors.New("clone aborted")
+
+// activeClone is the registration of a clone in progress.
+type activeClone struct {
+	cancel context.CancelCauseFunc
+}
+
+// AbortClone cancels the clone in progress into the snapshot key in the
+// namespace of ctx.  The clone stops copying, its snapshot is removed and
+// the Prepare call that started it fails with an error matching
+// context.Canceled.  AbortClone returns without waiting for that; it fails
+// with [errdefs.ErrNotFound] if no clone into key is in progress.
+func (s *CloneSnapshotter) AbortClone(ctx context.Context, key string) error {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	a, ok := s.active[refKey(ctx, key)]
+	if !ok {
+		return fmt.Errorf("no clone into %q in progress: %w", key, errdefs.ErrNotFound)
+	}
+	a.cancel(errCloneAborted)
+	return nil
+}
+
+// registerClone makes the clone into key, running under ctx, abortable
+// through AbortClone.  It returns the context the clone must use and a
+// function to call once it has finished.  If another clone into key is
+// already in progress, the new one is not registered: it is bound to fail
+// when it prepares its snapshot.
+func (s *CloneSnapshotter) registerClone(ctx context.Context, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	k := refKey(ctx, key)
+	a := &activeClone{cancel: cancel}
+
+	s.activeMu.Lock()
+	if s.active == nil {
+		s.active = map[string]*activeClone{}
+	}
+	registered := s.active[k] == nil
+	if registered {
+		s.active[k] = a
+	}
+	s.activeMu.Unlock()
+
+	return ctx, func() {
+		if registered {
+			s.activeMu.Lock()
+			delete(s.active, k)
+			s.activeMu.Unlock()
+		}
+		cancel(nil)
+	}
+}
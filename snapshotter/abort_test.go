@@ -0,0 +1,75 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestAbortClone verifies that AbortClone stops a clone in progress, which
+// then fails with context.Canceled and leaves no destination snapshot
+// behind.
+func TestAbortClone(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	copying := make(chan struct{})
+	sn.copyHook = func(ctx context.Context, _ string) error {
+		// Simulate a slow copy that only ends when cancelled.
+		close(copying)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	if err := sn.AbortClone(ctx, "clone"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("AbortClone before the clone started = %v, want ErrNotFound", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+			LabelCloneSource: "source",
+		}))
+		done <- err
+	}()
+	<-copying
+	if err := sn.AbortClone(ctx, "clone"); err != nil {
+		t.Fatalf("AbortClone: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Prepare clone error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("aborted clone did not return")
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("aborted clone snapshot was not removed")
+	}
+	if err := sn.AbortClone(ctx, "clone"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("AbortClone after the clone ended = %v, want ErrNotFound", err)
+	}
+}
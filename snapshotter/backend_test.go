@@ -0,0 +1,94 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// btrfsSnapshotter is a stub backend whose snapshots mount as btrfs
+// subvolumes, a mount type the clone logic does not understand.
+type btrfsSnapshotter struct {
+	snapshots.Snapshotter
+	prepared []string
+}
+
+func (b *btrfsSnapshotter) Stat(_ context.Context, key string) (snapshots.Info, error) {
+	return snapshots.Info{Name: key, Kind: snapshots.KindActive}, nil
+}
+
+func (b *btrfsSnapshotter) Mounts(_ context.Context, key string) ([]mount.Mount, error) {
+	return []mount.Mount{{Type: "btrfs", Source: "/dev/sdb", Options: []string{"subvol=" + key}}}, nil
+}
+
+func (b *btrfsSnapshotter) Prepare(_ context.Context, key, _ string, _ ...snapshots.Opt) ([]mount.Mount, error) {
+	b.prepared = append(b.prepared, key)
+	return b.Mounts(context.Background(), key)
+}
+
+// TestPrepare_Clone_UnsupportedBackend verifies that cloning on a backend
+// with unknown mount types fails with a descriptive error and without
+// preparing the destination snapshot.
+func TestPrepare_Clone_UnsupportedBackend(t *testing.T) {
+	inner := &btrfsSnapshotter{}
+	sn := snapshotter.New(inner)
+
+	_, err := sn.Prepare(context.Background(), "clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "source",
+		}),
+	)
+	if !errors.Is(err, snapshotter.ErrUnsupportedBackend) {
+		t.Fatalf("Prepare error = %v, want ErrUnsupportedBackend", err)
+	}
+	if !strings.Contains(err.Error(), "btrfs") {
+		t.Errorf("error %q does not name the btrfs mount type", err)
+	}
+	if len(inner.prepared) != 0 {
+		t.Errorf("inner Prepare called for %v, want no calls", inner.prepared)
+	}
+
+	// Regular Prepare calls are still delegated.
+	if _, err := sn.Prepare(context.Background(), "plain", ""); err != nil {
+		t.Fatalf("Prepare without clone label: %v", err)
+	}
+}
+
+// mountlessSnapshotter is a stub backend whose snapshots have no mounts.
+type mountlessSnapshotter struct {
+	btrfsSnapshotter
+}
+
+func (m *mountlessSnapshotter) Mounts(context.Context, string) ([]mount.Mount, error) {
+	return nil, nil
+}
+
+// TestPrepare_Clone_NoMounts verifies that a source without mounts fails
+// with ErrNoMounts naming the source, not with ErrUnsupportedBackend.
+func TestPrepare_Clone_NoMounts(t *testing.T) {
+	inner := &mountlessSnapshotter{}
+	sn := snapshotter.New(inner)
+
+	_, err := sn.Prepare(context.Background(), "clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "source",
+		}),
+	)
+	if !errors.Is(err, snapshotter.ErrNoMounts) || errors.Is(err, snapshotter.ErrUnsupportedBackend) {
+		t.Fatalf("Prepare error = %v, want ErrNoMounts", err)
+	}
+	if !strings.Contains(err.Error(), `"source"`) {
+		t.Errorf("error %q does not name the source snapshot", err)
+	}
+	if len(inner.prepared) != 0 {
+		t.Errorf("inner Prepare called for %v, want no calls", inner.prepared)
+	}
+	if _, err := sn.WritableDir(context.Background(), "source"); !errors.Is(err, snapshotter.ErrNoMounts) {
+		t.Errorf("WritableDir error = %v, want ErrNoMounts", err)
+	}
+}
@@ -0,0 +1,83 @@
+package snapshotter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// CloneResult is the outcome of one clone created by
+// [CloneSnapshotter.CloneMany].
+type CloneResult struct {
+	// Mounts are the mounts of the new snapshot, as returned by Prepare.
+	Mounts []mount.Mount
+
+	// Err is the reason the clone could not be created, or nil.
+	Err error
+}
+
+// transformingLabels are the labels under which a clone's content is not a
+// plain copy of its source's, so that copying one clone from another would
+// apply them twice.  IgnoreFiles are left out of every clone alike, so
+// copying from a clone that lacks them gives the same result.
+var transformingLabels = []string{
+	LabelCloneExclude,
+	LabelClonePathMap,
+	LabelCloneFitBudget,
+	LabelCloneMetadataOnly,
+}
+
+// CloneMany prepares one clone of sourceKey for each of destKeys, as if
+// Prepare had been called for each key with opts and the clone-source label
+// set to sourceKey.  As with Prepare, ${key} in sourceKey is replaced with
+// the key of each clone.
+//
+// The source's writable layer is read only once: the first clone to succeed
+// is copied from the source, and every later one from that clone, sharing
+// its file extents through reflinks where the filesystem supports them.
+// When the clones do not hold a plain copy of the source, because opts set
+// one of [transformingLabels] or a PostCloneHook is configured, every clone
+// is copied from the source instead, so that the transformation is applied
+// once.  Every key is attempted regardless of earlier failures.  The error
+// is only non-nil if opts cannot be applied, in which case nothing is
+// created.
+func (s *CloneSnapshotter) CloneMany(ctx context.Context, sourceKey string, destKeys []string, opts ...snapshots.Opt) (map[string]CloneResult, error) {
+	info := snapshots.Info{}
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+	labels := map[string]string{}
+	for k, v := range info.Labels {
+		labels[k] = v
+	}
+	labels[LabelCloneSource] = sourceKey
+
+	// A source naming each clone's own key is a different snapshot for
+	// every clone, so no clone can be copied from another.
+	share := s.PostCloneHook == "" && !strings.Contains(sourceKey, sourceKeyVar)
+	for _, l := range transformingLabels {
+		if _, ok := labels[l]; ok {
+			share = false
+		}
+	}
+
+	results := make(map[string]CloneResult, len(destKeys))
+	var from string
+	for _, key := range destKeys {
+		mounts, err := s.clonePrepare(ctx, key, expandCloneSource(labels, key), opts, from)
+		results[key] = CloneResult{Mounts: mounts, Err: err}
+		if err == nil && share && from == "" {
+			// A clone that succeeded has writable mounts, so this cannot
+			// fail; if it somehow does, or the clone has several writable
+			// directories, keep copying from the source.
+			if dirs, _ := getWritableDirs(mounts); len(dirs) == 1 {
+				from = dirs[0]
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,176 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestCloneMany verifies that every clone created from one source is a
+// complete copy of it and independent of the source and of the other
+// clones.
+func TestCloneMany(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "golden", ""); err != nil {
+		t.Fatalf("Prepare golden: %v", err)
+	}
+	srcDir := writableDir(t, sn, "golden")
+	if err := os.MkdirAll(filepath.Join(srcDir, "etc"), 0755); err != nil {
+		t.Fatalf("mkdir etc: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "etc", "app.conf"), []byte("golden"), 0644); err != nil {
+		t.Fatalf("write app.conf: %v", err)
+	}
+	if err := os.Symlink("etc/app.conf", filepath.Join(srcDir, "conf")); err != nil {
+		t.Fatalf("symlink conf: %v", err)
+	}
+
+	keys := []string{"replica-0", "replica-1", "replica-2"}
+	results, err := sn.CloneMany(ctx, "golden", keys,
+		snapshots.WithLabels(map[string]string{"app": "web"}))
+	if err != nil {
+		t.Fatalf("CloneMany: %v", err)
+	}
+	if len(results) != len(keys) {
+		t.Fatalf("CloneMany returned %d results, want %d", len(results), len(keys))
+	}
+	for _, key := range keys {
+		res := results[key]
+		if res.Err != nil {
+			t.Fatalf("clone %s: %v", key, res.Err)
+		}
+		if len(res.Mounts) == 0 {
+			t.Errorf("clone %s: no mounts", key)
+		}
+		info, err := sn.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat %s: %v", key, err)
+		}
+		if info.Labels["app"] != "web" {
+			t.Errorf("clone %s: label app = %q, want %q", key, info.Labels["app"], "web")
+		}
+		dir := writableDir(t, sn, key)
+		assertFileContent(t, filepath.Join(dir, "etc"), "app.conf", "golden")
+		if target, err := os.Readlink(filepath.Join(dir, "conf")); err != nil || target != "etc/app.conf" {
+			t.Errorf("clone %s: readlink conf = %q, %v", key, target, err)
+		}
+	}
+
+	// Writing to one clone must not leak into the source or its siblings.
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "replica-0"), "etc", "app.conf"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("modify replica-0: %v", err)
+	}
+	assertFileContent(t, filepath.Join(srcDir, "etc"), "app.conf", "golden")
+	for _, key := range keys[1:] {
+		assertFileContent(t, filepath.Join(writableDir(t, sn, key), "etc"), "app.conf", "golden")
+	}
+}
+
+// TestCloneMany_PerKeyErrors verifies that a destination that cannot be
+// created is reported in its result without affecting the others.
+func TestCloneMany_PerKeyErrors(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "golden", ""); err != nil {
+		t.Fatalf("Prepare golden: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "golden"), "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "taken", ""); err != nil {
+		t.Fatalf("Prepare taken: %v", err)
+	}
+
+	results, err := sn.CloneMany(ctx, "golden", []string{"taken", "fresh"})
+	if err != nil {
+		t.Fatalf("CloneMany: %v", err)
+	}
+	if results["taken"].Err == nil {
+		t.Error("clone into existing key \"taken\" succeeded, want an error")
+	}
+	if err := results["fresh"].Err; err != nil {
+		t.Fatalf("clone fresh: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "fresh"), "data.txt", "data")
+}
+
+// TestCloneMany_PathMap verifies that a path map is applied once to every
+// clone, rather than again to clones copied from an earlier one.
+func TestCloneMany_PathMap(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "golden", ""); err != nil {
+		t.Fatalf("Prepare golden: %v", err)
+	}
+	srcDir := writableDir(t, sn, "golden")
+	if err := os.MkdirAll(filepath.Join(srcDir, "a"), 0755); err != nil {
+		t.Fatalf("mkdir a: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a", "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write a/file.txt: %v", err)
+	}
+
+	keys := []string{"c1", "c2"}
+	results, err := sn.CloneMany(ctx, "golden", keys,
+		snapshots.WithLabels(map[string]string{snapshotter.LabelClonePathMap: "a=b"}))
+	if err != nil {
+		t.Fatalf("CloneMany: %v", err)
+	}
+	for _, key := range keys {
+		if err := results[key].Err; err != nil {
+			t.Fatalf("clone %s: %v", key, err)
+		}
+		dir := writableDir(t, sn, key)
+		assertFileContent(t, filepath.Join(dir, "b"), "file.txt", "data")
+		if _, err := os.Lstat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+			t.Errorf("clone %s: a was copied: %v", key, err)
+		}
+	}
+}
+
+// TestCloneMany_TemplatedSource verifies that ${key} in the source expands
+// to the key of each clone, as it does for Prepare.
+func TestCloneMany_TemplatedSource(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	keys := []string{"web", "db"}
+	for _, key := range keys {
+		if _, err := sn.Prepare(ctx, key+"-prev", ""); err != nil {
+			t.Fatalf("Prepare %s-prev: %v", key, err)
+		}
+		if err := os.WriteFile(filepath.Join(writableDir(t, sn, key+"-prev"), "data.txt"), []byte(key), 0644); err != nil {
+			t.Fatalf("write %s-prev/data.txt: %v", key, err)
+		}
+	}
+
+	results, err := sn.CloneMany(ctx, "${key}-prev", keys)
+	if err != nil {
+		t.Fatalf("CloneMany: %v", err)
+	}
+	for _, key := range keys {
+		if err := results[key].Err; err != nil {
+			t.Fatalf("clone %s: %v", key, err)
+		}
+		assertFileContent(t, writableDir(t, sn, key), "data.txt", key)
+		info, err := sn.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat %s: %v", key, err)
+		}
+		if got := info.Labels[snapshotter.LabelClonedFrom]; got != key+"-prev" {
+			t.Errorf("clone %s: label cloned-from = %q, want %q", key, got, key+"-prev")
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package snapshotter
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/snapshots"
+)
+
+// Cleanup frees the disk resources of removed snapshots through the inner
+// snapshotter, if it defers removing them, as the overlay snapshotter does
+// with its asynchronous remove option.  containerd calls it after garbage
+// collection.  With an inner snapshotter that removes snapshots
+// immediately there is nothing to clean up.
+func (s *CloneSnapshotter) Cleanup(ctx context.Context) error {
+	if c, ok := s.Snapshotter.(snapshots.Cleaner); ok {
+		return c.Cleanup(ctx)
+	}
+	return nil
+}
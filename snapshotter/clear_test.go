@@ -0,0 +1,125 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"golang.org/x/sys/unix"
+)
+
+// TestPrepare_Clone_ClearsOnlyNonEmptyDestinations verifies that an empty
+// destination is not cleared before the copy, while one holding its
+// parent's files, as a native clone on a parent does, still is.
+func TestPrepare_Clone_ClearsOnlyNonEmptyDestinations(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	var cleared []string
+	sn.clearHook = func(dir string) { cleared = append(cleared, dir) }
+
+	// A source without a parent gives a clone with an empty destination.
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if len(cleared) != 0 {
+		t.Errorf("empty destination was cleared: %q", cleared)
+	}
+
+	// A native snapshot on a parent starts out with the parent's files.
+	if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+		t.Fatalf("Prepare base-active: %v", err)
+	}
+	baseDir, err := sn.WritableDir(ctx, "base-active")
+	if err != nil {
+		t.Fatalf("WritableDir base-active: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "base.txt"), []byte("base"), 0644); err != nil {
+		t.Fatalf("write base.txt: %v", err)
+	}
+	if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+		t.Fatalf("Commit base: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "layered", "base"); err != nil {
+		t.Fatalf("Prepare layered: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "layered-clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "layered",
+	})); err != nil {
+		t.Fatalf("Prepare layered-clone: %v", err)
+	}
+	if len(cleared) != 1 {
+		t.Errorf("destination holding its parent's files was cleared %d times, want once", len(cleared))
+	}
+}
+
+// TestClearDir_Undeletable verifies that an entry that cannot be removed is
+// retried once and then fails clearing with an error naming the path below
+// it that is in the way.
+func TestClearDir_Undeletable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("create sub: %v", err)
+	}
+	stuck := filepath.Join(dir, "sub", "stuck")
+	var attempts int
+	c := &copier{removeAll: func(path string) error {
+		attempts++
+		return &fs.PathError{Op: "unlinkat", Path: stuck, Err: unix.EACCES}
+	}}
+
+	err := c.clearDir(dir)
+	if !errors.Is(err, unix.EACCES) || !strings.Contains(err.Error(), "cannot remove "+stuck+",") {
+		t.Fatalf("clearDir error = %v, want one naming %s", err, stuck)
+	}
+	if attempts != 2 {
+		t.Errorf("removal attempted %d times, want 2", attempts)
+	}
+}
+
+// TestClearDir_Immutable verifies that entries made undeletable by the
+// immutable and append-only flags are cleared.
+func TestClearDir_Immutable(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("create sub: %v", err)
+	}
+	file := filepath.Join(sub, "locked.txt")
+	if err := os.WriteFile(file, []byte("locked"), 0644); err != nil {
+		t.Fatalf("write locked.txt: %v", err)
+	}
+	for path, flag := range map[string]int{file: fsImmutableFl, sub: fsAppendFl} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("open %s: %v", path, err)
+		}
+		err = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, flag)
+		f.Close()
+		if err != nil {
+			t.Skipf("cannot set inode flags: %v", err)
+		}
+	}
+	t.Cleanup(func() { clearRemovalFlags(dir) })
+
+	if err := (&copier{}).clearDir(dir); err != nil {
+		t.Fatalf("clearDir: %v", err)
+	}
+	if empty, err := dirEmpty(dir); err != nil || !empty {
+		t.Errorf("directory not cleared: empty %t, %v", empty, err)
+	}
+}
@@ -0,0 +1,69 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is the error background work, such as ReplicateSource, returns
+// when it is stopped by Close.
+var ErrClosed = errors.New("clone snapshotter closed")
+
+// Close shuts the snapshotter down in order: it stops its background work,
+// such as ReplicateSource loops, and waits for it to return, then waits for
+// the clones in progress to finish, and finally closes the inner
+// snapshotter.  It is meant to be called once the server has stopped
+// accepting requests; CloseContext bounds the wait for the clones.  Later
+// calls return the result of the first.
+func (s *CloneSnapshotter) Close() error {
+	return s.CloseContext(context.Background())
+}
+
+// CloseContext is Close, but stops waiting for the clones in progress once
+// ctx is done: the inner snapshotter is then closed under them, so that
+// their process can exit, and the error wraps ctx's.
+func (s *CloneSnapshotter) CloseContext(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.closeMu.Lock()
+		s.closed = true
+		if s.closeCancel != nil {
+			s.closeCancel(ErrClosed)
+		}
+		s.closeMu.Unlock()
+
+		s.background.Wait()
+		if err := s.Drain(ctx); err != nil {
+			s.closeErr = fmt.Errorf("clones still in progress: %w", err)
+		}
+		if err := s.Snapshotter.Close(); err != nil {
+			s.closeErr = errors.Join(s.closeErr, fmt.Errorf("close inner snapshotter: %w", err))
+		}
+	})
+	return s.closeErr
+}
+
+// startBackground registers background work running under ctx with Close.
+// It returns the context the work must use, which Close cancels with the
+// cause ErrClosed, and a function to call once the work has returned.  If
+// Close has already been called, the context is cancelled from the start.
+func (s *CloneSnapshotter) startBackground(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		cancel(ErrClosed)
+		return ctx, func() {}
+	}
+	if s.closeCtx == nil {
+		s.closeCtx, s.closeCancel = context.WithCancelCause(context.Background())
+	}
+	stop := context.AfterFunc(s.closeCtx, func() { cancel(ErrClosed) })
+	s.background.Add(1)
+	return ctx, func() {
+		stop()
+		cancel(nil)
+		s.background.Done()
+	}
+}
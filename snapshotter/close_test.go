@@ -0,0 +1,119 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter/snapshottest"
+)
+
+// TestClose verifies that Close stops background replication and waits for
+// it to return before closing the inner snapshotter, and that replication
+// started after Close stops at once.
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	inner := snapshottest.New(t.TempDir())
+	sn := snapshotter.New(inner)
+	sn.ReplicateInterval = 10 * time.Millisecond
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "standby", "", snapshotter.WithCloneLabels("source", nil)); err != nil {
+		t.Fatalf("Prepare standby: %v", err)
+	}
+
+	done := make(chan error, 1)
+	synced := make(chan struct{})
+	var syncOnce sync.Once
+	var innerClosed, stoppedFirst atomic.Bool
+	inner.Fail = func(op, key string) error {
+		switch op {
+		case "Mounts":
+			if key == "standby" {
+				syncOnce.Do(func() { close(synced) })
+			}
+		case "Close":
+			innerClosed.Store(true)
+			stoppedFirst.Store(len(done) == 1)
+		}
+		return nil
+	}
+
+	go func() { done <- sn.ReplicateSource(ctx, "source", "standby") }()
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replication did not sync")
+	}
+
+	if err := sn.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !innerClosed.Load() {
+		t.Error("Close did not close the inner snapshotter")
+	} else if !stoppedFirst.Load() {
+		t.Error("inner snapshotter was closed before replication stopped")
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, snapshotter.ErrClosed) {
+			t.Errorf("ReplicateSource = %v, want ErrClosed", err)
+		}
+	default:
+		t.Fatal("Close returned before replication stopped")
+	}
+
+	if err := sn.ReplicateSource(ctx, "source", "standby"); !errors.Is(err, snapshotter.ErrClosed) {
+		t.Errorf("ReplicateSource after Close = %v, want ErrClosed", err)
+	}
+}
+
+// TestCloseContext verifies that CloseContext stops waiting for a clone
+// still in progress once its context is done, and still closes the inner
+// snapshotter.
+func TestCloseContext(t *testing.T) {
+	ctx := context.Background()
+	inner := snapshottest.New(t.TempDir())
+	sn := snapshotter.New(inner)
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	var innerClosed atomic.Bool
+	inner.Fail = func(op, key string) error {
+		switch {
+		case op == "Prepare" && key == "clone":
+			close(started)
+			<-release
+		case op == "Close":
+			innerClosed.Store(true)
+		}
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", nil))
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	closeCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := sn.CloseContext(closeCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseContext = %v, want DeadlineExceeded", err)
+	}
+	if !innerClosed.Load() {
+		t.Error("CloseContext did not close the inner snapshotter")
+	}
+}
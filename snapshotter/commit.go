@@ -0,0 +1,51 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/snapshots"
+)
+
+// trackClone records that the active snapshot key in the namespace of ctx
+// was created by a clone, when RemoveOnCommitFailure needs to know.
+func (s *CloneSnapshotter) trackClone(ctx context.Context, key string) {
+	if !s.RemoveOnCommitFailure {
+		return
+	}
+	s.clonesMu.Lock()
+	defer s.clonesMu.Unlock()
+	if s.clones == nil {
+		s.clones = map[string]struct{}{}
+	}
+	s.clones[refKey(ctx, key)] = struct{}{}
+}
+
+// untrackClone forgets the snapshot key in the namespace of ctx and reports
+// whether it was a tracked clone.
+func (s *CloneSnapshotter) untrackClone(ctx context.Context, key string) bool {
+	s.clonesMu.Lock()
+	defer s.clonesMu.Unlock()
+	k := refKey(ctx, key)
+	_, ok := s.clones[k]
+	delete(s.clones, k)
+	return ok
+}
+
+// Commit commits the active snapshot key as name through the inner
+// snapshotter.  With RemoveOnCommitFailure set, an active clone created by
+// this snapshotter whose Commit fails is removed, and the returned error
+// says so; otherwise Commit is delegated unchanged.
+func (s *CloneSnapshotter) Commit(ctx context.Context, name, key string, opts ...snapshots.Opt) error {
+	err := s.Snapshotter.Commit(ctx, name, key, opts...)
+	if !s.untrackClone(ctx, key) || err == nil {
+		return err
+	}
+	// The clone's context may be the reason for the failure; clean up
+	// regardless of it.
+	if removeErr := s.Remove(context.WithoutCancel(ctx), key); removeErr != nil {
+		s.cleanupFailed(ctx, key, removeErr)
+		return fmt.Errorf("%w (removing clone %q also failed: %v)", err, key, removeErr)
+	}
+	return fmt.Errorf("%w (clone %q removed)", err, key)
+}
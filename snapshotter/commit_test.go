@@ -0,0 +1,62 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// failingCommitSnapshotter fails every Commit as if name were taken.
+type failingCommitSnapshotter struct {
+	snapshots.Snapshotter
+}
+
+func (failingCommitSnapshotter) Commit(_ context.Context, name, _ string, _ ...snapshots.Opt) error {
+	return fmt.Errorf("snapshot %q: %w", name, errdefs.ErrAlreadyExists)
+}
+
+// TestCommit_RemoveOnCommitFailure verifies that a clone whose Commit fails
+// is removed only when RemoveOnCommitFailure is set, that other snapshots
+// are never removed, and that the Commit error is still reported.
+func TestCommit_RemoveOnCommitFailure(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			ctx := context.Background()
+			inner, err := native.NewSnapshotter(t.TempDir())
+			if err != nil {
+				t.Fatalf("create native snapshotter: %v", err)
+			}
+			sn := snapshotter.New(failingCommitSnapshotter{inner})
+			defer sn.Close()
+			sn.RemoveOnCommitFailure = enabled
+
+			if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+				t.Fatalf("Prepare source: %v", err)
+			}
+			if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+				snapshotter.LabelCloneSource: "source",
+			})); err != nil {
+				t.Fatalf("Prepare clone: %v", err)
+			}
+
+			for _, key := range []string{"clone", "source"} {
+				if err := sn.Commit(ctx, "taken", key); !errors.Is(err, errdefs.ErrAlreadyExists) {
+					t.Errorf("Commit %s error = %v, want ErrAlreadyExists", key, err)
+				}
+			}
+			_, err = sn.Stat(ctx, "clone")
+			if removed := errdefs.IsNotFound(err); removed != enabled {
+				t.Errorf("clone removed = %t (Stat: %v), want %t", removed, err, enabled)
+			}
+			if _, err := sn.Stat(ctx, "source"); err != nil {
+				t.Errorf("source that is no clone was removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,977 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
+)
+
+// ErrChecksumMismatch is returned (wrapped) by Prepare when
+// [CloneSnapshotter.VerifyChecksums] is enabled and a copied file does not
+// match its source.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// CloneCopyError is returned (wrapped) by Prepare when copying the writable
+// layer fails part way.  It records where the copy stopped so that failures
+// on flaky storage can be diagnosed; use [errors.As] to retrieve it.
+type CloneCopyError struct {
+	// Path is the file or directory being copied or synced when the copy
+	// failed.
+	Path string
+
+	// Files is the number of regular files copied before the failure.
+	Files int64
+
+	// Bytes is the number of file content bytes copied before the failure,
+	// including any written for the file that failed.
+	Bytes int64
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *CloneCopyError) Error() string {
+	return fmt.Sprintf("copy %s (after %d files, %d bytes): %v", e.Path, e.Files, e.Bytes, e.Err)
+}
+
+func (e *CloneCopyError) Unwrap() error { return e.Err }
+
+// castagnoli is the CRC-32C table used for checksum verification.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// dirEmpty reports whether dir has no entries, reading at most one of them.
+// A missing dir is empty.
+func dirEmpty(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// clearDir removes all entries inside dir without removing dir itself.  An
+// entry that cannot be removed has the immutable and append-only flags
+// cleared from it, everything below it and dir, and its removal is retried
+// once.  A failure names the path that could not be removed.
+func (c *copier) clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	removeAll := c.removeAll
+	if removeAll == nil {
+		removeAll = os.RemoveAll
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		err := removeAll(path)
+		if err != nil && errors.Is(err, fs.ErrPermission) {
+			clearFlags(dir, fsImmutableFl|fsAppendFl)
+			clearRemovalFlags(path)
+			err = removeAll(path)
+		}
+		if err != nil {
+			return removeError(path, err)
+		}
+	}
+	return nil
+}
+
+// removeError describes the failure err to remove path, naming the path
+// below it that could not be removed when err tells.
+func removeError(path string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		path = pe.Path
+		err = pe.Err
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("cannot remove %s, check its owner, permissions and inode flags and those of its directory: %w", path, err)
+	}
+	return fmt.Errorf("cannot remove %s: %w", path, err)
+}
+
+// copier copies a writable layer according to the settings of a single
+// clone.
+type copier struct {
+	// spaceMargin is the number of bytes that must remain free on the
+	// destination filesystem after the copy.
+	spaceMargin uint64
+
+	// maxBytes limits the total number of bytes copied; zero means no limit.
+	maxBytes int64
+
+	// bytes is the number of file content bytes copied so far.
+	bytes int64
+
+	// files is the number of regular files copied so far.
+	files int64
+
+	// strategies are tried before copying files; see
+	// [CloneSnapshotter.Strategies].
+	strategies []CloneStrategy
+
+	// incremental reuses an already populated destination, copying only
+	// files whose size or modification time differ from the source and
+	// removing entries the source no longer has.
+	incremental bool
+
+	// from, when set, is copied instead of the source's writable directory.
+	// CloneMany points it at a finished clone of the same source so that
+	// the source is only read once.
+	from string
+
+	// reflink shares file extents with the source through FICLONE where the
+	// filesystem supports it, falling back to copying the data.
+	reflink bool
+
+	// merge copies over an existing destination without clearing or
+	// pruning it; see [LabelCloneMerge].
+	merge bool
+
+	// lazy hard-links regular files instead of copying them; see
+	// [LabelCloneLazy].
+	lazy bool
+
+	// thin copies only what the source changed relative to its parent when
+	// cloning into a bind mount; see [LabelCloneThin].
+	thin bool
+
+	// reserve, if set, charges the bytes a layer is about to copy against
+	// the quota of the clone's namespace; reserved is the total charged.
+	// See [CloneSnapshotter.NamespaceQuotas].
+	reserve  func(n int64) error
+	reserved int64
+
+	// freshDest is set when the destination was just prepared by a backend
+	// whose new snapshots are known to be empty, so that clearing it and
+	// probing that it is writable can be skipped; see
+	// [CloneSnapshotter.AssumeEmptyDestinations].
+	freshDest bool
+
+	// clearHook, if set, is called with each destination directory about to
+	// be cleared.
+	clearHook func(dir string)
+
+	// readdirOrder creates the entries of each directory in the order the
+	// source lists them; see [LabelCloneReaddirOrder].
+	readdirOrder bool
+
+	// metadataOnly creates regular files as sparse files of the source's
+	// size instead of copying their data; see [LabelCloneMetadataOnly].
+	metadataOnly bool
+
+	// pathMap relocates source subtrees in the clone, leaving out everything
+	// else; see [LabelClonePathMap].
+	pathMap []pathRule
+
+	// viaTar copies through a tar stream; see [LabelCloneViaTar].
+	viaTar bool
+
+	// resumable keeps a manifest of the copied files in resumeDir, the
+	// clone's staging directory, so that a failed clone can be resumed; see
+	// [LabelCloneResumable].  resume is the manifest while the writable
+	// layer is copied.
+	resumable bool
+	resumeDir string
+	resume    *resumeManifest
+
+	// ignore holds patterns, relative to the source root, of the runtime
+	// managed files that are not copied; see
+	// [CloneSnapshotter.IgnoreFiles].  Unlike exclude, it does not bypass
+	// clone strategies.
+	ignore []string
+
+	// fitBudget is the size budget of a fit clone; see
+	// [LabelCloneFitBudget].  fitSpent is how much of it the writable
+	// directories planned so far take, and fitSkip holds the sizes of the
+	// source files left out, by path.
+	fitBudget int64
+	fitSpent  int64
+	fitSkip   map[string]int64
+
+	// followSymlinks copies what the symlinks of the source lead to
+	// instead of the links; see [LabelCloneFollowSymlinks].  root is the
+	// source root links are resolved in, and following holds the source
+	// directories being copied through links, outermost first.  linkRel
+	// is the path, relative to the clone root, of the innermost link being
+	// copied, against which exclude patterns are matched.
+	followSymlinks bool
+	root           string
+	following      []string
+	linkRel        string
+
+	// provenance marks each copied regular file and directory with the
+	// key of the source snapshot, source; see [LabelCloneProvenance].
+	// provenanceOff is set once the destination turns out not to support
+	// the marks.
+	provenance    bool
+	source        string
+	provenanceOff bool
+
+	// freeze freezes the filesystem of each source writable directory while
+	// it is copied; see [LabelCloneFreeze].  freezeFS and thawFS replace
+	// the FIFREEZE and FITHAW ioctls if set.
+	freeze   bool
+	freezeFS func(f *os.File) error
+	thawFS   func(f *os.File) error
+
+	// exclude holds patterns, relative to the source root, of paths that are
+	// not copied.
+	exclude []string
+
+	// verifyChecksums re-reads each copied file and compares its CRC-32C
+	// against the checksum computed while reading the source.
+	verifyChecksums bool
+
+	// verifyStructure compares the tree of each copied writable directory
+	// with its source's; see verifyLayerDir.  verifyHook, if set, is called
+	// with the destination directory before it is compared.
+	verifyStructure bool
+	verifyHook      func(dstDir string)
+
+	// durable fsyncs every copied file and destination directory.
+	durable bool
+
+	// bufSize is the size of the buffers file data is copied through; zero
+	// means defaultCopyBufferSize.  Buffers are taken from and returned to
+	// bufPool, when set, so that concurrent clones share them.
+	bufSize int
+	bufPool *sync.Pool
+
+	// limiter, when set, throttles the data copied into regular files.
+	limiter *rate.Limiter
+
+	// directIO copies file data with O_DIRECT where the filesystems
+	// support it, through directBuf; see copyDirect.  directFlag, if set,
+	// replaces the fcntl that turns O_DIRECT on and off.
+	directIO   bool
+	directBuf  []byte
+	directFlag func(f *os.File, on bool) error
+
+	// skipInodeCheck disables the free inode check; statfs replaces
+	// unix.Statfs in it if set.
+	skipInodeCheck bool
+	statfs         func(path string, st *unix.Statfs_t) error
+
+	// encryption, if set, replaces getEncryptionPolicy.
+	encryption func(dir string) ([]byte, error)
+
+	// fsync syncs f to stable storage when durable is set.  A nil fsync
+	// calls f.Sync; tests replace it to observe which files are synced.
+	fsync func(f *os.File) error
+
+	// bestEffort skips regular files that cannot be copied instead of
+	// failing the clone; see [LabelCloneBestEffort].
+	bestEffort bool
+
+	// skipped lists the source paths of the files skipped in best-effort
+	// mode.
+	skipped []string
+
+	// crossMounts copies the contents of file systems mounted below the
+	// source root instead of skipping them; see [LabelCloneCrossMounts].
+	crossMounts bool
+
+	// deviceOf returns the ID of the device holding the file described by
+	// info.  A nil deviceOf reads it from the stat result; tests replace it to
+	// simulate mount points.
+	deviceOf func(info fs.FileInfo) uint64
+
+	// link hard-links files for lazy clones and ficlone shares extents for
+	// reflink copies.  Nil values call os.Link and unix.IoctlFileClone;
+	// tests replace them to simulate failures such as EXDEV.
+	link    func(oldname, newname string) error
+	ficlone func(dstFd, srcFd int) error
+
+	// lchown gives copied entries the owner of their source.  A nil lchown
+	// calls os.Lchown; tests replace it to simulate an unprivileged plugin.
+	lchown func(path string, uid, gid int) error
+
+	// removeAll removes the entries of a destination being cleared.  A nil
+	// removeAll calls os.RemoveAll; tests replace it to simulate entries
+	// that cannot be removed.
+	removeAll func(path string) error
+
+	// progress, when set, is called with the current time, as read from
+	// clock, after a regular file is copied, at most once per
+	// progressInterval; lastProgress is when it was last called.  total is
+	// the size of the data measured for the copy, for estimating how long
+	// it takes.
+	progress         func(now time.Time)
+	clock            func() time.Time
+	progressInterval time.Duration
+	lastProgress     time.Time
+	total            int64
+
+	// acquireFile, if set, is called before a regular file is opened for
+	// copying and waits until it may be; see
+	// [CloneSnapshotter.MaxOpenFiles].  The returned function is called
+	// once the file is closed.
+	acquireFile func(ctx context.Context) (release func(), err error)
+
+	// hook, when non-nil, is called before each regular file is copied;
+	// an error aborts the copy.  Tests use it to slow down or observe
+	// clones.
+	hook func(ctx context.Context, path string) error
+
+	// wrapDst, when non-nil, wraps the writer of each destination file.  It
+	// lets tests simulate storage that corrupts data on write.
+	wrapDst func(io.Writer) io.Writer
+}
+
+// copyDir recursively copies the contents of srcDir into dstDir, preserving
+// ownership and permissions including the setuid, setgid and sticky bits
+// regardless of the process umask.  Entries the plugin cannot give their
+// source's owner lose the setuid and setgid bits instead.  Symlinks are
+// recreated as symlinks; directories, including empty ones, and regular
+// files are copied with their mode bits.  Regular files keep their
+// modification time, and directories their access and modification times.
+//
+// In incremental mode, regular files already present in dstDir with the same
+// mode, size and modification time are left untouched, and entries missing
+// from srcDir are removed from dstDir afterwards.  In merge mode, unchanged
+// files are skipped in the same way but nothing is removed.
+//
+// Overlay upperdir semantics are preserved: device nodes, including the 0/0
+// character devices overlayfs uses as whiteouts for deleted lower files, are
+// recreated with mknod, and the opaque-directory xattr is carried over so a
+// directory replaced in the source does not expose lower-layer entries in the
+// clone.
+//
+// A failure is reported as a [*CloneCopyError].
+func (c *copier) copyDir(ctx context.Context, srcDir, dstDir string) error {
+	// Directories are finished once the walk is complete, deepest first,
+	// so that, e.g., each directory's entries are durable before its
+	// parent's.
+	dirs := []string{dstDir}
+	// srcs holds the source directory of each of dirs.
+	srcs := []string{srcDir}
+
+	// current is the entry being worked on, reported if the copy fails.
+	var current string
+	// rootDev is the device of srcDir; entries on another device are
+	// mount points, which are not crossed unless crossMounts is set.
+	var rootDev uint64
+	err := c.walk(srcDir, func(path string, d fs.DirEntry, err error) error {
+		current = path
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+
+		// Skip the root entry; dstDir already exists.
+		if rel == "." {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rootDev = c.device(info)
+			if len(c.pathMap) > 0 {
+				// Only the mapped subtrees are copied, not the root.
+				return nil
+			}
+			return copyXattrs(path, dstDir, overlayOpaqueXattrs)
+		}
+
+		if c.excluded(filepath.Join(c.linkRel, rel)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !c.crossMounts {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if c.device(info) != rootDev {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		dst := filepath.Join(dstDir, rel)
+		if len(c.pathMap) > 0 {
+			mapped, descend := c.mapPath(rel)
+			switch {
+			case mapped != "":
+				dst = filepath.Join(dstDir, mapped)
+				if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+					return err
+				}
+			case descend && d.IsDir():
+				return nil
+			case d.IsDir():
+				return fs.SkipDir
+			default:
+				return nil
+			}
+		}
+
+		if c.incremental || c.merge {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			unchanged, err := reconcileEntry(info, dst)
+			if err != nil || unchanged {
+				return err
+			}
+		}
+		if c.resume != nil && c.resume.resumed {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			done, err := c.resume.reconcile(info, dst)
+			if err != nil || done {
+				return err
+			}
+		}
+
+		if c.followSymlinks && d.Type()&fs.ModeSymlink != 0 {
+			target, info, err := c.followLink(path)
+			switch {
+			case err != nil:
+				return err
+			case info == nil:
+				// Dangling links are kept as they are.
+			case info.IsDir():
+				return c.copyFollowedDir(ctx, target, dst, filepath.Join(c.linkRel, rel), info)
+			default:
+				path, d = target, fs.FileInfoToDirEntry(info)
+			}
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			if err := copySymlink(path, dst); err != nil {
+				return err
+			}
+			_, err := c.copyOwner(path, dst)
+			return err
+
+		case d.Type()&fs.ModeDevice != 0:
+			return c.copyDevice(path, dst)
+
+		case d.IsDir():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+				return err
+			}
+			owned, err := c.copyOwner(path, dst)
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(dst, ownedMode(modeBits(info.Mode()), owned)); err != nil {
+				return err
+			}
+			dirs = append(dirs, dst)
+			srcs = append(srcs, path)
+			if err := copyXattrs(path, dst, aclXattrs); err != nil {
+				return err
+			}
+			if err := c.markProvenance(ctx, dst); err != nil {
+				return err
+			}
+			return copyXattrs(path, dst, overlayOpaqueXattrs)
+
+		default:
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if c.skipUnfit(ctx, path) {
+				return nil
+			}
+			if c.hook != nil {
+				if err := c.hook(ctx, path); err != nil {
+					return err
+				}
+			}
+			if c.lazy {
+				if linked, err := c.linkFile(path, dst); err != nil || linked {
+					return err
+				}
+			}
+			if err := c.copyFile(ctx, path, dst, modeBits(info.Mode())); err != nil {
+				return c.skipFile(ctx, path, dst, err)
+			}
+			if err := os.Chtimes(dst, time.Time{}, info.ModTime()); err != nil {
+				return err
+			}
+			// Marked before an immutable flag forbids it.
+			if err := c.markProvenance(ctx, dst); err != nil {
+				return err
+			}
+			if err := copyInodeFlags(path, dst); err != nil {
+				return err
+			}
+			if c.resume != nil {
+				return c.resume.record(info, dst)
+			}
+			return nil
+		}
+	})
+	// A resumed clone may hold files the source no longer has.
+	if err == nil && (c.incremental || c.resume != nil && c.resume.resumed) {
+		current = dstDir
+		err = c.pruneDir(srcDir, dstDir)
+	}
+	// Directory times are restored once nothing is added to or removed
+	// from the directories any more, deepest first.
+	for i := len(dirs) - 1; i >= 0 && err == nil; i-- {
+		current = dirs[i]
+		err = copyTimes(srcs[i], current)
+	}
+	// Directory flags are applied last, deepest first, because an
+	// immutable directory can no longer have entries added or its times
+	// set.
+	for i := len(dirs) - 1; i > 0 && err == nil; i-- {
+		current = dirs[i]
+		err = copyInodeFlags(srcs[i], current)
+	}
+	if err == nil && c.durable {
+		for i := len(dirs) - 1; i >= 0 && err == nil; i-- {
+			current = dirs[i]
+			if err = c.syncPath(current); err != nil {
+				err = fmt.Errorf("sync directory: %w", err)
+			}
+		}
+	}
+	if err != nil {
+		// The copy of a directory reached through a link reports the
+		// path that failed in it.
+		var copyErr *CloneCopyError
+		if errors.As(err, &copyErr) {
+			return err
+		}
+		return &CloneCopyError{Path: current, Files: c.files, Bytes: c.bytes, Err: err}
+	}
+	return nil
+}
+
+// copyTimes sets the access and modification times of dst to those of src.
+func copyTimes(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	var atime time.Time
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		atime = time.Unix(st.Atim.Unix())
+	}
+	return os.Chtimes(dst, atime, fi.ModTime())
+}
+
+// syncFile flushes f to stable storage.
+func (c *copier) syncFile(f *os.File) error {
+	if c.fsync != nil {
+		return c.fsync(f)
+	}
+	return f.Sync()
+}
+
+// device returns the ID of the device holding the file described by info.
+func (c *copier) device(info fs.FileInfo) uint64 {
+	if c.deviceOf != nil {
+		return c.deviceOf(info)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Dev
+	}
+	return 0
+}
+
+// skipFile handles err, the failure to copy the regular file src to dst.  In
+// best-effort mode the file is logged, recorded in c.skipped and left out of
+// the clone, and nil is returned; otherwise, or if err is not specific to the
+// file, err is returned unchanged.
+func (c *copier) skipFile(ctx context.Context, src, dst string, err error) error {
+	if !c.bestEffort || errors.Is(err, ctx.Err()) || errors.Is(err, ErrCloneTooLarge) {
+		return err
+	}
+	log.G(ctx).WithError(err).WithField("path", src).Warn("best-effort clone: skipping file that could not be copied")
+	c.skipped = append(c.skipped, src)
+	if rmErr := os.Remove(dst); rmErr != nil && !os.IsNotExist(rmErr) {
+		return fmt.Errorf("remove partial copy of skipped file: %w", rmErr)
+	}
+	return nil
+}
+
+// defaultCopyBufferSize is the copy buffer size used when
+// [CloneSnapshotter.CopyBufferSize] is zero.
+const defaultCopyBufferSize = 128 << 10
+
+// buffer returns a copy buffer of the configured size, reusing one from the
+// pool if possible.
+func (c *copier) buffer() *[]byte {
+	size := c.bufSize
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	if c.bufPool != nil {
+		// Buffers of another size predate a change of CopyBufferSize.
+		if buf, ok := c.bufPool.Get().(*[]byte); ok && len(*buf) == size {
+			return buf
+		}
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// putBuffer returns buf to the pool for reuse.
+func (c *copier) putBuffer(buf *[]byte) {
+	if c.bufPool != nil {
+		c.bufPool.Put(buf)
+	}
+}
+
+// cloneFile makes dst share src's extents with the FICLONE ioctl.
+func (c *copier) cloneFile(dst, src *os.File) error {
+	if c.ficlone != nil {
+		return c.ficlone(int(dst.Fd()), int(src.Fd()))
+	}
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
+
+// syncPath opens path read-only and flushes it to stable storage.
+func (c *copier) syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.syncFile(f)
+}
+
+// copySymlink creates a symlink at dst pointing to the same target as src.
+func copySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// parseExcludePatterns splits a comma-separated list of exclude patterns,
+// dropping empty entries and leading slashes and validating each pattern.
+func parseExcludePatterns(v string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.TrimLeft(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", p, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// excluded reports whether rel, a path relative to the source root, matches
+// one of the exclude or ignore patterns.
+func (c *copier) excluded(rel string) bool {
+	return matchAny(c.exclude, rel) || matchAny(c.ignore, rel)
+}
+
+// matchAny reports whether rel matches one of patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyDevice recreates the character or block device node src at dst with
+// the same device number, ownership and permissions.
+func (c *copier) copyDevice(src, dst string) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(src, &st); err != nil {
+		return err
+	}
+	if err := unix.Mknod(dst, st.Mode&unix.S_IFMT|st.Mode&0777, int(st.Rdev)); err != nil {
+		return fmt.Errorf("mknod %s: %w", dst, err)
+	}
+	owned, err := c.copyOwner(src, dst)
+	if err != nil {
+		return err
+	}
+	// mknod applies the umask; restore the exact source permissions.
+	mode := st.Mode &^ unix.S_IFMT
+	if !owned {
+		mode &^= unix.S_ISUID | unix.S_ISGID
+	}
+	return unix.Chmod(dst, mode)
+}
+
+// copyOwner gives dst, which is not followed if it is a symlink, the owner
+// and group of src, and reports whether it did.  Without the privilege to
+// do so, as when the plugin runs unprivileged, dst is left owned by the
+// plugin and false is returned; the caller must then not give dst the
+// setuid and setgid bits of src, which would run it as the plugin's user.
+func (c *copier) copyOwner(src, dst string) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(src, &st); err != nil {
+		return false, err
+	}
+	return c.chown(dst, int(st.Uid), int(st.Gid))
+}
+
+// chown gives dst the owner uid and group gid, as copyOwner does, and
+// reports whether it could.
+func (c *copier) chown(dst string, uid, gid int) (bool, error) {
+	lchown := c.lchown
+	if lchown == nil {
+		lchown = os.Lchown
+	}
+	err := lchown(dst, uid, gid)
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, unix.EINVAL) {
+		// EINVAL: the IDs are not mapped in the plugin's user namespace.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ownedMode returns mode without the setuid and setgid bits unless owned
+// reports that the entry has the owner and group of its source.
+func ownedMode(mode fs.FileMode, owned bool) fs.FileMode {
+	if owned {
+		return mode
+	}
+	return mode &^ (fs.ModeSetuid | fs.ModeSetgid)
+}
+
+// modeBits returns the permission bits of m together with the setuid, setgid
+// and sticky bits, which os.FileMode.Perm drops.
+func modeBits(m fs.FileMode) fs.FileMode {
+	return m & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+}
+
+// copyFile copies a regular file from src to dst using the provided mode bits.
+// The mode is applied with an explicit chmod once the content is written
+// and dst has been given the owner and group of src, since the mode passed
+// at creation cannot carry the setuid, setgid and sticky bits, and those
+// bits must not be set on a file owned by the plugin.  When checksum
+// verification is enabled the destination is re-read once it has been
+// closed and compared against the source.  A metadata-only clone creates
+// dst without copying any data.
+func (c *copier) copyFile(ctx context.Context, src, dst string, mode os.FileMode) error {
+	if c.acquireFile != nil {
+		release, err := c.acquireFile(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	var sum uint32
+	var err error
+	// The file is created without the setuid and setgid bits, which are
+	// only set once it has its source's owner.
+	if c.metadataOnly {
+		err = c.writeSkeleton(src, dst, mode.Perm())
+	} else {
+		sum, err = c.writeFile(ctx, src, dst, mode.Perm())
+	}
+	if err != nil {
+		return err
+	}
+	owned, err := c.copyOwner(src, dst)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, ownedMode(mode, owned)); err != nil {
+		return err
+	}
+	// ACLs are applied after the chmod, which would otherwise rewrite
+	// their mask entry.
+	if err := copyXattrs(src, dst, aclXattrs); err != nil {
+		return err
+	}
+	if !c.verifyChecksums || c.metadataOnly {
+		return nil
+	}
+
+	got, err := fileChecksum(dst)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", dst, err)
+	}
+	if got != sum {
+		return fmt.Errorf("%w: %s: source crc32c %08x, destination crc32c %08x", ErrChecksumMismatch, dst, sum, got)
+	}
+	return nil
+}
+
+// writeFile copies src to dst and returns the CRC-32C of the bytes read from
+// src, or zero if checksum verification is disabled.
+func (c *copier) writeFile(ctx context.Context, src, dst string, mode os.FileMode) (sum uint32, retErr error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	if err := breakLink(dst); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && retErr == nil {
+			retErr = cerr
+		}
+	}()
+
+	var r io.Reader = ctxReader{ctx, in}
+	if c.maxBytes > 0 {
+		// Read at most one byte past the limit so that crossing it is
+		// detected without copying the rest of a huge file.
+		r = io.LimitReader(r, c.maxBytes-c.bytes+1)
+	}
+	var h hash.Hash32
+	if c.verifyChecksums {
+		h = crc32.New(castagnoli)
+		r = io.TeeReader(r, h)
+	}
+	var w io.Writer = out
+	if c.wrapDst != nil {
+		w = c.wrapDst(w)
+	}
+
+	// A failed reflink, e.g. EXDEV across filesystems or EOPNOTSUPP,
+	// leaves out untouched, so the data is simply copied instead.
+	var n int64
+	if c.reflink && c.cloneFile(out, in) == nil {
+		// The destination now shares the extents the source had when it
+		// was cloned.  A live source may have changed since, so the size
+		// and, if needed, the checksum are taken from the destination.
+		var fi os.FileInfo
+		if fi, err = out.Stat(); err == nil {
+			n = fi.Size()
+			if h != nil {
+				err = hashFile(ctx, dst, h)
+			}
+		}
+	} else {
+		var direct bool
+		if c.directIO {
+			n, direct, err = c.copyDirect(ctx, in, out, w, h)
+		}
+		if !direct {
+			if c.limiter != nil {
+				r = rateReader{ctx, r, c.limiter}
+			}
+			buf := c.buffer()
+			defer c.putBuffer(buf)
+			// Hide out's ReadFrom, which would copy through a buffer of
+			// its own.
+			n, err = io.CopyBuffer(struct{ io.Writer }{w}, r, *buf)
+		}
+	}
+	c.bytes += n
+	if err != nil {
+		return 0, err
+	}
+	if c.maxBytes > 0 && c.bytes > c.maxBytes {
+		return 0, fmt.Errorf("%w: copied more than %d bytes", ErrCloneTooLarge, c.maxBytes)
+	}
+	c.fileCopied()
+	if c.durable {
+		if err := c.syncFile(out); err != nil {
+			return 0, fmt.Errorf("sync %s: %w", dst, err)
+		}
+	}
+	if h != nil {
+		sum = h.Sum32()
+	}
+	return sum, nil
+}
+
+// ctxReader is an io.Reader that fails with the context's error once the
+// context is done, so that a cancelled clone stops in the middle of a large
+// file rather than after it.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// hashFile writes the contents of the file at path to h.
+func hashFile(ctx context.Context, path string, h hash.Hash32) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, ctxReader{ctx, f})
+	return err
+}
+
+// fileChecksum returns the CRC-32C of the contents of the file at path.
+func fileChecksum(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
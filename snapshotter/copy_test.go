@@ -0,0 +1,616 @@
+package snapshotter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/log"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// corruptingWriter flips the first byte of every write it forwards.
+type corruptingWriter struct{ w io.Writer }
+
+func (c corruptingWriter) Write(p []byte) (int, error) {
+	b := append([]byte(nil), p...)
+	if len(b) > 0 {
+		b[0] ^= 0xff
+	}
+	return c.w.Write(b)
+}
+
+// TestCopyFile_VerifyChecksums verifies that a destination file which does
+// not match its source is detected when checksum verification is enabled
+// and goes unnoticed when it is disabled.
+func TestCopyFile_VerifyChecksums(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	if err := os.WriteFile(src, []byte("precious data"), 0644); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	corrupt := func(w io.Writer) io.Writer { return corruptingWriter{w} }
+
+	c := &copier{verifyChecksums: true, wrapDst: corrupt}
+	err := c.copyFile(context.Background(), src, filepath.Join(dir, "verified.txt"), 0644)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("copyFile error = %v, want ErrChecksumMismatch", err)
+	}
+
+	c = &copier{wrapDst: corrupt}
+	if err := c.copyFile(context.Background(), src, filepath.Join(dir, "unverified.txt"), 0644); err != nil {
+		t.Fatalf("copyFile without verification: %v", err)
+	}
+
+	c = &copier{verifyChecksums: true}
+	if err := c.copyFile(context.Background(), src, filepath.Join(dir, "clean.txt"), 0644); err != nil {
+		t.Fatalf("copyFile with verification: %v", err)
+	}
+}
+
+// TestCopyDir_Durable verifies that every copied file and every destination
+// directory is fsynced when durability is requested, and that nothing is
+// synced otherwise.
+func TestCopyDir_Durable(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	for _, durable := range []bool{true, false} {
+		dst := t.TempDir()
+		synced := map[string]bool{}
+		c := &copier{
+			durable: durable,
+			fsync: func(f *os.File) error {
+				synced[f.Name()] = true
+				return f.Sync()
+			},
+		}
+		if err := c.copyDir(context.Background(), src, dst); err != nil {
+			t.Fatalf("copyDir(durable=%v): %v", durable, err)
+		}
+
+		for _, rel := range []string{".", "a.txt", "sub", "sub/b.txt"} {
+			path := filepath.Join(dst, rel)
+			if synced[path] != durable {
+				t.Errorf("durable=%v: synced[%s] = %v", durable, rel, synced[path])
+			}
+		}
+	}
+}
+
+// TestCopyDir_MaxBytes verifies that the size limit is enforced while
+// copying, independently of any up-front size check, and that the copy stops
+// shortly after the limit is crossed.
+func TestCopyDir_MaxBytes(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+		if err := os.WriteFile(filepath.Join(src, name), make([]byte, 1000), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	c := &copier{maxBytes: 1500}
+	err := c.copyDir(context.Background(), src, t.TempDir())
+	if !errors.Is(err, ErrCloneTooLarge) {
+		t.Fatalf("copyDir error = %v, want ErrCloneTooLarge", err)
+	}
+	if c.bytes != 1501 {
+		t.Errorf("copied %d bytes, want the copy to stop at 1501", c.bytes)
+	}
+}
+
+// TestCopyDir_CopyError verifies that a failed copy reports the file it
+// failed on and how much had been copied before it.
+func TestCopyDir_CopyError(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for name, data := range map[string]string{"a.txt": "12345", "sub/b.txt": "678", "sub/c.txt": "9"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(data), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	injected := errors.New("injected I/O error")
+	failing := filepath.Join(src, "sub", "b.txt")
+	c := &copier{hook: func(_ context.Context, path string) error {
+		if path == failing {
+			return injected
+		}
+		return nil
+	}}
+	err := c.copyDir(context.Background(), src, t.TempDir())
+
+	var copyErr *CloneCopyError
+	if !errors.As(err, &copyErr) {
+		t.Fatalf("copyDir error = %v, want a *CloneCopyError", err)
+	}
+	if !errors.Is(err, injected) {
+		t.Errorf("copyDir error = %v, want it to wrap the injected error", err)
+	}
+	if copyErr.Path != failing {
+		t.Errorf("Path = %q, want %q", copyErr.Path, failing)
+	}
+	if copyErr.Files != 1 || copyErr.Bytes != 5 {
+		t.Errorf("copied %d files, %d bytes before failing, want 1 file, 5 bytes", copyErr.Files, copyErr.Bytes)
+	}
+}
+
+// TestCopyDir_CrossDevice verifies that when hard-linking or reflinking fails
+// with EXDEV, as it does across filesystems, each file is copied instead and
+// the clone still completes.
+func TestCopyDir_CrossDevice(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	exdev := func(string, string) error { return &os.LinkError{Op: "link", Err: unix.EXDEV} }
+
+	for name, c := range map[string]*copier{
+		"lazy":    {lazy: true, link: exdev},
+		"reflink": {reflink: true, ficlone: func(int, int) error { return unix.EXDEV }},
+	} {
+		dst := t.TempDir()
+		if err := c.copyDir(context.Background(), src, dst); err != nil {
+			t.Fatalf("%s: copyDir: %v", name, err)
+		}
+		if c.files != 2 || c.bytes != int64(len("a.txt")+len("sub/b.txt")) {
+			t.Errorf("%s: copied %d files, %d bytes; want 2 files copied by content", name, c.files, c.bytes)
+		}
+		for _, rel := range []string{"a.txt", "sub/b.txt"} {
+			got, err := os.ReadFile(filepath.Join(dst, rel))
+			if err != nil || string(got) != rel {
+				t.Errorf("%s: %s = %q, %v; want %q", name, rel, got, err, rel)
+			}
+			srcInfo, _ := os.Stat(filepath.Join(src, rel))
+			dstInfo, _ := os.Stat(filepath.Join(dst, rel))
+			if os.SameFile(srcInfo, dstInfo) {
+				t.Errorf("%s: %s is linked to the source, want a copy", name, rel)
+			}
+		}
+	}
+}
+
+// TestCopyDir_MountPoints verifies that directories and files on another
+// device than the source root are skipped unless crossing mounts is
+// requested.
+func TestCopyDir_MountPoints(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "mnt", "host"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"own.txt", "bound.txt", "mnt/host/secret.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	// Pretend mnt and bound.txt are mount points.
+	deviceOf := func(info fs.FileInfo) uint64 {
+		if info.Name() == "mnt" || info.Name() == "bound.txt" {
+			return 2
+		}
+		return 1
+	}
+
+	for _, crossMounts := range []bool{false, true} {
+		dst := t.TempDir()
+		c := &copier{crossMounts: crossMounts, deviceOf: deviceOf}
+		if err := c.copyDir(context.Background(), src, dst); err != nil {
+			t.Fatalf("copyDir(crossMounts=%v): %v", crossMounts, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dst, "own.txt")); err != nil {
+			t.Errorf("crossMounts=%v: own.txt not copied: %v", crossMounts, err)
+		}
+		for _, rel := range []string{"bound.txt", "mnt", "mnt/host/secret.txt"} {
+			_, err := os.Lstat(filepath.Join(dst, rel))
+			if copied := err == nil; copied != crossMounts {
+				t.Errorf("crossMounts=%v: %s copied = %v", crossMounts, rel, copied)
+			}
+		}
+	}
+}
+
+// TestCopyDir_BufferPool verifies that files larger than the copy buffer are
+// copied intact and that buffers are reused from the pool rather than
+// allocated per file.
+func TestCopyDir_BufferPool(t *testing.T) {
+	const bufSize, files = 4096, 8
+	src := t.TempDir()
+	data := make([]byte, 10*bufSize+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("generate data: %v", err)
+	}
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%d.bin", i)), data, 0644); err != nil {
+			t.Fatalf("write f%d.bin: %v", i, err)
+		}
+	}
+
+	var allocs int
+	pool := &sync.Pool{New: func() any {
+		allocs++
+		buf := make([]byte, bufSize)
+		return &buf
+	}}
+	dst := t.TempDir()
+	c := &copier{bufSize: bufSize, bufPool: pool}
+	if err := c.copyDir(context.Background(), src, dst); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+
+	for i := 0; i < files; i++ {
+		got, err := os.ReadFile(filepath.Join(dst, fmt.Sprintf("f%d.bin", i)))
+		if err != nil {
+			t.Fatalf("read f%d.bin: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("f%d.bin differs from its source", i)
+		}
+	}
+	// sync.Pool may drop entries, e.g. under the race detector, so only
+	// require that buffers were reused at all.
+	if allocs == 0 || allocs >= files {
+		t.Errorf("allocated %d buffers for %d files, want them reused", allocs, files)
+	}
+}
+
+// failingWriter fails every write with err.
+type failingWriter struct{ err error }
+
+func (f failingWriter) Write([]byte) (int, error) { return 0, f.err }
+
+// TestCopyDir_BestEffort verifies that a file that cannot be copied fails a
+// strict copy but is skipped and reported by a best-effort one, which goes
+// on to copy everything else.
+func TestCopyDir_BestEffort(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a-broken.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	ioErr := errors.New("input/output error")
+	// Fail the first file copied, a-broken.txt.
+	breakFirst := func() func(io.Writer) io.Writer {
+		calls := 0
+		return func(w io.Writer) io.Writer {
+			if calls++; calls == 1 {
+				return failingWriter{ioErr}
+			}
+			return w
+		}
+	}
+
+	c := &copier{wrapDst: breakFirst()}
+	if err := c.copyDir(context.Background(), src, t.TempDir()); !errors.Is(err, ioErr) {
+		t.Fatalf("strict copyDir error = %v, want the I/O error", err)
+	}
+
+	dst := t.TempDir()
+	c = &copier{bestEffort: true, wrapDst: breakFirst()}
+	if err := c.copyDir(context.Background(), src, dst); err != nil {
+		t.Fatalf("best-effort copyDir: %v", err)
+	}
+	if want := filepath.Join(src, "a-broken.txt"); len(c.skipped) != 1 || c.skipped[0] != want {
+		t.Errorf("skipped = %q, want [%q]", c.skipped, want)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "a-broken.txt")); !os.IsNotExist(err) {
+		t.Errorf("skipped file left behind in the clone, lstat err = %v", err)
+	}
+	for _, name := range []string{"b.txt", "c.txt"} {
+		if got, err := os.ReadFile(filepath.Join(dst, name)); err != nil || string(got) != name {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, name)
+		}
+	}
+}
+
+// TestCopyDir_DirectIO verifies that files of aligned and unaligned sizes are
+// copied intact with O_DIRECT, and through the page cache when the
+// filesystem does not support it.
+func TestCopyDir_DirectIO(t *testing.T) {
+	src := t.TempDir()
+	sizes := []int{0, 100, directAlign, 3*directAlign + 123, 40*directAlign + 7}
+	for _, size := range sizes {
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatalf("generate data: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("f%d.bin", size)), data, 0644); err != nil {
+			t.Fatalf("write f%d.bin: %v", size, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		unsupported bool
+	}{
+		{name: "direct"},
+		{name: "fallback", unsupported: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var direct int
+			c := &copier{bufSize: 8 * directAlign, directIO: true, verifyChecksums: true}
+			c.directFlag = func(f *os.File, on bool) error {
+				if on && tc.unsupported {
+					return unix.EINVAL
+				}
+				err := setDirectFlag(f, on)
+				if on && err == nil {
+					direct++
+				}
+				return err
+			}
+			dst := t.TempDir()
+			if err := c.copyDir(context.Background(), src, dst); err != nil {
+				t.Fatalf("copyDir: %v", err)
+			}
+			for _, size := range sizes {
+				name := fmt.Sprintf("f%d.bin", size)
+				want, _ := os.ReadFile(filepath.Join(src, name))
+				got, err := os.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatalf("read %s: %v", name, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s differs from its source", name)
+				}
+			}
+			if tc.unsupported && direct != 0 {
+				t.Errorf("O_DIRECT set %d times on an unsupported filesystem", direct)
+			}
+			if !tc.unsupported && direct == 0 {
+				t.Skip("filesystem does not support O_DIRECT")
+			}
+		})
+	}
+}
+
+// TestCopyDir_ShrinkingSource verifies that a source file truncated after
+// the layer was measured, as a running container may do, is copied as it is
+// read rather than failing the clone, whichever way the data is copied.
+func TestCopyDir_ShrinkingSource(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		c    copier
+	}{
+		{name: "buffered", c: copier{bufSize: 4096}},
+		{name: "checksums", c: copier{bufSize: 4096, verifyChecksums: true}},
+		{name: "reflink", c: copier{reflink: true, verifyChecksums: true}},
+		{name: "direct", c: copier{directIO: true}},
+		{name: "tar", c: copier{viaTar: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := t.TempDir()
+			data := bytes.Repeat([]byte("live"), 16<<10)
+			if err := os.WriteFile(filepath.Join(src, "log"), data, 0644); err != nil {
+				t.Fatalf("write log: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "static"), []byte("static"), 0644); err != nil {
+				t.Fatalf("write static: %v", err)
+			}
+			const kept = 1000
+			c := tc.c
+			c.hook = func(_ context.Context, path string) error {
+				if filepath.Base(path) == "log" {
+					return os.Truncate(path, kept)
+				}
+				return nil
+			}
+
+			dst := t.TempDir()
+			var err error
+			if c.viaTar {
+				err = c.copyTar(context.Background(), src, dst)
+			} else {
+				err = c.copyDir(context.Background(), src, dst)
+			}
+			if err != nil {
+				t.Fatalf("copy: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(dst, "log"))
+			if err != nil {
+				t.Fatalf("read cloned log: %v", err)
+			}
+			if !bytes.Equal(got[:min(len(got), kept)], data[:kept]) {
+				t.Errorf("cloned log does not start with the source's remaining %d bytes", kept)
+			}
+			if !c.viaTar && len(got) != kept {
+				t.Errorf("cloned log is %d bytes, want %d", len(got), kept)
+			}
+			if c.bytes != int64(len(got))+int64(len("static")) {
+				t.Errorf("copied %d bytes, want %d", c.bytes, len(got)+len("static"))
+			}
+			if got, err := os.ReadFile(filepath.Join(dst, "static")); err != nil || string(got) != "static" {
+				t.Errorf("cloned static = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+// TestCopyWritableLayer_MultipleDirs verifies that the writable directories
+// of snapshots with several overlay mounts are each copied to the matching
+// destination directory.
+func TestCopyWritableLayer_MultipleDirs(t *testing.T) {
+	lower := t.TempDir()
+	overlayMount := func(upper string) mount.Mount {
+		return mount.Mount{
+			Type:    "overlay",
+			Source:  "overlay",
+			Options: []string{"workdir=" + t.TempDir(), "upperdir=" + upper, "lowerdir=" + lower},
+		}
+	}
+	src := []string{t.TempDir(), t.TempDir()}
+	dst := []string{t.TempDir(), t.TempDir()}
+	for i, dir := range src {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("upper%d.txt", i)), []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatalf("write upper%d.txt: %v", i, err)
+		}
+	}
+	// Stale content in the destination is cleared.
+	if err := os.WriteFile(filepath.Join(dst[1], "stale.txt"), nil, 0644); err != nil {
+		t.Fatalf("write stale.txt: %v", err)
+	}
+
+	c := &copier{}
+	srcMounts := []mount.Mount{overlayMount(src[0]), overlayMount(src[1])}
+	dstMounts := []mount.Mount{overlayMount(dst[0]), overlayMount(dst[1])}
+	if err := c.copyWritableLayer(context.Background(), srcMounts, dstMounts, true); err != nil {
+		t.Fatalf("copyWritableLayer: %v", err)
+	}
+	for i, dir := range dst {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("read destination %d: %v", i, err)
+		}
+		if len(entries) != 1 || entries[0].Name() != fmt.Sprintf("upper%d.txt", i) {
+			t.Errorf("destination %d holds %v, want only upper%d.txt", i, entries, i)
+		}
+	}
+
+	if err := c.copyWritableLayer(context.Background(), srcMounts, dstMounts[:1], true); !errors.Is(err, ErrUnsupportedBackend) {
+		t.Errorf("copyWritableLayer with unmatched directories error = %v, want ErrUnsupportedBackend", err)
+	}
+}
+
+// TestCopyWritableLayer_ReadOnlyDestination verifies that a destination on a
+// read-only mount is refused with ErrReadOnlyDestination before copying.
+func TestCopyWritableLayer_ReadOnlyDestination(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write file.txt: %v", err)
+	}
+	if err := unix.Mount(dst, dst, "", unix.MS_BIND, ""); err != nil {
+		t.Skipf("bind mount: %v", err)
+	}
+	defer unix.Unmount(dst, 0)
+	if err := unix.Mount("", dst, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, ""); err != nil {
+		t.Skipf("remount read-only: %v", err)
+	}
+
+	c := &copier{}
+	bind := func(dir string) []mount.Mount {
+		return []mount.Mount{{Type: "bind", Source: dir, Options: []string{"rbind", "rw"}}}
+	}
+	err := c.copyWritableLayer(context.Background(), bind(src), bind(dst), true)
+	if !errors.Is(err, ErrReadOnlyDestination) {
+		t.Fatalf("copyWritableLayer error = %v, want ErrReadOnlyDestination", err)
+	}
+	if c.files != 0 {
+		t.Errorf("%d files copied into a read-only destination", c.files)
+	}
+}
+
+// TestCopyWritableLayer_DebugMountOptions verifies that the options of an
+// overlay mount without an upperdir are logged at debug level only, and are
+// never put in the error.
+func TestCopyWritableLayer_DebugMountOptions(t *testing.T) {
+	dst := []mount.Mount{{
+		Type:    "overlay",
+		Source:  "overlay",
+		Options: []string{"lowerdir=/secret/lower", "workdir=/secret/work", "upper=/secret/upper"},
+	}}
+	src := []mount.Mount{{Type: "bind", Source: t.TempDir(), Options: []string{"rbind", "rw"}}}
+
+	for _, level := range []logrus.Level{logrus.InfoLevel, logrus.DebugLevel} {
+		var buf bytes.Buffer
+		logger := logrus.New()
+		logger.SetOutput(&buf)
+		logger.SetLevel(level)
+		ctx := log.WithLogger(context.Background(), logrus.NewEntry(logger))
+
+		c := &copier{}
+		err := c.copyWritableLayer(ctx, src, dst, true)
+		if err == nil {
+			t.Fatal("copyWritableLayer into an overlay mount without upperdir succeeded")
+		}
+		if strings.Contains(err.Error(), "/secret") {
+			t.Errorf("error leaks mount options: %v", err)
+		}
+		logged := strings.Contains(buf.String(), "upper=/secret/upper")
+		if want := level == logrus.DebugLevel; logged != want {
+			t.Errorf("at %v level, mount options logged = %t, want %t; log:\n%s", level, logged, want, buf.String())
+		}
+	}
+}
+
+// TestCopyDir_SetuidOwnership verifies that a setuid and setgid file owned
+// by an unprivileged user keeps its owner in the clone, and that it loses
+// those bits when the plugin cannot give it that owner, rather than
+// becoming setuid to the plugin's user, both when copied and when streamed
+// through a tar archive.
+func TestCopyDir_SetuidOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create files owned by another user")
+	}
+	src := t.TempDir()
+	file := filepath.Join(src, "tool")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("write tool: %v", err)
+	}
+	if err := os.Chown(file, 1000, 1000); err != nil {
+		t.Fatalf("chown tool: %v", err)
+	}
+	if err := os.Chmod(file, 0755|os.ModeSetuid|os.ModeSetgid); err != nil {
+		t.Fatalf("chmod tool: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		lchown func(path string, uid, gid int) error
+		uid    uint32
+		mode   os.FileMode
+	}{
+		{"privileged", nil, 1000, 0755 | os.ModeSetuid | os.ModeSetgid},
+		{"unprivileged", func(string, int, int) error { return unix.EPERM }, 0, 0755},
+	} {
+		for _, via := range []struct {
+			name string
+			copy func(c *copier, ctx context.Context, src, dst string) error
+		}{
+			{"copyDir", (*copier).copyDir},
+			{"copyTar", (*copier).copyTar},
+		} {
+			dst := t.TempDir()
+			if err := via.copy(&copier{lchown: tc.lchown}, context.Background(), src, dst); err != nil {
+				t.Fatalf("%s: %s: %v", tc.name, via.name, err)
+			}
+			fi, err := os.Stat(filepath.Join(dst, "tool"))
+			if err != nil {
+				t.Fatalf("%s: %s: stat copy: %v", tc.name, via.name, err)
+			}
+			st := fi.Sys().(*syscall.Stat_t)
+			if st.Uid != tc.uid || st.Gid != tc.uid {
+				t.Errorf("%s: %s: copy owned by %d:%d, want %d:%d", tc.name, via.name, st.Uid, st.Gid, tc.uid, tc.uid)
+			}
+			if got := modeBits(fi.Mode()); got != tc.mode {
+				t.Errorf("%s: %s: copy mode = %v, want %v", tc.name, via.name, got, tc.mode)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package snapshotter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CopyMethod selects how regular files are copied into a clone.
+type CopyMethod string
+
+const (
+	// CopyMethodCopy copies file data.  It is the default.
+	CopyMethodCopy CopyMethod = "copy"
+
+	// CopyMethodReflink shares file extents with the source through the
+	// FICLONE ioctl, copying the data where the filesystem does not support
+	// it.  The clone is as independent of its source as with
+	// [CopyMethodCopy].
+	CopyMethodReflink CopyMethod = "reflink"
+
+	// CopyMethodHardlink hard-links files from the source, as the
+	// [LabelCloneLazy] label does for a single clone.
+	CopyMethodHardlink CopyMethod = "hardlink"
+)
+
+// copyMethods lists the valid copy methods.
+var copyMethods = []CopyMethod{CopyMethodCopy, CopyMethodReflink, CopyMethodHardlink}
+
+// ParseCopyMethod returns the copy method named v.
+func ParseCopyMethod(v string) (CopyMethod, error) {
+	for _, m := range copyMethods {
+		if string(m) == v {
+			return m, nil
+		}
+	}
+	names := make([]string, len(copyMethods))
+	for i, m := range copyMethods {
+		names[i] = string(m)
+	}
+	return "", fmt.Errorf("unknown copy method %q (valid: %s)", v, strings.Join(names, ", "))
+}
@@ -0,0 +1,84 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_CopyMethod verifies that copy and reflink clones are
+// independent of their source while hardlink clones share its inodes.
+func TestPrepare_Clone_CopyMethod(t *testing.T) {
+	for _, tc := range []struct {
+		method snapshotter.CopyMethod
+		shared bool
+	}{
+		{method: snapshotter.CopyMethodCopy},
+		{method: snapshotter.CopyMethodReflink},
+		{method: snapshotter.CopyMethodHardlink, shared: true},
+	} {
+		t.Run(string(tc.method), func(t *testing.T) {
+			ctx := context.Background()
+			sn, cleanup := newTestSnapshotter(t)
+			defer cleanup()
+			sn.CopyMethod = tc.method
+
+			if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+				t.Fatalf("Prepare source: %v", err)
+			}
+			srcDir := writableDir(t, sn, "source")
+			if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("source"), 0644); err != nil {
+				t.Fatalf("write data.txt: %v", err)
+			}
+			if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+				snapshotter.LabelCloneSource: "source",
+			})); err != nil {
+				t.Fatalf("Prepare clone: %v", err)
+			}
+			dstDir := writableDir(t, sn, "clone")
+			assertFileContent(t, dstDir, "data.txt", "source")
+
+			src, err := os.Stat(filepath.Join(srcDir, "data.txt"))
+			if err != nil {
+				t.Fatalf("stat source file: %v", err)
+			}
+			dst, err := os.Stat(filepath.Join(dstDir, "data.txt"))
+			if err != nil {
+				t.Fatalf("stat clone file: %v", err)
+			}
+			if shared := os.SameFile(src, dst); shared != tc.shared {
+				t.Errorf("clone file shares the source inode = %v, want %v", shared, tc.shared)
+			}
+			if tc.shared {
+				if n := dst.Sys().(*syscall.Stat_t).Nlink; n != 2 {
+					t.Errorf("link count = %d, want 2", n)
+				}
+				return
+			}
+
+			if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("changed"), 0644); err != nil {
+				t.Fatalf("modify source: %v", err)
+			}
+			assertFileContent(t, dstDir, "data.txt", "source")
+		})
+	}
+}
+
+// TestParseCopyMethod verifies that only the known copy methods parse.
+func TestParseCopyMethod(t *testing.T) {
+	for _, v := range []string{"copy", "reflink", "hardlink"} {
+		if m, err := snapshotter.ParseCopyMethod(v); err != nil || string(m) != v {
+			t.Errorf("ParseCopyMethod(%q) = %q, %v", v, m, err)
+		}
+	}
+	for _, v := range []string{"", "symlink", "Copy"} {
+		if _, err := snapshotter.ParseCopyMethod(v); err == nil {
+			t.Errorf("ParseCopyMethod(%q) succeeded, want an error", v)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directAlign is the alignment of O_DIRECT buffers, lengths and file
+// offsets: the page size, a multiple of the logical block size of the
+// devices in common use.
+const directAlign = 4096
+
+// copyDirect copies in to w, which writes to out, with O_DIRECT set on both
+// files so that the data bypasses the page cache.  It reports false, having
+// copied nothing, if the filesystems do not support O_DIRECT.  Reads and
+// writes that cannot be done directly, such as those of the unaligned tail
+// of a file, go through the page cache instead.
+//
+// The data is checksummed into h, if set, and throttled by c.limiter like a
+// buffered copy.  Once more than the bytes left under c.maxBytes have been
+// copied, copyDirect stops and leaves it to the caller to report.
+func (c *copier) copyDirect(ctx context.Context, in, out *os.File, w io.Writer, h hash.Hash32) (n int64, ok bool, err error) {
+	if err := c.setDirect(in, true); err != nil {
+		return 0, false, nil
+	}
+	if err := c.setDirect(out, true); err != nil {
+		// The file is closed after the copy; its flags do not matter.
+		_ = c.setDirect(in, false)
+		return 0, false, nil
+	}
+	inDirect, outDirect := true, true
+
+	if c.directBuf == nil {
+		size := c.bufSize
+		if size <= 0 {
+			size = defaultCopyBufferSize
+		}
+		c.directBuf = alignedBuffer(size)
+	}
+	buf := c.directBuf
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, true, err
+		}
+		if c.maxBytes > 0 && n > c.maxBytes-c.bytes {
+			return n, true, nil
+		}
+
+		m, rerr := in.Read(buf)
+		if errors.Is(rerr, unix.EINVAL) && inDirect {
+			// The filesystem rejects this direct read; read the rest
+			// through the page cache.
+			inDirect = false
+			if err := c.setDirect(in, false); err != nil {
+				return n, true, err
+			}
+			continue
+		}
+		if m > 0 {
+			if m < len(buf) && inDirect {
+				// A short read leaves the offset unaligned.
+				inDirect = false
+				if err := c.setDirect(in, false); err != nil {
+					return n, true, err
+				}
+			}
+			if h != nil {
+				h.Write(buf[:m])
+			}
+			if c.limiter != nil {
+				if err := waitBytes(ctx, c.limiter, m); err != nil {
+					return n, true, err
+				}
+			}
+			if m%directAlign != 0 && outDirect {
+				outDirect = false
+				if err := c.setDirect(out, false); err != nil {
+					return n, true, err
+				}
+			}
+			_, err := w.Write(buf[:m])
+			if errors.Is(err, unix.EINVAL) && outDirect {
+				// A rejected direct write writes nothing; write
+				// through the page cache instead.
+				outDirect = false
+				if err = c.setDirect(out, false); err == nil {
+					_, err = w.Write(buf[:m])
+				}
+			}
+			if err != nil {
+				return n, true, err
+			}
+			n += int64(m)
+		}
+		if rerr == io.EOF {
+			return n, true, nil
+		}
+		if rerr != nil {
+			return n, true, rerr
+		}
+	}
+}
+
+// setDirect turns O_DIRECT on or off for f.
+func (c *copier) setDirect(f *os.File, on bool) error {
+	if c.directFlag != nil {
+		return c.directFlag(f, on)
+	}
+	return setDirectFlag(f, on)
+}
+
+// setDirectFlag turns O_DIRECT on or off for f with fcntl.
+func setDirectFlag(f *os.File, on bool) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if on {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags)
+	return err
+}
+
+// alignedBuffer returns a buffer of at least size bytes, rounded up to a
+// multiple of directAlign, whose address is aligned to directAlign.
+func alignedBuffer(size int) []byte {
+	size = (size + directAlign - 1) &^ (directAlign - 1)
+	buf := make([]byte, size+directAlign)
+	off := int(uintptr(unsafe.Pointer(&buf[0])) & (directAlign - 1))
+	if off != 0 {
+		off = directAlign - off
+	}
+	return buf[off : off+size]
+}
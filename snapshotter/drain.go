@@ -0,0 +1,23 @@
+package snapshotter
+
+import "context"
+
+// Drain blocks until every clone in progress has finished, or until ctx is
+// done, in which case it returns ctx's error.  It does not stop new clones
+// from starting, so it is meant to be called once clients have been told to
+// stop sending them, e.g. by reporting NOT_SERVING to health checks, and
+// before the server is stopped.
+func (s *CloneSnapshotter) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
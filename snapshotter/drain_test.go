@@ -0,0 +1,82 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestDrain verifies that Drain waits for a clone in progress to finish and
+// gives up when its context expires first.
+func TestDrain(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+
+	// Nothing in progress: Drain returns at once.
+	if err := sn.Drain(ctx); err != nil {
+		t.Fatalf("Drain with no clones: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	copying, release := make(chan struct{}), make(chan struct{})
+	sn.copyHook = func(context.Context, string) error {
+		close(copying)
+		<-release
+		return nil
+	}
+	cloned := make(chan error, 1)
+	go func() {
+		_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+			LabelCloneSource: "source",
+		}))
+		cloned <- err
+	}()
+	<-copying
+
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := sn.Drain(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain with a clone in progress = %v, want context.DeadlineExceeded", err)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- sn.Drain(ctx) }()
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned %v before the clone finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	select {
+	case err := <-cloned:
+		if err != nil {
+			t.Fatalf("Prepare clone: %v", err)
+		}
+	default:
+		t.Fatal("Drain returned before the clone's Prepare did")
+	}
+}
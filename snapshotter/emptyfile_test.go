@@ -0,0 +1,75 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_EmptyFiles verifies that empty regular files are created
+// in the clone with their mode by every way of copying files.
+func TestPrepare_Clone_EmptyFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method snapshotter.CopyMethod
+		direct bool
+		labels map[string]string
+	}{
+		{name: "copy"},
+		{name: "direct", direct: true},
+		{name: "reflink", method: snapshotter.CopyMethodReflink},
+		{name: "hardlink", method: snapshotter.CopyMethodHardlink},
+		{name: "tar", labels: map[string]string{snapshotter.LabelCloneViaTar: "true"}},
+		{name: "metadata-only", labels: map[string]string{snapshotter.LabelCloneMetadataOnly: "true"}},
+		{name: "incremental", labels: map[string]string{snapshotter.LabelCloneIncremental: "true"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			sn, cleanup := newTestSnapshotter(t)
+			defer cleanup()
+			sn.CopyMethod = tc.method
+			sn.DirectIO = tc.direct
+
+			if _, err := sn.Prepare(ctx, "empty-src", ""); err != nil {
+				t.Fatalf("Prepare empty-src: %v", err)
+			}
+			srcDir := writableDir(t, sn, "empty-src")
+			if err := os.Mkdir(filepath.Join(srcDir, "dir"), 0755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+			files := map[string]os.FileMode{"empty": 0600, "dir/empty-exec": 0755, "dir/.keep": 0444}
+			for name, mode := range files {
+				path := filepath.Join(srcDir, name)
+				if err := os.WriteFile(path, nil, mode); err != nil {
+					t.Fatalf("write %s: %v", name, err)
+				}
+				if err := os.Chmod(path, mode); err != nil {
+					t.Fatalf("chmod %s: %v", name, err)
+				}
+			}
+
+			labels := map[string]string{snapshotter.LabelCloneSource: "empty-src"}
+			for k, v := range tc.labels {
+				labels[k] = v
+			}
+			if _, err := sn.Prepare(ctx, "empty-clone", "", snapshots.WithLabels(labels)); err != nil {
+				t.Fatalf("Prepare empty-clone: %v", err)
+			}
+			cloneDir := writableDir(t, sn, "empty-clone")
+			for name, mode := range files {
+				fi, err := os.Lstat(filepath.Join(cloneDir, name))
+				if err != nil {
+					t.Errorf("%s missing from the clone: %v", name, err)
+					continue
+				}
+				if !fi.Mode().IsRegular() || fi.Size() != 0 || fi.Mode().Perm() != mode {
+					t.Errorf("%s: mode %v size %d, want an empty regular file with mode %v", name, fi.Mode(), fi.Size(), mode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// parseFitBudget parses the value of [LabelCloneFitBudget].
+func parseFitBudget(v string) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s label %q, want a positive number of bytes: %w", LabelCloneFitBudget, v, errdefs.ErrInvalidArgument)
+	}
+	return n, nil
+}
+
+// fitFile is a regular file of the source considered by planFit.
+type fitFile struct {
+	path string
+	size int64
+}
+
+// planFit fits the writable directory srcDir, whose regular files take
+// size bytes, in what is left of a fit clone's budget and returns the size
+// of the files that are copied.  If they do not all fit, the smallest are
+// kept, in ascending size order, until the next one would not fit, and
+// every larger file is added to c.fitSkip to be left out.
+func (c *copier) planFit(srcDir string, size uint64) (uint64, error) {
+	left := c.fitBudget - c.fitSpent
+	if size <= uint64(left) {
+		c.fitSpent += int64(size)
+		return size, nil
+	}
+
+	var files []fitFile
+	var rootDev uint64
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if path == srcDir {
+			rootDev = c.device(info)
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if c.excluded(rel) || (!c.crossMounts && c.device(info) != rootDev) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, fitFile{path, info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Equal sizes are ordered by path so that the plan is reproducible.
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].size != files[j].size {
+			return files[i].size < files[j].size
+		}
+		return files[i].path < files[j].path
+	})
+	var kept int64
+	for i, f := range files {
+		if f.size > left-kept {
+			if c.fitSkip == nil {
+				c.fitSkip = make(map[string]int64, len(files)-i)
+			}
+			for _, skipped := range files[i:] {
+				c.fitSkip[skipped.path] = skipped.size
+			}
+			break
+		}
+		kept += f.size
+	}
+	c.fitSpent += kept
+	return uint64(kept), nil
+}
+
+// skipUnfit leaves the source file src out of a fit clone if planFit chose
+// to, logging it and recording it in c.skipped, and reports whether it did.
+func (c *copier) skipUnfit(ctx context.Context, src string) bool {
+	size, ok := c.fitSkip[src]
+	if !ok {
+		return false
+	}
+	log.G(ctx).WithField("path", src).WithField("size", size).Warn("fit clone: skipping file that does not fit the budget")
+	c.skipped = append(c.skipped, src)
+	return true
+}
@@ -0,0 +1,69 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_FitBudget verifies that a clone over its fit budget
+// keeps the smallest files that fit and leaves out the largest ones,
+// counting them as skipped.
+func TestPrepare_Clone_FitBudget(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	if err := os.MkdirAll(filepath.Join(srcDir, "big"), 0755); err != nil {
+		t.Fatalf("create big: %v", err)
+	}
+	sizes := map[string]int{"a.txt": 100, "b.txt": 200, "big/c.bin": 300, "d.bin": 5000, "big/e.bin": 8000}
+	for name, size := range sizes {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(strings.Repeat("x", size)), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", map[string]string{
+		snapshotter.LabelCloneFitBudget: "700",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "clone")
+	for name, size := range sizes {
+		fi, err := os.Stat(filepath.Join(cloneDir, name))
+		switch {
+		case size <= 300 && (err != nil || fi.Size() != int64(size)):
+			t.Errorf("%s: small file not copied: %v", name, err)
+		case size > 300 && !os.IsNotExist(err):
+			t.Errorf("%s: large file copied although it does not fit: %v", name, err)
+		}
+	}
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelCloneSkipped]; got != "2" {
+		t.Errorf("label clone-skipped = %q, want 2", got)
+	}
+	if got := info.Labels[snapshotter.LabelCloneBytes]; got != "600" {
+		t.Errorf("label clone-bytes = %q, want 600", got)
+	}
+
+	_, err = sn.Prepare(ctx, "bad", "", snapshotter.WithCloneLabels("source", map[string]string{
+		snapshotter.LabelCloneFitBudget: "-1",
+	}))
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Prepare with a negative budget error = %v, want ErrInvalidArgument", err)
+	}
+}
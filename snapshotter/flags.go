@@ -0,0 +1,110 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Inode flags from linux/fs.h, as set by chattr.
+const (
+	fsImmutableFl = 0x00000010 // FS_IMMUTABLE_FL, chattr +i
+	fsAppendFl    = 0x00000020 // FS_APPEND_FL, chattr +a
+	fsNodumpFl    = 0x00000040 // FS_NODUMP_FL, chattr +d
+	fsNoatimeFl   = 0x00000080 // FS_NOATIME_FL, chattr +A
+)
+
+// preservedInodeFlags are the inode flags a clone reproduces.  Flags
+// describing the source filesystem's own on-disk layout, such as
+// FS_EXTENT_FL, are left to the destination filesystem.
+const preservedInodeFlags = fsImmutableFl | fsAppendFl | fsNodumpFl | fsNoatimeFl
+
+// inodeFlags returns the inode flags of the regular file or directory at
+// path, read with the FS_IOC_GETFLAGS ioctl.
+func inodeFlags(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+}
+
+// flagsUnsupported reports whether err means that a filesystem does not
+// support inode flags.
+func flagsUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL)
+}
+
+// copyInodeFlags sets the preserved inode flags of src on dst.  It must run
+// after dst's content and metadata are final, since an immutable or
+// append-only dst can no longer be changed.  Filesystems without inode flag
+// support on either side are skipped silently.
+func copyInodeFlags(src, dst string) error {
+	flags, err := inodeFlags(src)
+	if flagsUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get inode flags of %s: %w", src, err)
+	}
+	flags &= preservedInodeFlags
+	if flags == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(dst, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cur, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if flagsUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get inode flags of %s: %w", dst, err)
+	}
+	err = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, cur|flags)
+	if flagsUnsupported(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("set inode flags %#x on %s: %w", flags, dst, err)
+	}
+	return nil
+}
+
+// clearRemovalFlags clears the immutable and append-only flags, which
+// prevent removing a file or the entries of a directory, from path and
+// everything below it.  It is best effort: errors are ignored, since the
+// removal it prepares reports what still cannot be removed.
+func clearRemovalFlags(path string) {
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() || d.IsDir() {
+			clearFlags(p, fsImmutableFl|fsAppendFl)
+		}
+		return nil
+	})
+}
+
+// clearFlags clears flags from the inode flags of the regular file or
+// directory at path, ignoring errors.
+func clearFlags(path string, flags int) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	cur, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err == nil && cur&flags != 0 {
+		_ = unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, cur&^flags)
+	}
+}
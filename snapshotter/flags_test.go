@@ -0,0 +1,76 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// fsAppendFl is FS_APPEND_FL from linux/fs.h, set by chattr +a.
+const fsAppendFl = 0x20
+
+// setInodeFlags sets flags, replacing any others, on the file at path.
+func setInodeFlags(path string, flags int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return unix.IoctlSetPointerInt(int(f.Fd()), unix.FS_IOC_SETFLAGS, flags)
+}
+
+// getInodeFlags returns the inode flags of the file at path.
+func getInodeFlags(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	flags, err := unix.IoctlGetInt(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		t.Fatalf("get inode flags of %s: %v", path, err)
+	}
+	return flags
+}
+
+// TestPrepare_Clone_AppendOnly verifies that the append-only inode flag of a
+// source file is reproduced on its copy.  Setting the flag requires
+// CAP_LINUX_IMMUTABLE and a filesystem that supports it.
+func TestPrepare_Clone_AppendOnly(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "attr-src", ""); err != nil {
+		t.Fatalf("Prepare attr-src: %v", err)
+	}
+	srcFile := filepath.Join(writableDir(t, sn, "attr-src"), "audit.log")
+	if err := os.WriteFile(srcFile, []byte("entry 1\n"), 0644); err != nil {
+		t.Fatalf("write audit.log: %v", err)
+	}
+	flags := getInodeFlags(t, srcFile)
+	if err := setInodeFlags(srcFile, flags|fsAppendFl); err != nil {
+		t.Skipf("set append-only flag: %v", err)
+	}
+	t.Cleanup(func() { setInodeFlags(srcFile, flags) })
+
+	if _, err := sn.Prepare(ctx, "attr-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "attr-src",
+	})); err != nil {
+		t.Fatalf("Prepare attr-clone: %v", err)
+	}
+	cloneFile := filepath.Join(writableDir(t, sn, "attr-clone"), "audit.log")
+	cloneFlags := getInodeFlags(t, cloneFile)
+	t.Cleanup(func() { setInodeFlags(cloneFile, cloneFlags&^fsAppendFl) })
+
+	if cloneFlags&fsAppendFl == 0 {
+		t.Errorf("clone inode flags = %#x, want the append-only flag %#x set", cloneFlags, fsAppendFl)
+	}
+	assertFileContent(t, filepath.Dir(cloneFile), "audit.log", "entry 1\n")
+}
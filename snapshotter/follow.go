@@ -0,0 +1,124 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSymlinkLoop is returned (wrapped) by Prepare when a clone following
+// symlinks, see [LabelCloneFollowSymlinks], meets a link that never resolves
+// because it leads back to itself, or one that leads to a directory it is
+// inside of, which would be copied into itself without end.
+var ErrSymlinkLoop = errors.New("symlink loop")
+
+// maxSymlinkHops is the number of links resolving a path may go through, as
+// for the kernel's ELOOP, before it is taken to be a loop.
+const maxSymlinkHops = 40
+
+// resolveInRoot resolves the path rel, relative to root, following every
+// symlink on it as if root were the root directory: absolute targets start
+// from root and ".." never leaves it.  It returns the resolved path below
+// root.
+func resolveInRoot(root, rel string) (string, error) {
+	pending := strings.Split(rel, "/")
+	resolved := ""
+	for hops := 0; len(pending) > 0; {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", fmt.Errorf("%s does not resolve after %d links: %w", rel, maxSymlinkHops, ErrSymlinkLoop)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// followLink resolves the symlink at path, below the source root, for a
+// clone following symlinks.  It returns what the link leads to and its
+// stat result, or a nil result if the link is dangling.
+func (c *copier) followLink(path string) (string, fs.FileInfo, error) {
+	rel, err := filepath.Rel(c.root, path)
+	if err != nil {
+		return "", nil, err
+	}
+	target, err := resolveInRoot(c.root, rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		for _, dir := range append([]string{path}, c.following...) {
+			if within(dir, target) {
+				return "", nil, fmt.Errorf("%s leads to %s, which it is inside of: %w", path, target, ErrSymlinkLoop)
+			}
+		}
+	}
+	return target, info, nil
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// copyFollowedDir copies the source directory target, reached through a
+// link at rel in the clone, to dst, a directory with the mode bits of
+// target described by info.
+func (c *copier) copyFollowedDir(ctx context.Context, target, dst, rel string, info fs.FileInfo) error {
+	if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	owned, err := c.copyOwner(target, dst)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, ownedMode(modeBits(info.Mode()), owned)); err != nil {
+		return err
+	}
+	if err := copyXattrs(target, dst, aclXattrs); err != nil {
+		return err
+	}
+	if err := c.markProvenance(ctx, dst); err != nil {
+		return err
+	}
+	following, linkRel := c.following, c.linkRel
+	c.following, c.linkRel = append(following, target), rel
+	defer func() { c.following, c.linkRel = following, linkRel }()
+	return c.copyDir(ctx, target, dst)
+}
@@ -0,0 +1,111 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_FollowSymlinks verifies that a deep clone copies what
+// links lead to, resolving them inside the source, and keeps dangling links.
+func TestPrepare_Clone_FollowSymlinks(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	if err := os.MkdirAll(filepath.Join(srcDir, "data", "sub"), 0755); err != nil {
+		t.Fatalf("create data: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data", "sub", "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write file.txt: %v", err)
+	}
+	links := map[string]string{
+		"file-link": "data/sub/file.txt",
+		"dir-link":  "data",
+		"abs-link":  "/data/sub",
+		"escape":    "../../../data/sub/file.txt",
+		"dangling":  "missing",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(srcDir, name)); err != nil {
+			t.Fatalf("symlink %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", map[string]string{
+		snapshotter.LabelCloneFollowSymlinks: "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "clone")
+	for _, name := range []string{"file-link", "dir-link/sub/file.txt", "abs-link/file.txt", "escape"} {
+		path := filepath.Join(cloneDir, name)
+		if fi, err := os.Lstat(path); err != nil || !fi.Mode().IsRegular() {
+			t.Errorf("%s is not a regular file: %v", name, err)
+			continue
+		}
+		assertFileContent(t, cloneDir, name, "data")
+	}
+	if target, err := os.Readlink(filepath.Join(cloneDir, "dangling")); err != nil || target != "missing" {
+		t.Errorf("dangling link = %q, %v, want it kept pointing to missing", target, err)
+	}
+}
+
+// TestPrepare_Clone_FollowSymlinks_Loop verifies that a deep clone fails
+// with ErrSymlinkLoop, rather than hanging, on a self-referential link and
+// on a link leading to a directory it is inside of.
+func TestPrepare_Clone_FollowSymlinks_Loop(t *testing.T) {
+	for name, link := range map[string][2]string{
+		"self":     {"self", "self"},
+		"chain":    {"a", "b"},
+		"ancestor": {"dir/sub/up", "../.."},
+		"absolute": {"dir/root", "/"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			sn, cleanup := newTestSnapshotter(t)
+			defer cleanup()
+
+			if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+				t.Fatalf("Prepare source: %v", err)
+			}
+			srcDir := writableDir(t, sn, "source")
+			if err := os.MkdirAll(filepath.Join(srcDir, "dir", "sub"), 0755); err != nil {
+				t.Fatalf("create dir: %v", err)
+			}
+			if err := os.Symlink(link[1], filepath.Join(srcDir, link[0])); err != nil {
+				t.Fatalf("symlink: %v", err)
+			}
+			if name == "chain" {
+				if err := os.Symlink("a", filepath.Join(srcDir, "b")); err != nil {
+					t.Fatalf("symlink: %v", err)
+				}
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", map[string]string{
+					snapshotter.LabelCloneFollowSymlinks: "true",
+				}))
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, snapshotter.ErrSymlinkLoop) {
+					t.Errorf("Prepare clone error = %v, want ErrSymlinkLoop", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("deep clone did not detect the symlink loop")
+			}
+		})
+	}
+}
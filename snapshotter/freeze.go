@@ -0,0 +1,75 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem freeze ioctls from linux/fs.h.
+const (
+	fiFreeze = 0xc0045877 // FIFREEZE, _IOWR('X', 119, int)
+	fiThaw   = 0xc0045878 // FITHAW, _IOWR('X', 120, int)
+)
+
+// freezeFS freezes the filesystem holding the open file f; thawFS thaws it.
+func freezeFS(f *os.File) error { return unix.IoctlSetInt(int(f.Fd()), fiFreeze, 0) }
+func thawFS(f *os.File) error   { return unix.IoctlSetInt(int(f.Fd()), fiThaw, 0) }
+
+// freezeSource freezes the filesystem holding the writable directory srcDir
+// for a point-in-time clone into dstDir, and returns a function that thaws
+// it again.  A consistent copy cannot be made when dstDir is on the same
+// filesystem, since the copy could not be written while it is frozen, or
+// when the filesystem cannot be frozen; both fail with
+// [errdefs.ErrFailedPrecondition] rather than copying live.
+//
+// FIFREEZE blocks writes to the whole filesystem, not only to the source
+// layer, so every process on the host writing to it waits until the thaw.
+// The kernel does not thaw a filesystem when the process that froze it
+// exits: if the plugin crashes mid-copy, the filesystem stays frozen until
+// it is thawed by hand with "fsfreeze -u".
+func (c *copier) freezeSource(ctx context.Context, srcDir, dstDir string) (thaw func() error, err error) {
+	srcInfo, err := os.Stat(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	dstInfo, err := os.Stat(dstDir)
+	if err != nil {
+		return nil, err
+	}
+	if c.device(srcInfo) == c.device(dstInfo) {
+		return nil, fmt.Errorf("%s: the source is on the filesystem the clone is written to, which cannot be written while frozen: %w",
+			LabelCloneFreeze, errdefs.ErrFailedPrecondition)
+	}
+
+	freeze, thawIoctl := c.freezeFS, c.thawFS
+	if freeze == nil {
+		freeze, thawIoctl = freezeFS, thawFS
+	}
+	f, err := os.Open(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := freeze(f); err != nil {
+		f.Close()
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EBUSY) {
+			// Not permitted, not supported, or frozen by someone else,
+			// who would also thaw it under us.
+			return nil, fmt.Errorf("%s: cannot freeze the source filesystem: %v: %w", LabelCloneFreeze, err, errdefs.ErrFailedPrecondition)
+		}
+		return nil, fmt.Errorf("freeze source filesystem: %w", err)
+	}
+	return func() error {
+		defer f.Close()
+		if err := thawIoctl(f); err != nil {
+			log.G(ctx).WithError(err).WithField("source", srcDir).Error("freeze clone: cannot thaw the source filesystem, thaw it with fsfreeze -u")
+			return fmt.Errorf("thaw source filesystem: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,83 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+// TestCopyLayerDir_Freeze verifies that a freeze clone freezes the source
+// filesystem before copying and thaws it afterwards, also when the copy
+// fails, and that it fails without copying when the source cannot be
+// frozen.
+func TestCopyLayerDir_Freeze(t *testing.T) {
+	ctx := context.Background()
+	errCopy := errors.New("copy failed")
+	for _, tc := range []struct {
+		name      string
+		sameFS    bool
+		freezeErr error
+		copyErr   error
+		wantErr   error
+		want      []string
+	}{
+		{name: "frozen", want: []string{"freeze", "copy", "thaw"}},
+		{name: "copy fails", copyErr: errCopy, wantErr: errCopy, want: []string{"freeze", "copy", "thaw"}},
+		{name: "same filesystem", sameFS: true, wantErr: errdefs.ErrFailedPrecondition},
+		{name: "not permitted", freezeErr: unix.EPERM, wantErr: errdefs.ErrFailedPrecondition, want: []string{"freeze"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			src, dst := filepath.Join(root, "src"), filepath.Join(root, "dst")
+			for _, dir := range []string{src, dst} {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					t.Fatalf("create %s: %v", dir, err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(src, "data.txt"), []byte("data"), 0644); err != nil {
+				t.Fatalf("write data.txt: %v", err)
+			}
+
+			var events []string
+			c := &copier{
+				freeze: true,
+				freezeFS: func(*os.File) error {
+					events = append(events, "freeze")
+					return tc.freezeErr
+				},
+				thawFS: func(*os.File) error {
+					events = append(events, "thaw")
+					return nil
+				},
+				hook: func(context.Context, string) error {
+					events = append(events, "copy")
+					return tc.copyErr
+				},
+			}
+			if !tc.sameFS {
+				// The destination is on a filesystem of its own.
+				c.deviceOf = func(info fs.FileInfo) uint64 {
+					if info.Name() == "dst" {
+						return 2
+					}
+					return 1
+				}
+			}
+
+			err := c.copyLayerDir(ctx, src, dst, false)
+			if !errors.Is(err, tc.wantErr) || tc.wantErr == nil && err != nil {
+				t.Errorf("copyLayerDir error = %v, want %v", err, tc.wantErr)
+			}
+			if !slices.Equal(events, tc.want) {
+				t.Errorf("events = %q, want %q", events, tc.want)
+			}
+		})
+	}
+}
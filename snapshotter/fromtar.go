@@ -0,0 +1,220 @@
+package snapshotter
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/archive"
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tarPrepare implements Prepare for a snapshot seeded from an archive: it
+// prepares the snapshot key on parent and extracts the archive named by the
+// [LabelCloneFromTar] label in labels into its writable directory.  A
+// snapshot whose extraction fails is removed.
+//
+// Seeding is subject to the limits of a clone: it can be aborted and is
+// drained like one, runs under CloneTimeout and CopyRateLimit, and the
+// archive's content is measured up front and checked against
+// MaxCloneBytes, the free space and inodes of the destination and the
+// namespace's quota before anything is extracted.
+func (s *CloneSnapshotter) tarPrepare(ctx context.Context, key, parent string, labels map[string]string, opts []snapshots.Opt) (_ []mount.Mount, retErr error) {
+	s.inflight.Add(1)
+	defer s.inflight.Done()
+
+	ctx, span := s.tracer.Start(ctx, "clone_from_tar", trace.WithAttributes(
+		attribute.String("clone.archive", labels[LabelCloneFromTar]),
+		attribute.String("clone.key", key),
+	))
+	defer func() { endSpan(span, retErr) }()
+
+	ctx, unregister := s.registerClone(ctx, key)
+	defer unregister()
+	defer func() {
+		if errors.Is(retErr, context.Canceled) && errors.Is(context.Cause(ctx), errCloneAborted) {
+			retErr = fmt.Errorf("clone into %q aborted: %w", key, retErr)
+		}
+	}()
+
+	path, err := s.archivePath(labels[LabelCloneFromTar])
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	defer f.Close()
+
+	release, err := s.acquireSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if s.CloneTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.CloneTimeout)
+		defer cancel()
+		defer func() {
+			if errors.Is(retErr, context.DeadlineExceeded) {
+				retErr = fmt.Errorf("extraction of %s timed out after %v: %w", path, s.CloneTimeout, retErr)
+			}
+		}()
+	}
+
+	size, entries, err := measureArchive(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("measure archive %s: %w", path, err)
+	}
+	if s.MaxCloneBytes > 0 && size > uint64(s.MaxCloneBytes) {
+		return nil, fmt.Errorf("%w: archive holds %d bytes, limit is %d", ErrCloneTooLarge, size, s.MaxCloneBytes)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind archive: %w", err)
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	if quota, ok := s.NamespaceQuotas[ns]; ok {
+		if err := s.reserveQuota(ns, quota, int64(size)); err != nil {
+			return nil, err
+		}
+		defer func() { s.settleQuota(ns, int64(size), int64(size), retErr == nil) }()
+	}
+
+	mounts, err := s.Snapshotter.Prepare(ctx, key, parent, withoutLabels(opts, cloneControlLabels...)...)
+	if err != nil {
+		return nil, fmt.Errorf("prepare snapshot %q: %w", key, err)
+	}
+	if err := s.applyArchive(ctx, f, mounts, size, entries); err != nil {
+		// The clone's context may be the reason for the failure; clean up
+		// regardless of it.
+		if removeErr := s.Remove(context.WithoutCancel(ctx), key); removeErr != nil {
+			s.cleanupFailed(ctx, key, removeErr)
+			return nil, fmt.Errorf("extract archive: %w (cleanup also failed: %v)", err, removeErr)
+		}
+		return nil, fmt.Errorf("extract archive %s into %q: %w", path, key, err)
+	}
+	return mounts, nil
+}
+
+// measureArchive returns the size of the regular files in the archive read
+// from r, plain or compressed, and its number of entries, as dirUsage does
+// for a directory.
+func measureArchive(ctx context.Context, r io.Reader) (size, entries uint64, err error) {
+	ds, err := compression.DecompressStream(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer ds.Close()
+
+	tr := tar.NewReader(ds)
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return size, entries, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		entries++
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			size += uint64(hdr.Size)
+		}
+	}
+}
+
+// archivePath resolves the [LabelCloneFromTar] label value v to the path of
+// an archive under ArchiveDir.  The path is checked both as given, before
+// anything outside ArchiveDir is looked at, and with symbolic links
+// resolved, so that a link under ArchiveDir cannot give access to files
+// elsewhere.
+func (s *CloneSnapshotter) archivePath(v string) (string, error) {
+	if s.ArchiveDir == "" {
+		return "", fmt.Errorf("%s: no archive directory is configured: %w", LabelCloneFromTar, errdefs.ErrFailedPrecondition)
+	}
+	outside := fmt.Errorf("%s %q: must be an absolute path under %s: %w", LabelCloneFromTar, v, s.ArchiveDir, errdefs.ErrInvalidArgument)
+	if !filepath.IsAbs(v) || !underDir(filepath.Clean(v), filepath.Clean(s.ArchiveDir)) {
+		return "", outside
+	}
+	dir, err := filepath.EvalSymlinks(s.ArchiveDir)
+	if err != nil {
+		return "", fmt.Errorf("resolve archive directory: %w", err)
+	}
+	path, err := filepath.EvalSymlinks(v)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s %q: %w", LabelCloneFromTar, v, errdefs.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve archive: %w", err)
+	}
+	if !underDir(path, dir) {
+		return "", outside
+	}
+	return path, nil
+}
+
+// underDir reports whether the clean path lies below the clean directory
+// dir.
+func underDir(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// applyArchive extracts the archive read from r, plain or compressed, into
+// the writable directory of mounts, once the destination is found to have
+// room for the size bytes and entries measured by measureArchive.
+// Whiteouts in the archive delete the files of the parent they name; on
+// overlay mounts they are converted to overlay whiteouts instead.
+func (s *CloneSnapshotter) applyArchive(ctx context.Context, r io.Reader, mounts []mount.Mount, size, entries uint64) error {
+	dirs, err := getWritableDirs(mounts)
+	if err != nil {
+		debugMountOptions(ctx, "destination", mounts)
+		return fmt.Errorf("destination: %w", err)
+	}
+	if len(dirs) != 1 {
+		return fmt.Errorf("an archive holds a single layer but the destination has %d writable directories: %w",
+			len(dirs), ErrUnsupportedBackend)
+	}
+	if err := checkWritable(dirs[0]); err != nil {
+		return err
+	}
+	if err := checkSpace(size, dirs[0], s.SpaceMargin); err != nil {
+		return err
+	}
+	if !s.SkipInodeCheck {
+		if err := checkInodes(entries, dirs[0], s.statfs); err != nil {
+			return err
+		}
+	}
+
+	ds, err := compression.DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+	var tr io.Reader = ds
+	if l := s.copyLimiter(); l != nil {
+		tr = rateReader{ctx, ds, l}
+	}
+
+	var applyOpts []archive.ApplyOpt
+	if mounts[0].Type == "overlay" {
+		applyOpts = append(applyOpts, archive.WithConvertWhiteout(archive.OverlayConvertWhiteout))
+	}
+	_, err = archive.Apply(ctx, dirs[0], tr, applyOpts...)
+	return err
+}
@@ -0,0 +1,187 @@
+package snapshotter_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// writeArchive writes a tar archive of hdrs, with the given content for
+// regular files, to path, gzip compressed if compress is set.
+func writeArchive(t *testing.T, path string, compress bool, hdrs []*tar.Header, content map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, hdr := range hdrs {
+		hdr.Size = int64(len(content[hdr.Name]))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header %s: %v", hdr.Name, err)
+		}
+		if _, err := io.WriteString(tw, content[hdr.Name]); err != nil {
+			t.Fatalf("write %s: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+}
+
+// TestPrepare_FromTar verifies that a snapshot seeded from an archive, plain
+// or compressed, holds the archive's files on top of its parent, with the
+// parent's files the archive whites out removed.
+func TestPrepare_FromTar(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		ctx := context.Background()
+		sn, cleanup := newTestSnapshotter(t)
+		defer cleanup()
+		sn.ArchiveDir = t.TempDir()
+
+		if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+			t.Fatalf("Prepare base-active: %v", err)
+		}
+		baseDir := writableDir(t, sn, "base-active")
+		for _, name := range []string{"kept.txt", "whited-out.txt"} {
+			if err := os.WriteFile(filepath.Join(baseDir, name), []byte(name), 0644); err != nil {
+				t.Fatalf("write %s: %v", name, err)
+			}
+		}
+		if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+			t.Fatalf("Commit base: %v", err)
+		}
+
+		archive := filepath.Join(sn.ArchiveDir, "layer.tar")
+		writeArchive(t, archive, compress, []*tar.Header{
+			{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644},
+			{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0755},
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "etc/hostname"},
+			{Name: ".wh.whited-out.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		}, map[string]string{"etc/hostname": "seeded\n", "bin/tool": "#!/bin/sh\n"})
+
+		if _, err := sn.Prepare(ctx, "seeded", "base", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneFromTar: archive,
+		})); err != nil {
+			t.Fatalf("Prepare seeded (compressed: %t): %v", compress, err)
+		}
+		info, err := sn.Stat(ctx, "seeded")
+		if err != nil {
+			t.Fatalf("Stat seeded: %v", err)
+		}
+		if info.Parent != "base" {
+			t.Errorf("seeded snapshot parent = %q, want base", info.Parent)
+		}
+		if _, ok := info.Labels[snapshotter.LabelCloneFromTar]; ok {
+			t.Error("clone-from-tar label stored on the snapshot")
+		}
+
+		dir := writableDir(t, sn, "seeded")
+		assertFileContent(t, dir, "etc/hostname", "seeded\n")
+		assertFileContent(t, dir, "bin/tool", "#!/bin/sh\n")
+		assertFileContent(t, dir, "kept.txt", "kept.txt")
+		if fi, err := os.Stat(filepath.Join(dir, "bin", "tool")); err != nil || fi.Mode().Perm() != 0755 {
+			t.Errorf("bin/tool: %v, %v, want mode 0755", fi, err)
+		}
+		if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "etc/hostname" {
+			t.Errorf("link = %q, %v, want etc/hostname", target, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "whited-out.txt")); !os.IsNotExist(err) {
+			t.Errorf("whited-out.txt is still there: %v", err)
+		}
+	}
+}
+
+// TestPrepare_FromTarOutsideArchiveDir verifies that archives outside
+// ArchiveDir, including through a symbolic link in it, are refused without
+// creating a snapshot.
+func TestPrepare_FromTarOutsideArchiveDir(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	outside := filepath.Join(t.TempDir(), "layer.tar")
+	writeArchive(t, outside, false, []*tar.Header{{Name: "file", Typeflag: tar.TypeReg, Mode: 0644}}, nil)
+
+	prepare := func(path string) error {
+		_, err := sn.Prepare(ctx, "seeded", "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneFromTar: path,
+		}))
+		return err
+	}
+	if err := prepare(outside); !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("Prepare without ArchiveDir error = %v, want ErrFailedPrecondition", err)
+	}
+
+	sn.ArchiveDir = t.TempDir()
+	link := filepath.Join(sn.ArchiveDir, "link.tar")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	for _, path := range []string{outside, link, sn.ArchiveDir + "/../layer.tar", "layer.tar"} {
+		if err := prepare(path); !errdefs.IsInvalidArgument(err) {
+			t.Errorf("Prepare from %s error = %v, want ErrInvalidArgument", path, err)
+		}
+	}
+	if err := prepare(filepath.Join(sn.ArchiveDir, "missing.tar")); !errdefs.IsNotFound(err) {
+		t.Errorf("Prepare from a missing archive error = %v, want ErrNotFound", err)
+	}
+	if _, err := sn.Stat(ctx, "seeded"); !errdefs.IsNotFound(err) {
+		t.Errorf("refused seeding left a snapshot behind: %v", err)
+	}
+}
+
+// TestPrepare_FromTarLimits verifies that seeding from an archive larger
+// than MaxCloneBytes is refused without creating a snapshot, and that a
+// seeded snapshot counts against its namespace's quota.
+func TestPrepare_FromTarLimits(t *testing.T) {
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.ArchiveDir = t.TempDir()
+	archive := filepath.Join(sn.ArchiveDir, "layer.tar.gz")
+	writeArchive(t, archive, true, []*tar.Header{
+		{Name: "data.bin", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"data.bin": strings.Repeat("x", 1000)})
+	labels := snapshots.WithLabels(map[string]string{snapshotter.LabelCloneFromTar: archive})
+
+	ctx := namespaces.WithNamespace(context.Background(), "tenant")
+	sn.MaxCloneBytes = 500
+	if _, err := sn.Prepare(ctx, "too-large", "", labels); !errors.Is(err, snapshotter.ErrCloneTooLarge) {
+		t.Fatalf("Prepare too-large error = %v, want ErrCloneTooLarge", err)
+	}
+	if _, err := sn.Stat(ctx, "too-large"); !errdefs.IsNotFound(err) {
+		t.Errorf("Stat too-large = %v, want NotFound", err)
+	}
+
+	sn.MaxCloneBytes = 0
+	sn.NamespaceQuotas = map[string]int64{"tenant": 1500}
+	if _, err := sn.Prepare(ctx, "seeded", "", labels); err != nil {
+		t.Fatalf("Prepare seeded: %v", err)
+	}
+	if got := sn.QuotaUsage("tenant"); got != 1000 {
+		t.Errorf("quota usage after seeding = %d, want 1000", got)
+	}
+	if _, err := sn.Prepare(ctx, "seeded-2", "", labels); !errors.Is(err, snapshotter.ErrQuotaExceeded) {
+		t.Errorf("Prepare seeded-2 error = %v, want ErrQuotaExceeded", err)
+	}
+}
@@ -0,0 +1,82 @@
+package snapshotter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrEncryptionMismatch is returned when the source's and the destination's
+// writable directories are not under the same fscrypt encryption policy.
+// Files created in an encrypted directory inherit its policy, so a clone
+// would either re-encrypt the source's files under another policy or write
+// encrypted files out in plaintext; both are refused rather than done
+// silently.
+var ErrEncryptionMismatch = errors.New("source and destination encryption policies differ")
+
+// checkEncryption fails with [ErrEncryptionMismatch] unless srcDir and dstDir
+// are both unencrypted or under the same fscrypt policy.  policy replaces
+// getEncryptionPolicy if not nil.
+func checkEncryption(srcDir, dstDir string, policy func(dir string) ([]byte, error)) error {
+	if policy == nil {
+		policy = getEncryptionPolicy
+	}
+	src, err := policy(srcDir)
+	if err != nil {
+		return fmt.Errorf("get encryption policy of source: %w", err)
+	}
+	dst, err := policy(dstDir)
+	if err != nil {
+		return fmt.Errorf("get encryption policy of destination: %w", err)
+	}
+	switch {
+	case bytes.Equal(src, dst):
+		return nil
+	case src == nil:
+		return fmt.Errorf("%w: destination %s is encrypted but the source is not", ErrEncryptionMismatch, dstDir)
+	case dst == nil:
+		return fmt.Errorf("%w: source %s is encrypted but the destination is not", ErrEncryptionMismatch, srcDir)
+	default:
+		return fmt.Errorf("%w: %s and %s are encrypted with different keys or settings", ErrEncryptionMismatch, srcDir, dstDir)
+	}
+}
+
+// getEncryptionPolicy returns the raw fscrypt policy of dir, or nil if dir is
+// not encrypted or its filesystem does not support encryption.
+func getEncryptionPolicy(dir string) ([]byte, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	arg := unix.FscryptGetPolicyExArg{}
+	arg.Size = uint64(len(arg.Policy))
+	errno := ioctlPtr(f.Fd(), unix.FS_IOC_GET_ENCRYPTION_POLICY_EX, unsafe.Pointer(&arg))
+	if errno == unix.ENOTTY {
+		// Kernels before 5.4 only know v1 policies.
+		var v1 unix.FscryptPolicyV1
+		errno = ioctlPtr(f.Fd(), unix.FS_IOC_GET_ENCRYPTION_POLICY, unsafe.Pointer(&v1))
+		if errno == 0 {
+			return unsafe.Slice((*byte)(unsafe.Pointer(&v1)), unsafe.Sizeof(v1)), nil
+		}
+	}
+	switch errno {
+	case 0:
+		return arg.Policy[:arg.Size], nil
+	case unix.ENODATA, unix.ENOTTY, unix.EOPNOTSUPP:
+		return nil, nil
+	default:
+		return nil, errno
+	}
+}
+
+// ioctlPtr issues the ioctl req on fd with a pointer argument.
+func ioctlPtr(fd uintptr, req uint, arg unsafe.Pointer) unix.Errno {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, uintptr(req), uintptr(arg))
+	return errno
+}
@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_EncryptedDestination verifies that cloning an
+// unencrypted source into an encrypted destination fails with
+// ErrEncryptionMismatch and removes the clone, and that a clone between
+// directories under the same policy goes ahead.
+func TestPrepare_Clone_EncryptedDestination(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	// Every directory but the source's is encrypted.
+	policy := []byte{2, 1, 4, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	sn.encryptionPolicy = func(dir string) ([]byte, error) {
+		if dir == srcDir {
+			return nil, nil
+		}
+		return policy, nil
+	}
+	_, err = sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, ErrEncryptionMismatch) {
+		t.Fatalf("Prepare clone error = %v, want ErrEncryptionMismatch", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("failed clone snapshot was not removed")
+	}
+
+	// The same policy on both sides is fine.
+	sn.encryptionPolicy = func(string) ([]byte, error) { return policy, nil }
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone under a shared policy: %v", err)
+	}
+}
+
+// TestGetEncryptionPolicy_Unencrypted verifies that an ordinary directory
+// reports no encryption policy.
+func TestGetEncryptionPolicy_Unencrypted(t *testing.T) {
+	policy, err := getEncryptionPolicy(t.TempDir())
+	if err != nil || policy != nil {
+		t.Errorf("getEncryptionPolicy = %v, %v; want no policy", policy, err)
+	}
+}
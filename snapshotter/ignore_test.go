@@ -0,0 +1,49 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_IgnoreFiles verifies that runtime-managed files such as
+// /etc/hostname are left out of clones by default while other /etc files
+// are copied, and that an empty IgnoreFiles copies them too.
+func TestPrepare_Clone_IgnoreFiles(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	etc := filepath.Join(writableDir(t, sn, "source"), "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		t.Fatalf("create etc: %v", err)
+	}
+	for name, data := range map[string]string{"hostname": "source-host\n", "hosts": "127.0.0.1 source-host\n", "app.conf": "setting=1\n"} {
+		if err := os.WriteFile(filepath.Join(etc, name), []byte(data), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", nil)); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	cloneEtc := filepath.Join(writableDir(t, sn, "clone"), "etc")
+	assertFileContent(t, cloneEtc, "app.conf", "setting=1\n")
+	for _, name := range []string{"hostname", "hosts"} {
+		if _, err := os.Lstat(filepath.Join(cloneEtc, name)); !os.IsNotExist(err) {
+			t.Errorf("etc/%s was copied into the clone: %v", name, err)
+		}
+	}
+
+	sn.IgnoreFiles = []string{}
+	if _, err := sn.Prepare(ctx, "full-clone", "", snapshotter.WithCloneLabels("source", nil)); err != nil {
+		t.Fatalf("Prepare full-clone: %v", err)
+	}
+	assertFileContent(t, filepath.Join(writableDir(t, sn, "full-clone"), "etc"), "hostname", "source-host\n")
+}
@@ -0,0 +1,68 @@
+package snapshotter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// reconcileEntry prepares dst, an existing destination path, to receive the
+// source entry described by src during an incremental clone.  It reports
+// whether dst is a regular file with the same mode, size and modification
+// time as src, in which case the copy can be skipped.  An entry of a
+// different type is removed so that it can be recreated.
+func reconcileEntry(src fs.FileInfo, dst string) (bool, error) {
+	cur, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case src.Mode().IsDir() && cur.Mode().IsDir():
+		return false, nil
+	case src.Mode().IsRegular() && cur.Mode().IsRegular():
+		return cur.Mode() == src.Mode() && cur.Size() == src.Size() && cur.ModTime().Equal(src.ModTime()), nil
+	}
+	return false, os.RemoveAll(dst)
+}
+
+// pruneDir removes every entry below dstDir that has no counterpart below
+// srcDir or that the copier excludes, so that after an incremental clone the
+// destination holds exactly what a full clone would.  Entries matching the
+// ignore patterns are the clone's own and are kept.
+func (c *copier) pruneDir(srcDir, dstDir string) error {
+	return filepath.WalkDir(dstDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dstDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if matchAny(c.ignore, rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		_, err = os.Lstat(filepath.Join(srcDir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && !matchAny(c.exclude, rel) {
+			return nil
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_Incremental verifies that an incremental re-clone into
+// an existing snapshot leaves unchanged files alone, updates changed files,
+// adds new files and deletes files the source no longer has.
+func TestPrepare_Clone_Incremental(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "inc-src", ""); err != nil {
+		t.Fatalf("Prepare inc-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "inc-src")
+	for name, content := range map[string]string{
+		"unchanged.txt": "same",
+		"changed.txt":   "old",
+		"removed.txt":   "gone soon",
+	} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	cloneLabels := snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:      "inc-src",
+		snapshotter.LabelCloneIncremental: "true",
+	})
+	if _, err := sn.Prepare(ctx, "inc-clone", "", cloneLabels); err != nil {
+		t.Fatalf("initial Prepare inc-clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "inc-clone")
+
+	// Copied files keep the source's modification time.
+	srcInfo, err := os.Stat(filepath.Join(srcDir, "unchanged.txt"))
+	if err != nil {
+		t.Fatalf("stat source unchanged.txt: %v", err)
+	}
+	cloneInfo, err := os.Stat(filepath.Join(cloneDir, "unchanged.txt"))
+	if err != nil {
+		t.Fatalf("stat clone unchanged.txt: %v", err)
+	}
+	if !cloneInfo.ModTime().Equal(srcInfo.ModTime()) {
+		t.Errorf("clone mtime = %v, want source mtime %v", cloneInfo.ModTime(), srcInfo.ModTime())
+	}
+
+	// Tamper with the clone's copy of unchanged.txt without changing its size
+	// or mtime: if the incremental pass rewrote it, the tampering would vanish.
+	unchanged := filepath.Join(cloneDir, "unchanged.txt")
+	if err := os.WriteFile(unchanged, []byte("SAME"), 0644); err != nil {
+		t.Fatalf("tamper unchanged.txt: %v", err)
+	}
+	if err := os.Chtimes(unchanged, time.Time{}, srcInfo.ModTime()); err != nil {
+		t.Fatalf("restore mtime: %v", err)
+	}
+
+	// Evolve the source.
+	if err := os.WriteFile(filepath.Join(srcDir, "changed.txt"), []byte("new content"), 0644); err != nil {
+		t.Fatalf("update changed.txt: %v", err)
+	}
+	if err := os.Remove(filepath.Join(srcDir, "removed.txt")); err != nil {
+		t.Fatalf("remove removed.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "added.txt"), []byte("added"), 0644); err != nil {
+		t.Fatalf("write added.txt: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "inc-clone", "", cloneLabels); err != nil {
+		t.Fatalf("incremental Prepare inc-clone: %v", err)
+	}
+
+	assertFileContent(t, cloneDir, "unchanged.txt", "SAME")
+	assertFileContent(t, cloneDir, "changed.txt", "new content")
+	assertFileContent(t, cloneDir, "added.txt", "added")
+	if _, err := os.Stat(filepath.Join(cloneDir, "removed.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected removed.txt to be deleted from the clone, stat err = %v", err)
+	}
+}
+
+// TestPrepare_Clone_IncrementalRequiresLabel verifies that, without the
+// incremental label, cloning into an existing key still fails.
+func TestPrepare_Clone_IncrementalRequiresLabel(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "inc-src", ""); err != nil {
+		t.Fatalf("Prepare inc-src: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "inc-existing", ""); err != nil {
+		t.Fatalf("Prepare inc-existing: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "inc-existing", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "inc-src",
+		}),
+	); err == nil {
+		t.Fatal("expected error cloning into an existing key without the incremental label")
+	}
+	if _, err := sn.Stat(ctx, "inc-existing"); err != nil {
+		t.Errorf("existing snapshot should survive the failed clone: %v", err)
+	}
+}
+
+// TestPrepare_Clone_IncrementalKeepsIgnoredFiles verifies that an
+// incremental re-clone keeps the clone's own versions of ignored files such
+// as etc/hostname instead of pruning them.
+func TestPrepare_Clone_IncrementalKeepsIgnoredFiles(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "inc-src", ""); err != nil {
+		t.Fatalf("Prepare inc-src: %v", err)
+	}
+	srcEtc := filepath.Join(writableDir(t, sn, "inc-src"), "etc")
+	if err := os.MkdirAll(srcEtc, 0755); err != nil {
+		t.Fatalf("create etc: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcEtc, "hostname"), []byte("source-host\n"), 0644); err != nil {
+		t.Fatalf("write hostname: %v", err)
+	}
+
+	cloneLabels := snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:      "inc-src",
+		snapshotter.LabelCloneIncremental: "true",
+	})
+	if _, err := sn.Prepare(ctx, "inc-clone", "", cloneLabels); err != nil {
+		t.Fatalf("initial Prepare inc-clone: %v", err)
+	}
+	cloneEtc := filepath.Join(writableDir(t, sn, "inc-clone"), "etc")
+	if err := os.WriteFile(filepath.Join(cloneEtc, "hostname"), []byte("clone-host\n"), 0644); err != nil {
+		t.Fatalf("write clone hostname: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "inc-clone", "", cloneLabels); err != nil {
+		t.Fatalf("incremental Prepare inc-clone: %v", err)
+	}
+	assertFileContent(t, cloneEtc, "hostname", "clone-host\n")
+}
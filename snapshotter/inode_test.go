@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"golang.org/x/sys/unix"
+)
+
+// TestPrepare_Clone_InsufficientInodes verifies that a clone with more
+// entries than the destination has free inodes fails before anything is
+// copied, unless SkipInodeCheck is set.
+func TestPrepare_Clone_InsufficientInodes(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	defer sn.Close()
+	sn.statfs = func(path string, st *unix.Statfs_t) error {
+		if err := unix.Statfs(path, st); err != nil {
+			return err
+		}
+		st.Ffree = 3
+		return nil
+	}
+	var copied int
+	sn.copyHook = func(context.Context, string) error {
+		copied++
+		return nil
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("file-%d", i)), nil, 0644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	_, err = sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, ErrInsufficientInodes) {
+		t.Fatalf("Prepare clone error = %v, want ErrInsufficientInodes", err)
+	}
+	if copied != 0 {
+		t.Errorf("%d files copied before the inode check failed", copied)
+	}
+
+	sn.SkipInodeCheck = true
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone with SkipInodeCheck: %v", err)
+	}
+	if copied != 10 {
+		t.Errorf("%d files copied, want 10", copied)
+	}
+}
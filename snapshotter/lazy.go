@@ -0,0 +1,49 @@
+package snapshotter
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkFile hard-links the regular file src to dst for a lazy clone,
+// replacing any existing dst.  It reports false, without error, when the
+// file cannot be linked and has to be copied instead: across filesystems,
+// past the link limit, or for immutable and append-only files.
+func (c *copier) linkFile(src, dst string) (bool, error) {
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	link := c.link
+	if link == nil {
+		link = os.Link
+	}
+	err := link(src, dst)
+	if errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EMLINK) || errors.Is(err, unix.EPERM) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c.fileCopied()
+	return true, nil
+}
+
+// breakLink removes path if it is a regular file with more than one link, so
+// that writing a new file in its place does not modify the data of a file
+// shared with another snapshot by a lazy clone.
+func breakLink(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+		return os.Remove(path)
+	}
+	return nil
+}
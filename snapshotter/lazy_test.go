@@ -0,0 +1,87 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// inode returns the inode number of the file at path.
+func inode(t *testing.T, path string) uint64 {
+	t.Helper()
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		t.Fatalf("lstat %s: %v", path, err)
+	}
+	return st.Ino
+}
+
+// TestPrepare_Clone_Lazy verifies that a lazy clone shares the inodes of
+// unmodified files with its source, and that replacing a file in the clone
+// or merging new content into it leaves the source untouched.
+func TestPrepare_Clone_Lazy(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "lazy-src", ""); err != nil {
+		t.Fatalf("Prepare lazy-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "lazy-src")
+	for _, name := range []string{"shared.txt", "replaced.txt", "merged.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("original "+name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "lazy-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "lazy-src",
+		snapshotter.LabelCloneLazy:   "true",
+	})); err != nil {
+		t.Fatalf("Prepare lazy-clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "lazy-clone")
+	for _, name := range []string{"shared.txt", "replaced.txt", "merged.txt"} {
+		if inode(t, filepath.Join(cloneDir, name)) != inode(t, filepath.Join(srcDir, name)) {
+			t.Errorf("%s: clone does not share the source's inode", name)
+		}
+	}
+
+	// Replacing a file in the clone breaks the link.
+	tmp := filepath.Join(cloneDir, ".replaced.txt.tmp")
+	if err := os.WriteFile(tmp, []byte("rewritten in clone"), 0644); err != nil {
+		t.Fatalf("write replacement: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(cloneDir, "replaced.txt")); err != nil {
+		t.Fatalf("rename replacement: %v", err)
+	}
+
+	// A merge clone writing into the lazy clone must not write through the
+	// links either.
+	if _, err := sn.Prepare(ctx, "merge-src", ""); err != nil {
+		t.Fatalf("Prepare merge-src: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "merge-src"), "merged.txt"), []byte("merged into clone"), 0644); err != nil {
+		t.Fatalf("write merge source: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "lazy-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "merge-src",
+		snapshotter.LabelCloneMerge:  "true",
+	})); err != nil {
+		t.Fatalf("merge into lazy-clone: %v", err)
+	}
+
+	assertFileContent(t, cloneDir, "replaced.txt", "rewritten in clone")
+	assertFileContent(t, cloneDir, "merged.txt", "merged into clone")
+	for _, name := range []string{"shared.txt", "replaced.txt", "merged.txt"} {
+		assertFileContent(t, srcDir, name, "original "+name)
+	}
+	if inode(t, filepath.Join(cloneDir, "shared.txt")) != inode(t, filepath.Join(srcDir, "shared.txt")) {
+		t.Error("shared.txt: clone no longer shares the source's inode")
+	}
+}
@@ -0,0 +1,103 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// lineageLabels are the labels recording where a clone came from.  They are
+// set only by the plugin and cannot be changed through Update.
+var lineageLabels = []string{LabelClonedFrom, LabelClonedFromNamespace, LabelClonedFromUpperdir, LabelCloneViewOf}
+
+// Update updates the snapshot's info through the inner snapshotter while
+// protecting its lineage labels.  An update naming a lineage label in its
+// field paths fails with [errdefs.ErrInvalidArgument] unless it keeps the
+// label's current value; an update replacing all labels keeps the current
+// lineage labels.  The virtual [LabelCloneSize] is never stored: naming it
+// fails, and it is dropped from an update replacing all labels.
+func (s *CloneSnapshotter) Update(ctx context.Context, info snapshots.Info, fieldpaths ...string) (snapshots.Info, error) {
+	cur, err := s.Snapshotter.Stat(ctx, info.Name)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+
+	replacesLabels := len(fieldpaths) == 0
+	for _, path := range fieldpaths {
+		if path == "labels" {
+			replacesLabels = true
+			continue
+		}
+		label, ok := strings.CutPrefix(path, "labels.")
+		if ok && label == LabelCloneSize {
+			return snapshots.Info{}, fmt.Errorf("label %s of snapshot %q is computed by the clone snapshotter and cannot be set: %w",
+				label, info.Name, errdefs.ErrInvalidArgument)
+		}
+		if !ok || !isLineageLabel(label) {
+			continue
+		}
+		old, had := cur.Labels[label]
+		if v, ok := info.Labels[label]; v != old || ok != had {
+			return snapshots.Info{}, fmt.Errorf("label %s of snapshot %q is set by the clone snapshotter and cannot be changed: %w",
+				label, info.Name, errdefs.ErrInvalidArgument)
+		}
+	}
+
+	if replacesLabels {
+		labels := make(map[string]string, len(info.Labels)+len(lineageLabels))
+		for k, v := range info.Labels {
+			// Virtual labels seen through Stat are not stored.
+			if !isLineageLabel(k) && k != LabelCloneSize {
+				labels[k] = v
+			}
+		}
+		for _, label := range lineageLabels {
+			if v, ok := cur.Labels[label]; ok {
+				labels[label] = v
+			}
+		}
+		info.Labels = labels
+	}
+	return s.Snapshotter.Update(ctx, info, fieldpaths...)
+}
+
+// isLineageLabel reports whether label is one of the lineage labels.
+func isLineageLabel(label string) bool {
+	for _, l := range lineageLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// ClonesOf returns the sorted keys of the snapshots in the namespace of ctx
+// that were cloned from the snapshot sourceKey in that namespace, according
+// to their [LabelClonedFrom] label.  Only direct clones are listed, not
+// clones of clones, and clones in other namespaces are not seen.  The
+// snapshots backing view clones are left out in favour of the views.
+func (s *CloneSnapshotter) ClonesOf(ctx context.Context, sourceKey string) ([]string, error) {
+	ns, _ := namespaces.Namespace(ctx)
+	var keys []string
+	err := s.Snapshotter.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		if info.Labels[LabelClonedFrom] != sourceKey || strings.HasPrefix(info.Name, viewBackingPrefix) {
+			return nil
+		}
+		// The same key in another namespace is another source.
+		if from, ok := info.Labels[LabelClonedFromNamespace]; ok && from != ns {
+			return nil
+		}
+		keys = append(keys, info.Name)
+		return nil
+	}, fmt.Sprintf(`labels."%s"==%q`, LabelClonedFrom, sourceKey))
+	if err != nil {
+		return nil, fmt.Errorf("walk clones of %q: %w", sourceKey, err)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
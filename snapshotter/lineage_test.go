@@ -0,0 +1,114 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestUpdate_LineageLabels verifies that Update refuses to change the
+// cloned-from label, keeps it when all labels are replaced, and passes other
+// label updates through.
+func TestUpdate_LineageLabels(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+
+	_, err := sn.Update(ctx, snapshots.Info{
+		Name:   "clone",
+		Labels: map[string]string{snapshotter.LabelClonedFrom: "other"},
+	}, "labels."+snapshotter.LabelClonedFrom)
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Update cloned-from error = %v, want ErrInvalidArgument", err)
+	}
+	_, err = sn.Update(ctx, snapshots.Info{Name: "clone"}, "labels."+snapshotter.LabelClonedFrom)
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("Update removing cloned-from error = %v, want ErrInvalidArgument", err)
+	}
+
+	if _, err := sn.Update(ctx, snapshots.Info{
+		Name:   "clone",
+		Labels: map[string]string{"app": "web"},
+	}, "labels.app"); err != nil {
+		t.Fatalf("Update app label: %v", err)
+	}
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if info.Labels["app"] != "web" {
+		t.Errorf("label app = %q, want %q", info.Labels["app"], "web")
+	}
+
+	if _, err := sn.Update(ctx, snapshots.Info{
+		Name:   "clone",
+		Labels: map[string]string{"team": "infra"},
+	}); err != nil {
+		t.Fatalf("Update replacing labels: %v", err)
+	}
+	info, err = sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if info.Labels[snapshotter.LabelClonedFrom] != "source" {
+		t.Errorf("label cloned-from = %q after replacing labels, want %q", info.Labels[snapshotter.LabelClonedFrom], "source")
+	}
+	if info.Labels["team"] != "infra" || info.Labels["app"] != "" {
+		t.Errorf("labels = %v, want team=infra without app", info.Labels)
+	}
+}
+
+// TestClonesOf verifies that ClonesOf lists the direct clones of a source
+// and nothing else.
+func TestClonesOf(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	for _, key := range []string{"source", "other"} {
+		if _, err := sn.Prepare(ctx, key, ""); err != nil {
+			t.Fatalf("Prepare %s: %v", key, err)
+		}
+	}
+	for key, source := range map[string]string{
+		"clone-b":        "source",
+		"clone-a":        "source",
+		"clone-of-other": "other",
+	} {
+		if _, err := sn.Prepare(ctx, key, "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: source,
+		})); err != nil {
+			t.Fatalf("Prepare %s: %v", key, err)
+		}
+	}
+	if _, err := sn.Prepare(ctx, "clone-of-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "clone-a",
+	})); err != nil {
+		t.Fatalf("Prepare clone-of-clone: %v", err)
+	}
+
+	got, err := sn.ClonesOf(ctx, "source")
+	if err != nil {
+		t.Fatalf("ClonesOf: %v", err)
+	}
+	if want := []string{"clone-a", "clone-b"}; !slices.Equal(got, want) {
+		t.Errorf("ClonesOf(source) = %v, want %v", got, want)
+	}
+	if got, err := sn.ClonesOf(ctx, "clone-of-other"); err != nil || len(got) != 0 {
+		t.Errorf("ClonesOf(clone-of-other) = %v, %v; want none", got, err)
+	}
+}
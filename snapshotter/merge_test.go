@@ -0,0 +1,65 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_Merge verifies that a merge clone into a populated
+// snapshot keeps the destination's own files, adds the source's files and
+// lets the source win where both have the same path.
+func TestPrepare_Clone_Merge(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "merge-src", ""); err != nil {
+		t.Fatalf("Prepare merge-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "merge-src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "etc"), 0755); err != nil {
+		t.Fatalf("mkdir source etc: %v", err)
+	}
+	for name, content := range map[string]string{
+		"etc/app.conf": "from source",
+		"source.txt":   "source only",
+	} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write source %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "merge-dst", ""); err != nil {
+		t.Fatalf("Prepare merge-dst: %v", err)
+	}
+	dstDir := writableDir(t, sn, "merge-dst")
+	if err := os.MkdirAll(filepath.Join(dstDir, "etc"), 0755); err != nil {
+		t.Fatalf("mkdir destination etc: %v", err)
+	}
+	for name, content := range map[string]string{
+		"etc/app.conf":   "from destination, longer",
+		"etc/local.conf": "destination only",
+		"existing.txt":   "destination only",
+	} {
+		if err := os.WriteFile(filepath.Join(dstDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write destination %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "merge-dst", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "merge-src",
+		snapshotter.LabelCloneMerge:  "true",
+	})); err != nil {
+		t.Fatalf("merge Prepare merge-dst: %v", err)
+	}
+
+	assertFileContent(t, dstDir, "existing.txt", "destination only")
+	assertFileContent(t, dstDir, "source.txt", "source only")
+	assertFileContent(t, filepath.Join(dstDir, "etc"), "local.conf", "destination only")
+	assertFileContent(t, filepath.Join(dstDir, "etc"), "app.conf", "from source")
+}
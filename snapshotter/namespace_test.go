@@ -0,0 +1,148 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// namespacedSnapshotter isolates snapshot keys per containerd namespace by
+// prefixing them with the namespace taken from the context, mimicking the
+// isolation containerd's metadata store provides in front of a snapshotter.
+type namespacedSnapshotter struct {
+	snapshots.Snapshotter
+}
+
+func (n *namespacedSnapshotter) key(ctx context.Context, key string) (string, error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", nil
+	}
+	return ns + "/" + key, nil
+}
+
+func (n *namespacedSnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	info, err := n.Snapshotter.Stat(ctx, k)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	info.Name = strings.TrimPrefix(info.Name, ns+"/")
+	info.Parent = strings.TrimPrefix(info.Parent, ns+"/")
+	return info, nil
+}
+
+func (n *namespacedSnapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return n.Snapshotter.Mounts(ctx, k)
+}
+
+func (n *namespacedSnapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	p, err := n.key(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	return n.Snapshotter.Prepare(ctx, k, p, opts...)
+}
+
+func (n *namespacedSnapshotter) Update(ctx context.Context, info snapshots.Info, fieldpaths ...string) (snapshots.Info, error) {
+	name := info.Name
+	k, err := n.key(ctx, name)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	info.Name = k
+	info, err = n.Snapshotter.Update(ctx, info, fieldpaths...)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	info.Name = name
+	return info, nil
+}
+
+func (n *namespacedSnapshotter) Remove(ctx context.Context, key string) error {
+	k, err := n.key(ctx, key)
+	if err != nil {
+		return err
+	}
+	return n.Snapshotter.Remove(ctx, k)
+}
+
+// TestPrepare_Clone_SourceNamespace verifies that a clone prepared in one
+// namespace can copy a source that lives in another namespace.
+func TestPrepare_Clone_SourceNamespace(t *testing.T) {
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := snapshotter.New(&namespacedSnapshotter{Snapshotter: inner})
+
+	ctxA := namespaces.WithNamespace(context.Background(), "team-a")
+	ctxB := namespaces.WithNamespace(context.Background(), "team-b")
+
+	mounts, err := sn.Prepare(ctxA, "golden", "")
+	if err != nil {
+		t.Fatalf("Prepare golden in team-a: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(mounts[0].Source, "golden.txt"), []byte("from-a"), 0644); err != nil {
+		t.Fatalf("write golden.txt: %v", err)
+	}
+
+	// Without the namespace label the source is not visible from team-b.
+	if _, err := sn.Prepare(ctxB, "clone-missing", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "golden",
+		}),
+	); err == nil {
+		t.Fatal("expected error cloning a source from another namespace without the namespace label")
+	}
+
+	mounts, err = sn.Prepare(ctxB, "clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:          "golden",
+			snapshotter.LabelCloneSourceNamespace: "team-a",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Prepare clone in team-b: %v", err)
+	}
+	assertFileContent(t, mounts[0].Source, "golden.txt", "from-a")
+
+	// The clone belongs to team-b, not team-a, and does not carry the
+	// namespace control label.
+	info, err := sn.Stat(ctxB, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone in team-b: %v", err)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneSourceNamespace]; ok {
+		t.Error("clone-source-namespace label should not be stored on the clone")
+	}
+	if got := info.Labels[snapshotter.LabelClonedFromNamespace]; got != "team-a" {
+		t.Errorf("cloned-from-namespace label = %q, want %q", got, "team-a")
+	}
+	if _, err := sn.Stat(ctxA, "clone"); err == nil {
+		t.Error("clone should not exist in team-a")
+	}
+}
@@ -0,0 +1,78 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+)
+
+// Mounts returns the mounts of the snapshot identified by key.  The mounts
+// of an overlay-share clone have the shared source upperdir stacked on
+// their lower directories; all others are returned as the inner snapshotter
+// reports them.  Only snapshots whose mounts could be those of an
+// overlay-share clone, a single overlay mount with lower directories, are
+// looked up to tell; the mounts of all others are returned without one.
+func (s *CloneSnapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+	mounts, err := s.Snapshotter.Mounts(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(mounts) != 1 || mounts[0].Type != "overlay" || lowerdirIndex(mounts[0].Options) < 0 {
+		return mounts, nil
+	}
+	info, err := s.Snapshotter.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if dir, ok := info.Labels[LabelClonedFromUpperdir]; ok {
+		return stackUpperdir(mounts, dir)
+	}
+	return mounts, nil
+}
+
+// sharedUpperdir returns the upperdir of mounts for an overlay-share clone.
+// Only an overlay mount with lower directories can be shared: the clone gets
+// the same lower directories, with the upperdir on top of them.
+func sharedUpperdir(mounts []mount.Mount) (string, error) {
+	if len(mounts) != 1 || mounts[0].Type != "overlay" || lowerdirIndex(mounts[0].Options) < 0 {
+		return "", fmt.Errorf("overlay-share clones need an overlay source with a parent, got mount types %q: %w",
+			joinMountTypes(mounts), errdefs.ErrNotImplemented)
+	}
+	return getWritableDir(mounts)
+}
+
+// stackUpperdir returns a copy of mounts with dir, a colon-separated list of
+// directories, prepended to the lowerdir list of its overlay mount, making
+// it the topmost read-only layers.
+func stackUpperdir(mounts []mount.Mount, dir string) ([]mount.Mount, error) {
+	stacked := make([]mount.Mount, len(mounts))
+	copy(stacked, mounts)
+	for i, m := range stacked {
+		if m.Type != "overlay" {
+			continue
+		}
+		j := lowerdirIndex(m.Options)
+		if j < 0 {
+			break
+		}
+		opts := append([]string(nil), m.Options...)
+		opts[j] = "lowerdir=" + dir + ":" + strings.TrimPrefix(opts[j], "lowerdir=")
+		stacked[i].Options = opts
+		return stacked, nil
+	}
+	return nil, fmt.Errorf("stack shared upperdir %s: no overlay mount with lower directories (types: %s)",
+		dir, joinMountTypes(mounts))
+}
+
+// lowerdirIndex returns the index of the lowerdir option in opts, or -1.
+func lowerdirIndex(opts []string) int {
+	for i, opt := range opts {
+		if strings.HasPrefix(opt, "lowerdir=") {
+			return i
+		}
+	}
+	return -1
+}
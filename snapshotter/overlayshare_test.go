@@ -0,0 +1,130 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/containerd/containerd/snapshots/overlay"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_OverlayShare verifies that an overlay-share clone stacks
+// the source upperdir on its lower directories instead of copying it, and
+// that the mounted clone sees the source's files.
+func TestPrepare_Clone_OverlayShare(t *testing.T) {
+	ctx := context.Background()
+	inner, err := overlay.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Skipf("create overlay snapshotter: %v", err)
+	}
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+		t.Fatalf("Prepare base: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(upperdir(t, sn, "base-active"), "base.txt"), []byte("base"), 0644); err != nil {
+		t.Fatalf("write base.txt: %v", err)
+	}
+	if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+		t.Fatalf("Commit base: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "source", "base"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := upperdir(t, sn, "source")
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	mounts, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:       "source",
+		snapshotter.LabelCloneOverlayShare: "true",
+	}))
+	if err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if !strings.Contains(strings.Join(mounts[0].Options, ","), "lowerdir="+srcDir+":") {
+		t.Errorf("clone mount options %v do not stack source upperdir %s", mounts[0].Options, srcDir)
+	}
+	if again, err := sn.Mounts(ctx, "clone"); err != nil {
+		t.Fatalf("Mounts clone: %v", err)
+	} else if strings.Join(again[0].Options, ",") != strings.Join(mounts[0].Options, ",") {
+		t.Errorf("Mounts options = %v, want %v", again[0].Options, mounts[0].Options)
+	}
+	if entries, err := os.ReadDir(upperdir(t, sn, "clone")); err != nil || len(entries) != 0 {
+		t.Errorf("clone upperdir holds %d entries (%v), want none", len(entries), err)
+	}
+
+	target := t.TempDir()
+	if err := mount.All(mounts, target); err != nil {
+		t.Skipf("mount overlay: %v", err)
+	}
+	defer mount.UnmountAll(target, 0)
+	assertFileContent(t, target, "data.txt", "data")
+	assertFileContent(t, target, "base.txt", "base")
+}
+
+// TestPrepare_Clone_OverlayShareUnsupported verifies that an overlay-share
+// clone of a bind-mounted source fails without creating the clone.
+func TestPrepare_Clone_OverlayShareUnsupported(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:       "source",
+		snapshotter.LabelCloneOverlayShare: "true",
+	}))
+	if !errors.Is(err, errdefs.ErrNotImplemented) {
+		t.Fatalf("Prepare clone error = %v, want ErrNotImplemented", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("clone snapshot was created for an unsupported source")
+	}
+}
+
+// TestMounts_NoStatForOtherSnapshots verifies that Mounts of a snapshot that
+// cannot be an overlay-share clone does not look the snapshot up, so that it
+// does not fail when the lookup would.
+func TestMounts_NoStatForOtherSnapshots(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	flaky := &flakySnapshotter{Snapshotter: inner}
+	sn := snapshotter.New(flaky)
+	if _, err := sn.Prepare(ctx, "active", ""); err != nil {
+		t.Fatalf("Prepare active: %v", err)
+	}
+
+	flaky.statCalls, flaky.statFailures = 0, 1
+	if _, err := sn.Mounts(ctx, "active"); err != nil {
+		t.Fatalf("Mounts: %v", err)
+	}
+	if flaky.statCalls != 0 {
+		t.Errorf("Mounts made %d Stat calls, want none", flaky.statCalls)
+	}
+}
+
+// upperdir returns the writable directory of the snapshot key.
+func upperdir(t *testing.T, sn *snapshotter.CloneSnapshotter, key string) string {
+	t.Helper()
+	dir, err := sn.WritableDir(context.Background(), key)
+	if err != nil {
+		t.Fatalf("WritableDir(%q): %v", key, err)
+	}
+	return dir
+}
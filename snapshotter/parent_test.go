@@ -0,0 +1,190 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/overlay"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_Parent verifies that a clone can be prepared on another
+// committed snapshot than its source's parent, and that the mounted clone
+// sees that parent's files and the source's changes but not the files of
+// the source's own parent.
+func TestPrepare_Clone_Parent(t *testing.T) {
+	ctx := context.Background()
+	inner, err := overlay.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Skipf("create overlay snapshotter: %v", err)
+	}
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	for _, base := range []string{"base-v1", "base-v2"} {
+		if _, err := sn.Prepare(ctx, base+"-active", ""); err != nil {
+			t.Fatalf("Prepare %s: %v", base, err)
+		}
+		if err := os.WriteFile(filepath.Join(upperdir(t, sn, base+"-active"), base+".txt"), []byte(base), 0644); err != nil {
+			t.Fatalf("write %s.txt: %v", base, err)
+		}
+		if err := sn.Commit(ctx, base, base+"-active"); err != nil {
+			t.Fatalf("Commit %s: %v", base, err)
+		}
+	}
+	if _, err := sn.Prepare(ctx, "source", "base-v1"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(upperdir(t, sn, "source"), "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	_, err = sn.Prepare(ctx, "missing", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneParent: "base-v3",
+	}))
+	if !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Prepare onto a missing parent error = %v, want ErrNotFound", err)
+	}
+	if _, err := sn.Stat(ctx, "missing"); err == nil {
+		t.Error("clone onto a missing parent was created")
+	}
+
+	mounts, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneParent: "base-v2",
+	}))
+	if err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if info.Parent != "base-v2" {
+		t.Errorf("clone parent = %q, want base-v2", info.Parent)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneParent]; ok {
+		t.Errorf("clone kept the %s control label", snapshotter.LabelCloneParent)
+	}
+
+	target := t.TempDir()
+	if err := mount.All(mounts, target); err != nil {
+		t.Skipf("mount overlay: %v", err)
+	}
+	defer mount.UnmountAll(target, 0)
+	assertFileContent(t, target, "data.txt", "data")
+	assertFileContent(t, target, "base-v2.txt", "base-v2")
+	if _, err := os.Stat(filepath.Join(target, "base-v1.txt")); !os.IsNotExist(err) {
+		t.Errorf("clone sees the source parent's base-v1.txt (%v)", err)
+	}
+}
+
+// TestPrepare_Clone_ParentBind verifies that a bind-mounted source with a
+// parent, whose writable directory also holds its parent's files, cannot be
+// rebased.
+func TestPrepare_Clone_ParentBind(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	for _, base := range []string{"base-v1", "base-v2"} {
+		if _, err := sn.Prepare(ctx, base+"-active", ""); err != nil {
+			t.Fatalf("Prepare %s: %v", base, err)
+		}
+		if err := sn.Commit(ctx, base, base+"-active"); err != nil {
+			t.Fatalf("Commit %s: %v", base, err)
+		}
+	}
+	if _, err := sn.Prepare(ctx, "source", "base-v1"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneParent: "base-v2",
+	}))
+	if !errors.Is(err, errdefs.ErrNotImplemented) {
+		t.Fatalf("Prepare clone error = %v, want ErrNotImplemented", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("rejected clone was created")
+	}
+}
+
+// TestPrepare_Clone_Rebase verifies that a rebased clone sees the new
+// parent's files and the source's changes, and that parents the changes
+// cannot be applied to are refused.
+func TestPrepare_Clone_Rebase(t *testing.T) {
+	ctx := context.Background()
+	inner, err := overlay.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Skipf("create overlay snapshotter: %v", err)
+	}
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	for _, base := range []string{"base-v1", "base-v2"} {
+		if _, err := sn.Prepare(ctx, base+"-active", ""); err != nil {
+			t.Fatalf("Prepare %s: %v", base, err)
+		}
+		if err := os.WriteFile(filepath.Join(upperdir(t, sn, base+"-active"), base+".txt"), []byte(base), 0644); err != nil {
+			t.Fatalf("write %s.txt: %v", base, err)
+		}
+		if err := sn.Commit(ctx, base, base+"-active"); err != nil {
+			t.Fatalf("Commit %s: %v", base, err)
+		}
+	}
+	if _, err := sn.Prepare(ctx, "source", "base-v1"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(upperdir(t, sn, "source"), "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "other-active", "base-v2"); err != nil {
+		t.Fatalf("Prepare other-active: %v", err)
+	}
+	for name, labels := range map[string]map[string]string{
+		"active parent":     {snapshotter.LabelCloneRebase: "other-active"},
+		"source":            {snapshotter.LabelCloneRebase: "source"},
+		"with clone-parent": {snapshotter.LabelCloneRebase: "base-v2", snapshotter.LabelCloneParent: "base-v2"},
+	} {
+		if _, err := sn.Prepare(ctx, "bad", "", snapshotter.WithCloneLabels("source", labels)); !errors.Is(err, errdefs.ErrInvalidArgument) {
+			t.Errorf("Prepare rebased on %s error = %v, want ErrInvalidArgument", name, err)
+		}
+	}
+	if _, err := sn.Stat(ctx, "bad"); err == nil {
+		t.Error("rejected clone was created")
+	}
+
+	mounts, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", map[string]string{
+		snapshotter.LabelCloneRebase: "base-v2",
+	}))
+	if err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if info.Parent != "base-v2" {
+		t.Errorf("clone parent = %q, want base-v2", info.Parent)
+	}
+
+	target := t.TempDir()
+	if err := mount.All(mounts, target); err != nil {
+		t.Skipf("mount overlay: %v", err)
+	}
+	defer mount.UnmountAll(target, 0)
+	assertFileContent(t, target, "data.txt", "data")
+	assertFileContent(t, target, "base-v2.txt", "base-v2")
+	if _, err := os.Stat(filepath.Join(target, "base-v1.txt")); !os.IsNotExist(err) {
+		t.Errorf("clone sees the source parent's base-v1.txt (%v)", err)
+	}
+}
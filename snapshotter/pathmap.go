@@ -0,0 +1,58 @@
+package snapshotter
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// pathRule relocates the source subtree src to dst in the clone; both are
+// relative to the writable layer roots.
+type pathRule struct {
+	src, dst string
+}
+
+// parsePathMap parses the value of [LabelClonePathMap]: comma-separated
+// source=destination rules, each a relative path that stays within the
+// layer.
+func parsePathMap(v string) ([]pathRule, error) {
+	var rules []pathRule
+	for _, r := range strings.Split(v, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		src, dst, ok := strings.Cut(r, "=")
+		if !ok {
+			return nil, fmt.Errorf("rule %q: want source=destination", r)
+		}
+		src = filepath.Clean(strings.TrimLeft(strings.TrimSpace(src), "/"))
+		dst = filepath.Clean(strings.TrimLeft(strings.TrimSpace(dst), "/"))
+		if !filepath.IsLocal(src) || !filepath.IsLocal(dst) || src == "." || dst == "." {
+			return nil, fmt.Errorf("rule %q: paths must name a subpath of the layer", r)
+		}
+		rules = append(rules, pathRule{src: src, dst: dst})
+	}
+	return rules, nil
+}
+
+// mapPath returns where rel, a path relative to the source root, is copied
+// to under the path map: the destination of the rule with the longest
+// matching source, or "" if no rule covers rel.  descend reports whether rel
+// is a directory leading to the source of a rule, which is walked but not
+// copied itself.
+func (c *copier) mapPath(rel string) (mapped string, descend bool) {
+	var best *pathRule
+	for i, r := range c.pathMap {
+		if (rel == r.src || strings.HasPrefix(rel, r.src+"/")) && (best == nil || len(r.src) > len(best.src)) {
+			best = &c.pathMap[i]
+		}
+		if strings.HasPrefix(r.src, rel+"/") {
+			descend = true
+		}
+	}
+	if best != nil {
+		return filepath.Join(best.dst, strings.TrimPrefix(rel, best.src)), false
+	}
+	return "", descend
+}
@@ -0,0 +1,66 @@
+package snapshotter_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_PathMap verifies that a clone with a path map copies
+// only the mapped subtrees, each to its destination, and leaves out the
+// rest of the source.
+func TestPrepare_Clone_PathMap(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	for name, content := range map[string]string{
+		"data/a.txt":         "a",
+		"data/sub/b.txt":     "b",
+		"etc/app/app.conf":   "conf",
+		"etc/app/other.conf": "other",
+		"top.txt":            "top",
+	} {
+		path := filepath.Join(srcDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir for %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:  "source",
+		snapshotter.LabelClonePathMap: "data=restored, etc/app/app.conf=restored/conf/app.conf",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	dstDir := writableDir(t, sn, "clone")
+	assertFileContent(t, dstDir, "restored/a.txt", "a")
+	assertFileContent(t, dstDir, "restored/sub/b.txt", "b")
+	assertFileContent(t, dstDir, "restored/conf/app.conf", "conf")
+	for _, name := range []string{"data", "etc", "top.txt"} {
+		if _, err := os.Lstat(filepath.Join(dstDir, name)); !os.IsNotExist(err) {
+			t.Errorf("unmapped %s was cloned (%v)", name, err)
+		}
+	}
+
+	for i, v := range []string{"data", "../data=restored", "data=/"} {
+		if _, err := sn.Prepare(ctx, fmt.Sprintf("bad-%d", i), "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:  "source",
+			snapshotter.LabelClonePathMap: v,
+		})); err == nil {
+			t.Errorf("Prepare with path map %q succeeded", v)
+		}
+	}
+}
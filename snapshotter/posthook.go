@@ -0,0 +1,39 @@
+package snapshotter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/log"
+)
+
+// runPostCloneHook runs PostCloneHook on the clone key of sourceKey, whose
+// mounts are mounts.  The command gets the clone's writable directories as
+// arguments and the keys in its environment; a command that fails fails
+// the clone, with its output in the error.
+func (s *CloneSnapshotter) runPostCloneHook(ctx context.Context, key, sourceKey string, mounts []mount.Mount) error {
+	dirs, err := getWritableDirs(mounts)
+	if err != nil {
+		debugMountOptions(ctx, key, mounts)
+		return fmt.Errorf("post-clone hook: %w", err)
+	}
+	ns, _ := namespaces.Namespace(ctx)
+
+	cmd := exec.CommandContext(ctx, s.PostCloneHook, dirs...)
+	cmd.Env = append(os.Environ(),
+		"CLONE_KEY="+key,
+		"CLONE_SOURCE_KEY="+sourceKey,
+		"CLONE_NAMESPACE="+ns,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("post-clone hook %s: %w: %s", s.PostCloneHook, err, bytes.TrimSpace(out))
+	}
+	log.G(ctx).WithField("key", key).WithField("hook", s.PostCloneHook).Debug("post-clone hook succeeded")
+	return nil
+}
@@ -0,0 +1,67 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// writeHook writes an executable shell script with the given body and
+// returns its path.
+func writeHook(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatalf("write hook: %v", err)
+	}
+	return path
+}
+
+// TestPrepare_Clone_PostCloneHook verifies that the post-clone hook runs on
+// the clone's writable directory with the clone's keys in its environment.
+func TestPrepare_Clone_PostCloneHook(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.PostCloneHook = writeHook(t, `echo "$CLONE_SOURCE_KEY -> $CLONE_KEY" > "$1/marker"`+"\n")
+
+	if _, err := sn.Prepare(ctx, "hook-src", ""); err != nil {
+		t.Fatalf("Prepare hook-src: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "hook-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "hook-src",
+	})); err != nil {
+		t.Fatalf("Prepare hook-clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "hook-clone"), "marker", "hook-src -> hook-clone\n")
+	if _, err := os.Stat(filepath.Join(writableDir(t, sn, "hook-src"), "marker")); !os.IsNotExist(err) {
+		t.Errorf("hook ran on the source: %v", err)
+	}
+}
+
+// TestPrepare_Clone_PostCloneHookFails verifies that a hook exiting non-zero
+// fails the clone, reports the hook's output and removes the clone.
+func TestPrepare_Clone_PostCloneHookFails(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.PostCloneHook = writeHook(t, "echo machine-id reset failed >&2\nexit 3\n")
+
+	if _, err := sn.Prepare(ctx, "hook-src", ""); err != nil {
+		t.Fatalf("Prepare hook-src: %v", err)
+	}
+	_, err := sn.Prepare(ctx, "hook-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "hook-src",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "machine-id reset failed") {
+		t.Fatalf("Prepare hook-clone error = %v, want the hook's output", err)
+	}
+	if _, err := sn.Stat(ctx, "hook-clone"); err == nil {
+		t.Error("clone failed by its hook was not removed")
+	}
+}
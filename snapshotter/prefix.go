@@ -0,0 +1,73 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// resolveSourcePrefix returns the key of the only snapshot whose key starts
+// with prefix.  It fails with [errdefs.ErrNotFound] if there is none and
+// with [errdefs.ErrInvalidArgument] if there are several.
+func (s *CloneSnapshotter) resolveSourcePrefix(ctx context.Context, prefix string) (string, error) {
+	var matches []string
+	err := s.Retry.do(ctx, func() error {
+		matches = matches[:0]
+		return s.Snapshotter.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+			if strings.HasPrefix(info.Name, prefix) {
+				matches = append(matches, info.Name)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("walk snapshots: %w", err)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no snapshot key starts with %q: %w", prefix, errdefs.ErrNotFound)
+	case 1:
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", fmt.Errorf("snapshot key prefix %q is ambiguous, it matches %s: %w",
+			prefix, strings.Join(matches, ", "), errdefs.ErrInvalidArgument)
+	}
+}
+
+// resolveSourceByLabel returns the key of the only snapshot whose label
+// has value.  The snapshots backing view clones are not considered, as
+// they carry the labels of their views.  It fails with
+// [errdefs.ErrNotFound] if there is none and with
+// [errdefs.ErrInvalidArgument] if there are several.
+func (s *CloneSnapshotter) resolveSourceByLabel(ctx context.Context, label, value string) (string, error) {
+	var matches []string
+	err := s.Retry.do(ctx, func() error {
+		matches = matches[:0]
+		return s.Snapshotter.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+			if v, ok := info.Labels[label]; ok && v == value && !strings.HasPrefix(info.Name, viewBackingPrefix) {
+				matches = append(matches, info.Name)
+			}
+			return nil
+		}, fmt.Sprintf(`labels."%s"==%q`, label, value))
+	})
+	if err != nil {
+		return "", fmt.Errorf("walk snapshots: %w", err)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no snapshot has label %s=%q: %w", label, value, errdefs.ErrNotFound)
+	case 1:
+		return matches[0], nil
+	default:
+		sort.Strings(matches)
+		return "", fmt.Errorf("label %s=%q is ambiguous, it is on %s: %w",
+			label, value, strings.Join(matches, ", "), errdefs.ErrInvalidArgument)
+	}
+}
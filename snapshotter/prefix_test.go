@@ -0,0 +1,82 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_PrefixMatch verifies that a unique prefix of the source
+// key resolves to that snapshot and is recorded as its full key.
+func TestPrepare_Clone_PrefixMatch(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "web-3f9a2c", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "web-3f9a2c"), "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "db-77e1b0", ""); err != nil {
+		t.Fatalf("Prepare other: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:      "web-3f",
+		snapshotter.LabelClonePrefixMatch: "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "clone"), "data.txt", "data")
+
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelClonedFrom]; got != "web-3f9a2c" {
+		t.Errorf("label cloned-from = %q, want %q", got, "web-3f9a2c")
+	}
+	if _, ok := info.Labels[snapshotter.LabelClonePrefixMatch]; ok {
+		t.Error("prefix-match label was stored on the clone")
+	}
+
+	// Without the label the prefix is not resolved.
+	if _, err := sn.Prepare(ctx, "exact", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "web-3f",
+	})); !errdefs.IsNotFound(err) {
+		t.Errorf("Prepare with prefix but no prefix-match label error = %v, want not found", err)
+	}
+}
+
+// TestPrepare_Clone_PrefixMatchAmbiguous verifies that a prefix matching
+// several snapshots fails the clone without creating it.
+func TestPrepare_Clone_PrefixMatchAmbiguous(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	for _, key := range []string{"web-3f9a2c", "web-3f0d41"} {
+		if _, err := sn.Prepare(ctx, key, ""); err != nil {
+			t.Fatalf("Prepare %s: %v", key, err)
+		}
+	}
+
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:      "web-3f",
+		snapshotter.LabelClonePrefixMatch: "true",
+	}))
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Fatalf("Prepare clone error = %v, want ErrInvalidArgument", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("clone snapshot was created for an ambiguous prefix")
+	}
+}
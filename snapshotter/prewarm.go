@@ -0,0 +1,80 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/containerd/containerd/snapshots"
+	"golang.org/x/sys/unix"
+)
+
+// PrewarmSource asks the kernel to read the files of the writable layer of
+// the active snapshot key into the page cache, so that the clones of a
+// golden source made afterwards copy from memory rather than from a cold
+// disk.  The reads are started in the background through posix_fadvise
+// POSIX_FADV_WILLNEED; PrewarmSource does not wait for them to complete.
+// Like a clone, it does not cross into other file systems.
+//
+// Committed and view snapshots are left alone: their clones copy nothing.
+func (s *CloneSnapshotter) PrewarmSource(ctx context.Context, key string) error {
+	info, err := s.Snapshotter.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("stat snapshot %q: %w", key, err)
+	}
+	if info.Kind != snapshots.KindActive {
+		return nil
+	}
+	dir, err := s.WritableDir(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	var rootDev uint64
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var dev uint64
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			dev = uint64(st.Dev)
+		}
+		if path == dir {
+			rootDev = dev
+		} else if dev != rootDev {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || fi.Size() == 0 {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_WILLNEED); err != nil {
+			return fmt.Errorf("fadvise %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("prewarm snapshot %q: %w", key, err)
+	}
+	return nil
+}
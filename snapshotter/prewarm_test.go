@@ -0,0 +1,58 @@
+package snapshotter_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrewarmSource verifies that a populated source can be prewarmed, and
+// that it still clones correctly afterwards.
+func TestPrewarmSource(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	big := bytes.Repeat([]byte("golden"), 64<<10)
+	if err := os.MkdirAll(filepath.Join(srcDir, "lib"), 0755); err != nil {
+		t.Fatalf("mkdir lib: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "lib", "big.bin"), big, 0644); err != nil {
+		t.Fatalf("write big.bin: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "empty"), nil, 0644); err != nil {
+		t.Fatalf("write empty: %v", err)
+	}
+	if err := os.Symlink("lib/big.bin", filepath.Join(srcDir, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	if err := sn.PrewarmSource(ctx, "source"); err != nil {
+		t.Fatalf("PrewarmSource: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	dstDir := writableDir(t, sn, "clone")
+	assertFileContent(t, dstDir, "lib/big.bin", string(big))
+	assertFileContent(t, dstDir, "empty", "")
+	if target, err := os.Readlink(filepath.Join(dstDir, "link")); err != nil || target != "lib/big.bin" {
+		t.Errorf("cloned link = %q, %v; want lib/big.bin", target, err)
+	}
+
+	if err := sn.PrewarmSource(ctx, "does-not-exist"); err == nil {
+		t.Error("PrewarmSource of a missing snapshot succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package snapshotter
+
+import "time"
+
+// defaultProgressInterval is the time between progress reports when
+// ProgressInterval is zero.
+const defaultProgressInterval = time.Second
+
+// CloneProgress describes how far the copy of a clone's writable layer has
+// got; see [CloneSnapshotter.OnProgress].
+type CloneProgress struct {
+	// Key is the key of the clone and Source the key of its source.
+	Key    string
+	Source string
+
+	// Files and Bytes are the regular files and file content bytes copied
+	// so far.
+	Files int64
+	Bytes int64
+
+	// TotalBytes is the size of the files of the source's writable layer,
+	// as measured for the space check before they are copied, or zero if it
+	// is unknown, e.g. for lazy and metadata-only clones, which copy no
+	// data.  A live source may grow past it during the copy.
+	TotalBytes int64
+
+	// Started is when the copy started.
+	Started time.Time
+
+	// ETA is when the copy is expected to complete, extrapolated from
+	// TotalBytes and the throughput so far, or the zero time if it cannot
+	// be estimated yet.
+	ETA time.Time
+}
+
+// estimateETA returns when a copy of total bytes started at start, which
+// has copied done bytes by now, completes if it keeps its throughput so
+// far, or the zero time if nothing has been copied yet or total is unknown.
+func estimateETA(start, now time.Time, done, total int64) time.Time {
+	if done <= 0 || total <= 0 {
+		return time.Time{}
+	}
+	if done >= total {
+		return now
+	}
+	elapsed := now.Sub(start)
+	return now.Add(time.Duration(float64(elapsed) * float64(total-done) / float64(done)))
+}
+
+// fileCopied counts a regular file as copied and reports the progress of
+// the copy if a report is due.
+func (c *copier) fileCopied() {
+	c.files++
+	if c.progress == nil {
+		return
+	}
+	now := c.clock()
+	if now.Sub(c.lastProgress) < c.progressInterval {
+		return
+	}
+	c.lastProgress = now
+	c.progress(now)
+}
+
+// trackProgress makes the copier c of the clone key of sourceKey report its
+// progress to OnProgress from now on.
+func (s *CloneSnapshotter) trackProgress(c *copier, key, sourceKey string) {
+	c.clock = s.clock
+	if c.clock == nil {
+		c.clock = time.Now
+	}
+	started := c.clock()
+	c.progressInterval = s.ProgressInterval
+	if c.progressInterval <= 0 {
+		c.progressInterval = defaultProgressInterval
+	}
+	c.lastProgress = started
+	c.progress = func(now time.Time) {
+		s.OnProgress(CloneProgress{
+			Key:        key,
+			Source:     sourceKey,
+			Files:      c.files,
+			Bytes:      c.bytes,
+			TotalBytes: c.total,
+			Started:    started,
+			ETA:        estimateETA(started, now, c.bytes, c.total),
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_ProgressETA verifies that, with the source copied at a
+// steady rate, the completion time estimated once half of it is copied is
+// when the copy completes.  Copying a file advances a fake clock.
+func TestPrepare_Clone_ProgressETA(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	const files, perFile = 10, 40 * time.Millisecond
+	now := time.Unix(1000, 0)
+	sn.clock = func() time.Time { return now }
+	sn.copyHook = func(context.Context, string) error {
+		now = now.Add(perFile)
+		return nil
+	}
+	sn.ProgressInterval = time.Nanosecond
+	var reports []CloneProgress
+	sn.OnProgress = func(p CloneProgress) { reports = append(reports, p) }
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir source: %v", err)
+	}
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(srcDir, fmt.Sprintf("f%d", i)), []byte(strings.Repeat("x", 1000)), 0644); err != nil {
+			t.Fatalf("write f%d: %v", i, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+
+	if len(reports) != files {
+		t.Fatalf("got %d progress reports, want one per file", len(reports))
+	}
+	half := reports[files/2-1]
+	if half.Key != "clone" || half.Source != "source" || half.Bytes != 5000 || half.TotalBytes != 10000 {
+		t.Fatalf("report at half way = %+v, want 5000 of 10000 bytes of clone of source", half)
+	}
+	// The remaining half takes as long as the first one.
+	if want := half.Started.Add(files * perFile); !half.ETA.Equal(want) {
+		t.Errorf("ETA at half way = %v, want %v", half.ETA, want)
+	}
+	if last := reports[files-1]; last.Files != files || last.Bytes != last.TotalBytes {
+		t.Errorf("last report = %+v, want all files and bytes copied", last)
+	}
+}
+
+// TestEstimateETA verifies the extrapolation of the completion time and
+// that nothing is estimated without a known total or copied bytes.
+func TestEstimateETA(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start.Add(10 * time.Second)
+	if got, want := estimateETA(start, now, 25, 100), now.Add(30*time.Second); !got.Equal(want) {
+		t.Errorf("ETA at a quarter = %v, want %v", got, want)
+	}
+	if got := estimateETA(start, now, 0, 100); !got.IsZero() {
+		t.Errorf("ETA with nothing copied = %v, want zero", got)
+	}
+	if got := estimateETA(start, now, 25, 0); !got.IsZero() {
+		t.Errorf("ETA with unknown total = %v, want zero", got)
+	}
+	if got := estimateETA(start, now, 120, 100); !got.Equal(now) {
+		t.Errorf("ETA past the total = %v, want now", got)
+	}
+}
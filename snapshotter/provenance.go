@@ -0,0 +1,35 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// ProvenanceXattr is the extended attribute provenance clones set on each
+// copied regular file and directory, naming the source snapshot; see
+// [LabelCloneProvenance].
+const ProvenanceXattr = "user.clone-source"
+
+// markProvenance sets [ProvenanceXattr] on path, a copied regular file or
+// directory, when provenance marking is enabled.  A filesystem without
+// support for user extended attributes turns marking off for the rest of
+// the clone rather than failing it.
+func (c *copier) markProvenance(ctx context.Context, path string) error {
+	if !c.provenance || c.provenanceOff {
+		return nil
+	}
+	err := unix.Lsetxattr(path, ProvenanceXattr, []byte(c.source), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		log.G(ctx).WithError(err).WithField("path", path).Warn("provenance clone: extended attributes not supported, files are not marked")
+		c.provenanceOff = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("set xattr %s on %s: %w", ProvenanceXattr, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// TestPrepare_Clone_Provenance verifies that a provenance clone marks its
+// copied files and directories with the source key, and that other clones
+// are left unmarked.
+func TestPrepare_Clone_Provenance(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	if err := os.MkdirAll(filepath.Join(srcDir, "dir"), 0755); err != nil {
+		t.Fatalf("create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "dir", "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write file.txt: %v", err)
+	}
+	if err := unix.Lsetxattr(filepath.Join(srcDir, "dir"), "user.test", []byte("x"), 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("user extended attributes not supported")
+	}
+
+	if _, err := sn.Prepare(ctx, "marked", "", snapshotter.WithCloneLabels("source", map[string]string{
+		snapshotter.LabelCloneProvenance: "true",
+	})); err != nil {
+		t.Fatalf("Prepare marked: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "unmarked", "", snapshotter.WithCloneLabels("source", nil)); err != nil {
+		t.Fatalf("Prepare unmarked: %v", err)
+	}
+	buf := make([]byte, 64)
+	for _, name := range []string{"dir", "dir/file.txt"} {
+		path := filepath.Join(writableDir(t, sn, "marked"), name)
+		n, err := unix.Lgetxattr(path, snapshotter.ProvenanceXattr, buf)
+		if err != nil || string(buf[:n]) != "source" {
+			t.Errorf("%s: %s = %q, %v, want source", name, snapshotter.ProvenanceXattr, buf[:max(n, 0)], err)
+		}
+		path = filepath.Join(writableDir(t, sn, "unmarked"), name)
+		if _, err := unix.Lgetxattr(path, snapshotter.ProvenanceXattr, buf); !errors.Is(err, unix.ENODATA) {
+			t.Errorf("%s: unmarked clone has %s (%v)", name, snapshotter.ProvenanceXattr, err)
+		}
+	}
+}
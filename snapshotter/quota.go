@@ -0,0 +1,54 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned (wrapped) by Prepare when a clone would take
+// its namespace past its quota in [CloneSnapshotter.NamespaceQuotas].
+var ErrQuotaExceeded = errors.New("namespace clone quota exceeded")
+
+// reserveQuota charges n bytes against the quota of namespace ns, or fails
+// with [ErrQuotaExceeded] if they do not fit in it.
+func (s *CloneSnapshotter) reserveQuota(ns string, quota, n int64) error {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	used := s.quotaUsed[ns]
+	if used+n > quota {
+		return fmt.Errorf("%w: namespace %q has cloned %d of %d bytes, clone needs %d", ErrQuotaExceeded, ns, used, quota, n)
+	}
+	if s.quotaUsed == nil {
+		s.quotaUsed = make(map[string]int64)
+	}
+	s.quotaUsed[ns] = used + n
+	return nil
+}
+
+// settleQuota replaces the reserved bytes charged against the quota of
+// namespace ns by a clone with the copied bytes it actually wrote, or with
+// nothing if the clone failed.
+func (s *CloneSnapshotter) settleQuota(ns string, reserved, copied int64, ok bool) {
+	if !ok {
+		copied = 0
+	}
+	if reserved == 0 && copied == 0 {
+		// Clones failing before the copy, and those that copy no data,
+		// never reserved any quota.
+		return
+	}
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	if s.quotaUsed == nil {
+		s.quotaUsed = make(map[string]int64)
+	}
+	s.quotaUsed[ns] += copied - reserved
+}
+
+// QuotaUsage returns the number of bytes cloned in namespace ns that count
+// against its quota in [CloneSnapshotter.NamespaceQuotas].
+func (s *CloneSnapshotter) QuotaUsage(ns string) int64 {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+	return s.quotaUsed[ns]
+}
@@ -0,0 +1,72 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_NamespaceQuota verifies that clones in a namespace are
+// refused once they would exceed its quota, that a refused clone is not
+// counted, and that other namespaces are not limited.
+func TestPrepare_Clone_NamespaceQuota(t *testing.T) {
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.NamespaceQuotas = map[string]int64{"tenant": 1500}
+
+	for _, ns := range []string{"tenant", "other"} {
+		ctx := namespaces.WithNamespace(context.Background(), ns)
+		if _, err := sn.Prepare(ctx, ns+"-src", ""); err != nil {
+			t.Fatalf("Prepare %s-src: %v", ns, err)
+		}
+		if err := os.WriteFile(filepath.Join(writableDir(t, sn, ns+"-src"), "data.bin"), make([]byte, 1000), 0644); err != nil {
+			t.Fatalf("write data.bin: %v", err)
+		}
+	}
+
+	ctx := namespaces.WithNamespace(context.Background(), "tenant")
+	if _, err := sn.Prepare(ctx, "clone-1", "", snapshotter.WithCloneLabels("tenant-src", nil)); err != nil {
+		t.Fatalf("Prepare clone-1: %v", err)
+	}
+	if got := sn.QuotaUsage("tenant"); got != 1000 {
+		t.Errorf("quota usage after one clone = %d, want 1000", got)
+	}
+	_, err := sn.Prepare(ctx, "clone-2", "", snapshotter.WithCloneLabels("tenant-src", nil))
+	if !errors.Is(err, snapshotter.ErrQuotaExceeded) {
+		t.Fatalf("Prepare clone-2 error = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := sn.Stat(ctx, "clone-2"); err == nil {
+		t.Error("clone refused by the quota was not removed")
+	}
+	if got := sn.QuotaUsage("tenant"); got != 1000 {
+		t.Errorf("quota usage after a refused clone = %d, want 1000", got)
+	}
+
+	other := namespaces.WithNamespace(context.Background(), "other")
+	for _, key := range []string{"other-clone-1", "other-clone-2"} {
+		if _, err := sn.Prepare(other, key, "", snapshotter.WithCloneLabels("other-src", nil)); err != nil {
+			t.Errorf("Prepare %s in a namespace without quota: %v", key, err)
+		}
+	}
+}
+
+// TestPrepare_Clone_NamespaceQuotaMissingSource verifies that a clone
+// failing before it reserves any quota fails cleanly and charges nothing.
+func TestPrepare_Clone_NamespaceQuotaMissingSource(t *testing.T) {
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.NamespaceQuotas = map[string]int64{"tenant": 1500}
+
+	ctx := namespaces.WithNamespace(context.Background(), "tenant")
+	if _, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("missing", nil)); err == nil {
+		t.Fatal("Prepare from a missing source succeeded")
+	}
+	if got := sn.QuotaUsage("tenant"); got != 0 {
+		t.Errorf("quota usage after a failed clone = %d, want 0", got)
+	}
+}
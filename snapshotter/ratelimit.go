@@ -0,0 +1,62 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// copyLimiter returns the limiter enforcing CopyRateLimit, or nil if copies
+// are unlimited.  Its burst is one copy buffer, so that copies cannot run
+// ahead of the limit by more than that.
+func (s *CloneSnapshotter) copyLimiter() *rate.Limiter {
+	if s.CopyRateLimit <= 0 {
+		return nil
+	}
+	s.limiterOnce.Do(func() {
+		burst := s.CopyBufferSize
+		if burst <= 0 {
+			burst = defaultCopyBufferSize
+		}
+		s.limiter = rate.NewLimiter(rate.Limit(s.CopyRateLimit), burst)
+	})
+	// Pick up changes of CopyRateLimit since the limiter was created.
+	s.limiter.SetLimit(rate.Limit(s.CopyRateLimit))
+	return s.limiter
+}
+
+// rateReader is an io.Reader that waits for the limiter to allow every byte
+// it has read before returning it.
+type rateReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rate.Limiter
+}
+
+func (r rateReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if werr := waitBytes(r.ctx, r.l, n); werr != nil {
+		return 0, werr
+	}
+	return n, err
+}
+
+// waitBytes waits for l to allow n bytes.
+func waitBytes(ctx context.Context, l *rate.Limiter, n int) error {
+	// WaitN rejects requests larger than the burst, which buffers of
+	// another size than the limiter was created with may be.
+	for n > 0 {
+		chunk := min(n, l.Burst())
+		if err := l.WaitN(ctx, chunk); err != nil {
+			if cerr := ctx.Err(); cerr != nil {
+				return cerr
+			}
+			// The wait would outlast the context's deadline.
+			return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+		n -= chunk
+	}
+	return nil
+}
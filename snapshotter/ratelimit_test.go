@@ -0,0 +1,44 @@
+package snapshotter_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_CopyRateLimit verifies that a clone copies no faster
+// than CopyRateLimit allows.
+func TestPrepare_Clone_CopyRateLimit(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.CopyBufferSize = 4 << 10
+	sn.CopyRateLimit = 256 << 10
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	data := bytes.Repeat([]byte("x"), 128<<10)
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "source"), "data.bin"), data, 0644); err != nil {
+		t.Fatalf("write data.bin: %v", err)
+	}
+
+	// 128 KiB at 256 KiB/s, less the one-buffer burst, takes just under
+	// half a second.
+	start := time.Now()
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if elapsed, want := time.Since(start), 400*time.Millisecond; elapsed < want {
+		t.Errorf("clone took %v, want at least %v", elapsed, want)
+	}
+	assertFileContent(t, writableDir(t, sn, "clone"), "data.bin", string(data))
+}
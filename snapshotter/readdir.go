@@ -0,0 +1,64 @@
+package snapshotter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walk walks the tree rooted at root like [filepath.WalkDir], except that
+// with readdirOrder set the entries of each directory are visited in the
+// order the filesystem lists them instead of in lexical order; see
+// [LabelCloneReaddirOrder].
+func (c *copier) walk(root string, fn fs.WalkDirFunc) error {
+	if !c.readdirOrder {
+		return filepath.WalkDir(root, fn)
+	}
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkUnsorted(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkUnsorted is the recursive part of an unsorted walk, mirroring that of
+// [filepath.WalkDir].
+func walkUnsorted(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	f, err := os.Open(path)
+	var entries []fs.DirEntry
+	if err == nil {
+		// File.ReadDir, unlike os.ReadDir, does not sort.
+		entries, err = f.ReadDir(-1)
+		f.Close()
+	}
+	if err != nil {
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if err := walkUnsorted(filepath.Join(path, e.Name()), e, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_ReaddirOrder verifies that a readdir-order clone copies
+// the entries of a directory in the order the source lists them.
+func TestPrepare_Clone_ReaddirOrder(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	var copied []string
+	sn.copyHook = func(_ context.Context, path string) error {
+		copied = append(copied, filepath.Base(path))
+		return nil
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	for i := range 32 {
+		name := fmt.Sprintf("file-%02d", i)
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	f, err := os.Open(srcDir)
+	if err != nil {
+		t.Fatalf("open source: %v", err)
+	}
+	listed, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		t.Fatalf("read source: %v", err)
+	}
+	if slices.IsSorted(listed) {
+		t.Skip("the filesystem lists entries in lexical order")
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource:       "source",
+		LabelCloneReaddirOrder: "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if !slices.Equal(copied, listed) {
+		t.Errorf("clone copied entries in order %q, want the source's order %q", copied, listed)
+	}
+	cloneDir, err := sn.WritableDir(ctx, "clone")
+	if err != nil {
+		t.Fatalf("WritableDir clone: %v", err)
+	}
+	for _, name := range listed {
+		if got, err := os.ReadFile(filepath.Join(cloneDir, name)); err != nil || string(got) != name {
+			t.Errorf("%s = %q, %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package snapshotter
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// checkRebaseParent checks that parent, the snapshot named by
+// [LabelCloneRebase], is one the changes in the writable layer of the
+// source sourceKey can be applied to: a committed snapshot, whose content
+// no longer changes, and not the source itself.
+func checkRebaseParent(sourceKey string, parent snapshots.Info) error {
+	if parent.Name == sourceKey {
+		return fmt.Errorf("%s snapshot %q is the source itself: %w",
+			LabelCloneRebase, parent.Name, errdefs.ErrInvalidArgument)
+	}
+	if parent.Kind != snapshots.KindCommitted {
+		return fmt.Errorf("%s snapshot %q is %v, want a committed snapshot: %w",
+			LabelCloneRebase, parent.Name, parent.Kind, errdefs.ErrInvalidArgument)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+)
+
+// A plugin cannot take containerd leases on the snapshots it serves, and
+// labels set through the snapshotter are not seen by containerd's garbage
+// collector.  Sources are instead protected in the plugin itself: while a
+// clone copies a source, Remove, which is how the garbage collector deletes
+// a snapshot, refuses it.  The collector retries on its next run.  A clone
+// protects its source before looking it up, and Remove checks and removes
+// under the same lock, so that neither can slip in between the other's
+// steps.
+
+// refKey identifies the snapshot key in the namespace of ctx.
+func refKey(ctx context.Context, key string) string {
+	ns, _ := namespaces.Namespace(ctx)
+	return ns + "/" + key
+}
+
+// acquireSource protects the snapshot key in the namespace of ctx from
+// removal until the returned function is called.
+func (s *CloneSnapshotter) acquireSource(ctx context.Context, key string) (release func()) {
+	k := refKey(ctx, key)
+	s.refMu.Lock()
+	if s.sourceRefs == nil {
+		s.sourceRefs = map[string]int{}
+	}
+	s.sourceRefs[k]++
+	s.refMu.Unlock()
+
+	return func() {
+		s.refMu.Lock()
+		defer s.refMu.Unlock()
+		if s.sourceRefs[k]--; s.sourceRefs[k] == 0 {
+			delete(s.sourceRefs, k)
+		}
+	}
+}
+
+// checkNotInUse fails with [errdefs.ErrFailedPrecondition] if the snapshot
+// key in the namespace of ctx is the source of a clone in progress.  The
+// caller must hold s.refMu, and keep holding it until the snapshot is
+// removed, so that no clone can take it as its source in between.
+func (s *CloneSnapshotter) checkNotInUse(ctx context.Context, key string) error {
+	if n := s.sourceRefs[refKey(ctx, key)]; n > 0 {
+		return fmt.Errorf("snapshot %q is the source of %d clone(s) in progress: %w", key, n, errdefs.ErrFailedPrecondition)
+	}
+	return nil
+}
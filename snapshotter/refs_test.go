@@ -0,0 +1,105 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_SourceProtected verifies that the source of a clone
+// cannot be removed, e.g. by containerd's garbage collector, while it is
+// being copied, and can be once the clone is done.
+func TestPrepare_Clone_SourceProtected(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	defer sn.Close()
+
+	var removeErr error
+	sn.copyHook = func(ctx context.Context, _ string) error {
+		// Simulate the garbage collector removing the source mid-copy.
+		removeErr = sn.Remove(ctx, "source")
+		return nil
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if !errors.Is(removeErr, errdefs.ErrFailedPrecondition) {
+		t.Errorf("Remove of the source during the copy error = %v, want ErrFailedPrecondition", removeErr)
+	}
+
+	sn.copyHook = nil
+	if err := sn.Remove(ctx, "source"); err != nil {
+		t.Errorf("Remove of the source after the clone: %v", err)
+	}
+}
+
+// statHookSnapshotter calls onStat, if set, before each Stat.
+type statHookSnapshotter struct {
+	snapshots.Snapshotter
+	onStat func(key string)
+}
+
+func (s *statHookSnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	if s.onStat != nil {
+		s.onStat(key)
+	}
+	return s.Snapshotter.Stat(ctx, key)
+}
+
+// TestPrepare_Clone_SourceProtectedDuringLookup verifies that the source of
+// a clone is already protected while the clone looks it up, so that it
+// cannot be removed between the lookup and the copy.
+func TestPrepare_Clone_SourceProtectedDuringLookup(t *testing.T) {
+	ctx := context.Background()
+	base, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	inner := &statHookSnapshotter{Snapshotter: base}
+	sn := New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	var removeErr error
+	inner.onStat = func(key string) {
+		if key == "source" {
+			// Remove stats the snapshot itself.
+			inner.onStat = nil
+			removeErr = sn.Remove(ctx, "source")
+		}
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if !errors.Is(removeErr, errdefs.ErrFailedPrecondition) {
+		t.Errorf("Remove of the source during its lookup error = %v, want ErrFailedPrecondition", removeErr)
+	}
+}
@@ -0,0 +1,64 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/log"
+)
+
+// defaultReplicateInterval is the time between syncs of ReplicateSource
+// when ReplicateInterval is zero.
+const defaultReplicateInterval = 30 * time.Second
+
+// ReplicateSource keeps dest, an existing active snapshot on the same parent
+// as the active snapshot source, a warm standby of source: it re-syncs dest
+// with an incremental clone of source right away and then every
+// ReplicateInterval, copying only the files that changed, until ctx is done
+// or the snapshotter is closed.  A sync that fails is logged and retried at
+// the next interval, unless source or dest no longer exists.  It returns
+// ctx's error once ctx is done, [ErrClosed] once Close has been called, or
+// the error of a sync that cannot be retried.
+//
+// dest must not be in use while a sync runs, since its files are rewritten
+// in place; it is meant to be used once replication has stopped.
+func (s *CloneSnapshotter) ReplicateSource(ctx context.Context, source, dest string) error {
+	ctx, done := s.startBackground(ctx)
+	defer done()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	if _, err := s.Snapshotter.Stat(ctx, dest); err != nil {
+		return fmt.Errorf("replicate %q to %q: %w", source, dest, err)
+	}
+	interval := s.ReplicateInterval
+	if interval <= 0 {
+		interval = defaultReplicateInterval
+	}
+	opt := WithCloneLabels(source, map[string]string{LabelCloneIncremental: "true"})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := s.Prepare(ctx, dest, "", opt)
+		switch {
+		case ctx.Err() != nil:
+			return context.Cause(ctx)
+		case errdefs.IsNotFound(err):
+			return fmt.Errorf("replicate %q to %q: %w", source, dest, err)
+		case err != nil:
+			log.G(ctx).WithError(err).WithField("source", source).WithField("key", dest).Warn("replication sync failed")
+		default:
+			log.G(ctx).WithField("source", source).WithField("key", dest).Debug("replication sync done")
+		}
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestReplicateSource verifies that a replicated clone converges to its
+// source as the source changes between syncs, and that replication stops
+// when its context is cancelled.
+func TestReplicateSource(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.ReplicateInterval = 10 * time.Millisecond
+
+	if _, err := sn.Prepare(ctx, "repl-src", ""); err != nil {
+		t.Fatalf("Prepare repl-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "repl-src")
+	if err := os.WriteFile(filepath.Join(srcDir, "kept.txt"), []byte("v1"), 0644); err != nil {
+		t.Fatalf("write kept.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "deleted.txt"), []byte("gone soon"), 0644); err != nil {
+		t.Fatalf("write deleted.txt: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "repl-standby", "", snapshotter.WithCloneLabels("repl-src", nil)); err != nil {
+		t.Fatalf("Prepare repl-standby: %v", err)
+	}
+	standbyDir := writableDir(t, sn, "repl-standby")
+
+	replCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- sn.ReplicateSource(replCtx, "repl-src", "repl-standby") }()
+
+	// converged waits until the standby matches want, the expected
+	// content of each file, with nil meaning the file is absent.
+	converged := func(want map[string][]byte) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			match := true
+			for name, data := range want {
+				got, err := os.ReadFile(filepath.Join(standbyDir, name))
+				if data == nil && !os.IsNotExist(err) || data != nil && string(got) != string(data) {
+					match = false
+				}
+			}
+			if match {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("standby did not converge to %q", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, "kept.txt"), []byte("version 2"), 0644); err != nil {
+		t.Fatalf("rewrite kept.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "added.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("write added.txt: %v", err)
+	}
+	converged(map[string][]byte{"kept.txt": []byte("version 2"), "added.txt": []byte("new"), "deleted.txt": []byte("gone soon")})
+
+	if err := os.Remove(filepath.Join(srcDir, "deleted.txt")); err != nil {
+		t.Fatalf("remove deleted.txt: %v", err)
+	}
+	converged(map[string][]byte{"kept.txt": []byte("version 2"), "added.txt": []byte("new"), "deleted.txt": nil})
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ReplicateSource = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReplicateSource did not stop after cancellation")
+	}
+}
+
+// TestReplicateSource_MissingDest verifies that replication needs an
+// existing destination.
+func TestReplicateSource_MissingDest(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "repl-src", ""); err != nil {
+		t.Fatalf("Prepare repl-src: %v", err)
+	}
+	if err := sn.ReplicateSource(ctx, "repl-src", "missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("ReplicateSource to a missing dest = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,106 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+)
+
+// SpaceReport describes how much space a clone's writable layer uses and how
+// much of it is shared with its source.
+type SpaceReport struct {
+	// ApparentBytes is the total size of the clone's regular files, as a
+	// naive sum of clones would count it.
+	ApparentBytes int64
+
+	// AllocatedBytes is the space allocated to the clone's files, counting
+	// each inode once.
+	AllocatedBytes int64
+
+	// SharedBytes is the part of AllocatedBytes held by inodes the clone
+	// shares with its source, i.e. hard links made by a lazy or hardlink
+	// clone.
+	SharedBytes int64
+
+	// UniqueBytes is the part of AllocatedBytes only the clone uses.
+	// Extents shared through reflinks cannot be told apart from copies and
+	// are counted here.
+	UniqueBytes int64
+}
+
+// CloneSpaceReport reports the space used by the writable layer of the clone
+// key, and how much of it is shared with the source it was cloned from.  It
+// fails with [errdefs.ErrInvalidArgument] if key is not a clone.  If the
+// source no longer exists, nothing is shared.
+func (s *CloneSnapshotter) CloneSpaceReport(ctx context.Context, key string) (SpaceReport, error) {
+	info, err := s.Snapshotter.Stat(ctx, key)
+	if err != nil {
+		return SpaceReport{}, fmt.Errorf("stat snapshot %q: %w", key, err)
+	}
+	sourceKey, ok := info.Labels[LabelClonedFrom]
+	if !ok {
+		return SpaceReport{}, fmt.Errorf("snapshot %q is not a clone: %w", key, errdefs.ErrInvalidArgument)
+	}
+	dir, err := s.WritableDir(ctx, key)
+	if err != nil {
+		return SpaceReport{}, err
+	}
+
+	sourceCtx := ctx
+	if ns, ok := info.Labels[LabelClonedFromNamespace]; ok {
+		sourceCtx = namespaces.WithNamespace(ctx, ns)
+	}
+	sourceDir, err := s.WritableDir(sourceCtx, sourceKey)
+	if errdefs.IsNotFound(err) {
+		sourceDir = ""
+	} else if err != nil {
+		return SpaceReport{}, err
+	}
+
+	var r SpaceReport
+	seen := map[uint64]bool{}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		r.ApparentBytes += fi.Size()
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok || seen[st.Ino] {
+			return nil
+		}
+		seen[st.Ino] = true
+		allocated := st.Blocks * 512
+		r.AllocatedBytes += allocated
+
+		shared := false
+		if sourceDir != "" && st.Nlink > 1 {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if src, err := os.Lstat(filepath.Join(sourceDir, rel)); err == nil {
+				shared = os.SameFile(fi, src)
+			}
+		}
+		if shared {
+			r.SharedBytes += allocated
+		} else {
+			r.UniqueBytes += allocated
+		}
+		return nil
+	})
+	if err != nil {
+		return SpaceReport{}, fmt.Errorf("measure clone %q: %w", key, err)
+	}
+	return r, nil
+}
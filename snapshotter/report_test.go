@@ -0,0 +1,64 @@
+package snapshotter_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestCloneSpaceReport verifies that a hardlink clone reports its data as
+// shared with the source and a copying clone reports it as unique.
+func TestCloneSpaceReport(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	data := bytes.Repeat([]byte("x"), 256<<10)
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "source"), "data.bin"), data, 0644); err != nil {
+		t.Fatalf("write data.bin: %v", err)
+	}
+
+	for _, tc := range []struct {
+		key    string
+		labels map[string]string
+		shared bool
+	}{
+		{key: "lazy", labels: map[string]string{snapshotter.LabelCloneLazy: "true"}, shared: true},
+		{key: "copy", shared: false},
+	} {
+		labels := map[string]string{snapshotter.LabelCloneSource: "source"}
+		for k, v := range tc.labels {
+			labels[k] = v
+		}
+		if _, err := sn.Prepare(ctx, tc.key, "", snapshots.WithLabels(labels)); err != nil {
+			t.Fatalf("Prepare %s: %v", tc.key, err)
+		}
+		r, err := sn.CloneSpaceReport(ctx, tc.key)
+		if err != nil {
+			t.Fatalf("CloneSpaceReport %s: %v", tc.key, err)
+		}
+		if r.ApparentBytes != int64(len(data)) {
+			t.Errorf("%s: apparent bytes = %d, want %d", tc.key, r.ApparentBytes, len(data))
+		}
+		if r.AllocatedBytes < int64(len(data)) || r.SharedBytes+r.UniqueBytes != r.AllocatedBytes {
+			t.Errorf("%s: report %+v does not account for the allocated data", tc.key, r)
+		}
+		if shared := r.SharedBytes == r.AllocatedBytes && r.UniqueBytes == 0; shared != tc.shared {
+			t.Errorf("%s: report %+v, want all data shared = %v", tc.key, r, tc.shared)
+		}
+	}
+
+	if _, err := sn.CloneSpaceReport(ctx, "source"); !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("CloneSpaceReport of a non-clone error = %v, want ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,124 @@
+package snapshotter
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// resumeManifestName is the file in a clone's staging directory that lists
+// the files a resumable clone has finished copying.
+const resumeManifestName = "resume-manifest.json"
+
+// resumeEntry records a file a resumable clone has copied: its destination
+// path and the size and modification time of the source it was copied from.
+type resumeEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+// resumeManifest is the manifest of a resumable clone; see
+// [LabelCloneResumable].  It holds one JSON entry per copied file, appended
+// as each file is finished, so that a copy interrupted at any point leaves a
+// manifest listing the files that need not be copied again.
+type resumeManifest struct {
+	f       *os.File
+	enc     *json.Encoder
+	durable bool
+
+	// resumed is set if a previous attempt left the manifest behind.
+	resumed bool
+	// done holds the entries of previous attempts by destination path.
+	done map[string]resumeEntry
+}
+
+// hasResumeManifest reports whether a previous attempt of a resumable clone
+// left its manifest in the staging directory dir.
+func hasResumeManifest(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, resumeManifestName))
+	return err == nil
+}
+
+// openResumeManifest opens the manifest in the staging directory dir,
+// creating both if needed, and loads the entries of previous attempts.  A
+// trailing entry torn by a crash is dropped.  The manifest is synced after
+// every entry if durable is set.
+func openResumeManifest(dir string, durable bool) (*resumeManifest, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, resumeManifestName)
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	m := &resumeManifest{f: f, durable: durable, resumed: statErr == nil, done: map[string]resumeEntry{}}
+
+	dec := json.NewDecoder(f)
+	var end int64
+	for {
+		var e resumeEntry
+		if err := dec.Decode(&e); err != nil {
+			break
+		}
+		m.done[e.Path] = e
+		end = dec.InputOffset()
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.enc = json.NewEncoder(f)
+	return m, nil
+}
+
+// reconcile prepares dst, an existing destination path of a resumed clone,
+// to receive the source entry described by src.  It reports whether dst is
+// a regular file a previous attempt finished copying from a source of the
+// same size and modification time, in which case the copy can be skipped.
+// Any other entry but a directory is removed so that it can be recreated.
+func (m *resumeManifest) reconcile(src fs.FileInfo, dst string) (bool, error) {
+	cur, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case src.Mode().IsDir() && cur.Mode().IsDir():
+		return false, nil
+	case src.Mode().IsRegular() && cur.Mode().IsRegular():
+		e, ok := m.done[dst]
+		if ok && e.Size == src.Size() && e.ModTime == src.ModTime().UnixNano() && cur.Size() == src.Size() {
+			return true, nil
+		}
+	}
+	return false, os.RemoveAll(dst)
+}
+
+// record adds the file dst, copied from the source described by src, to the
+// manifest.
+func (m *resumeManifest) record(src fs.FileInfo, dst string) error {
+	if err := m.enc.Encode(resumeEntry{Path: dst, Size: src.Size(), ModTime: src.ModTime().UnixNano()}); err != nil {
+		return err
+	}
+	if m.durable {
+		return m.f.Sync()
+	}
+	return nil
+}
+
+// remove deletes the manifest of a clone that completed.
+func (m *resumeManifest) remove() error {
+	return os.Remove(m.f.Name())
+}
@@ -0,0 +1,131 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_Resumable verifies that a resumable clone interrupted
+// part way is kept, and that preparing it again after a restart copies only
+// the files its manifest does not list as up to date.
+func TestPrepare_Clone_Resumable(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	staging := t.TempDir()
+	sn := New(inner)
+	sn.StagingDir = staging
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	for _, name := range []string{"f0", "f1", "f2", "f3", "f4"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	// The first attempt fails before copying f3.
+	errInterrupted := errors.New("interrupted")
+	sn.copyHook = func(_ context.Context, path string) error {
+		if filepath.Base(path) == "f3" {
+			return errInterrupted
+		}
+		return nil
+	}
+	labels := map[string]string{
+		LabelCloneSource:    "source",
+		LabelCloneResumable: "true",
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(labels)); !errors.Is(err, errInterrupted) {
+		t.Fatalf("first Prepare clone error = %v, want the interruption", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err != nil {
+		t.Fatalf("interrupted resumable clone was not kept: %v", err)
+	}
+
+	// Between attempts f0 changes and f1 is deleted in the source.
+	if err := os.WriteFile(filepath.Join(srcDir, "f0"), []byte("f0 rewritten"), 0644); err != nil {
+		t.Fatalf("rewrite f0: %v", err)
+	}
+	if err := os.Remove(filepath.Join(srcDir, "f1")); err != nil {
+		t.Fatalf("remove f1: %v", err)
+	}
+
+	// A new snapshotter over the same state stands for a restarted plugin.
+	sn = New(inner)
+	sn.StagingDir = staging
+	if err := sn.SweepStaging(); err != nil {
+		t.Fatalf("SweepStaging: %v", err)
+	}
+	var copied []string
+	sn.copyHook = func(_ context.Context, path string) error {
+		copied = append(copied, filepath.Base(path))
+		return nil
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(labels)); err != nil {
+		t.Fatalf("resumed Prepare clone: %v", err)
+	}
+	if want := []string{"f0", "f3", "f4"}; !slices.Equal(copied, want) {
+		t.Errorf("resumed clone copied %q, want %q", copied, want)
+	}
+
+	cloneDir, err := sn.WritableDir(ctx, "clone")
+	if err != nil {
+		t.Fatalf("WritableDir clone: %v", err)
+	}
+	for name, want := range map[string]string{
+		"f0": "f0 rewritten",
+		"f2": "content of f2",
+		"f3": "content of f3",
+		"f4": "content of f4",
+	} {
+		if got, err := os.ReadFile(filepath.Join(cloneDir, name)); err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(cloneDir, "f1")); !os.IsNotExist(err) {
+		t.Errorf("f1 deleted from the source is still in the clone: %v", err)
+	}
+	if hasResumeManifest(sn.StagingPath(ctx, "clone")) {
+		t.Error("resume manifest left behind by a completed clone")
+	}
+}
+
+// TestPrepare_Clone_ResumableNeedsStaging verifies that a resumable clone is
+// refused without a staging directory to keep its manifest in.
+func TestPrepare_Clone_ResumableNeedsStaging(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	_, err = sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource:    "source",
+		LabelCloneResumable: "true",
+	}))
+	if !errdefs.IsFailedPrecondition(err) {
+		t.Fatalf("Prepare clone error = %v, want ErrFailedPrecondition", err)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("refused clone left a snapshot behind")
+	}
+}
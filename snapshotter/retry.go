@@ -0,0 +1,87 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// RetryPolicy controls how transient failures of the inner snapshotter are
+// retried while resolving a clone source.  The zero value performs a single
+// attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Values below 2 disable retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry.  Each subsequent retry
+	// doubles it, and a random jitter of up to half the delay is subtracted
+	// so that concurrent clones do not retry in lockstep.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between attempts.  Zero means no cap.
+	MaxDelay time.Duration
+
+	// Retryable reports whether err is transient.  When nil,
+	// [IsRetryable] is used.
+	Retryable func(err error) bool
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying:
+// errdefs Unavailable or Aborted errors, or an EAGAIN, EBUSY or EINTR from
+// the kernel.  Permanent errors such as NotFound are not retryable.
+func IsRetryable(err error) bool {
+	switch {
+	case errdefs.IsUnavailable(err), errdefs.IsAborted(err):
+		return true
+	case errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EBUSY), errors.Is(err, syscall.EINTR):
+		return true
+	}
+	return false
+}
+
+// do calls fn until it succeeds, returns a non-retryable error, the attempts
+// are exhausted or ctx is done.  The last error from fn is returned, or, if
+// ctx is done while waiting to retry, the cause of ctx with the last error
+// noted.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		t := time.NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w (last error: %v)", context.Cause(ctx), err)
+		case <-t.C:
+		}
+	}
+}
+
+// delay returns the backoff before retry number attempt (starting at 1).
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - rand.N(d/2+1)
+}
@@ -0,0 +1,158 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// flakySnapshotter fails the first statFailures Stat calls and the first
+// mountsFailures Mounts calls with a transient error, then delegates.
+type flakySnapshotter struct {
+	snapshots.Snapshotter
+	statFailures, mountsFailures int
+	statCalls, mountsCalls       int
+}
+
+func (f *flakySnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	f.statCalls++
+	if f.statCalls <= f.statFailures {
+		return snapshots.Info{}, fmt.Errorf("metadata db busy: %w", errdefs.ErrUnavailable)
+	}
+	return f.Snapshotter.Stat(ctx, key)
+}
+
+func (f *flakySnapshotter) Mounts(ctx context.Context, key string) ([]mount.Mount, error) {
+	f.mountsCalls++
+	if f.mountsCalls <= f.mountsFailures {
+		return nil, fmt.Errorf("metadata db busy: %w", errdefs.ErrUnavailable)
+	}
+	return f.Snapshotter.Mounts(ctx, key)
+}
+
+// TestPrepare_Clone_RetriesTransientErrors verifies that transient Stat and
+// Mounts failures are retried and the clone eventually completes.
+func TestPrepare_Clone_RetriesTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	mounts, err := inner.Prepare(ctx, "retry-src", "")
+	if err != nil {
+		t.Fatalf("Prepare retry-src: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(mounts[0].Source, "data.txt"), []byte("eventually"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	flaky := &flakySnapshotter{Snapshotter: inner, statFailures: 2, mountsFailures: 2}
+	sn := snapshotter.New(flaky)
+	sn.Retry = snapshotter.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	mounts, err = sn.Prepare(ctx, "retry-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "retry-src",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Prepare retry-clone: %v", err)
+	}
+	assertFileContent(t, mounts[0].Source, "data.txt", "eventually")
+	if flaky.statCalls != 3 || flaky.mountsCalls != 3 {
+		t.Errorf("Stat calls = %d, Mounts calls = %d, want 3 each", flaky.statCalls, flaky.mountsCalls)
+	}
+}
+
+// TestPrepare_Clone_RetryExhausted verifies that the clone fails once the
+// retry attempts are used up.
+func TestPrepare_Clone_RetryExhausted(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	if _, err := inner.Prepare(ctx, "retry-src", ""); err != nil {
+		t.Fatalf("Prepare retry-src: %v", err)
+	}
+
+	flaky := &flakySnapshotter{Snapshotter: inner, statFailures: 5}
+	sn := snapshotter.New(flaky)
+	sn.Retry = snapshotter.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	_, err = sn.Prepare(ctx, "retry-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "retry-src",
+		}),
+	)
+	if !errdefs.IsUnavailable(err) {
+		t.Fatalf("Prepare error = %v, want Unavailable", err)
+	}
+	if flaky.statCalls != 3 {
+		t.Errorf("Stat calls = %d, want 3", flaky.statCalls)
+	}
+}
+
+// TestPrepare_Clone_RetryContextDone verifies that a clone whose context
+// ends while waiting to retry fails with the context's error, not with the
+// transient one.
+func TestPrepare_Clone_RetryContextDone(t *testing.T) {
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	if _, err := inner.Prepare(context.Background(), "retry-src", ""); err != nil {
+		t.Fatalf("Prepare retry-src: %v", err)
+	}
+
+	flaky := &flakySnapshotter{Snapshotter: inner, statFailures: 5}
+	sn := snapshotter.New(flaky)
+	sn.Retry = snapshotter.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = sn.Prepare(ctx, "retry-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "retry-src",
+		}),
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Prepare error = %v, want DeadlineExceeded", err)
+	}
+	if flaky.statCalls != 1 {
+		t.Errorf("Stat calls = %d, want 1", flaky.statCalls)
+	}
+}
+
+// TestPrepare_Clone_NotFoundNotRetried verifies that permanent errors are
+// returned immediately.
+func TestPrepare_Clone_NotFoundNotRetried(t *testing.T) {
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	flaky := &flakySnapshotter{Snapshotter: inner}
+	sn := snapshotter.New(flaky)
+	sn.Retry = snapshotter.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond}
+
+	if _, err := sn.Prepare(context.Background(), "retry-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "does-not-exist",
+		}),
+	); err == nil {
+		t.Fatal("expected error for missing source snapshot, got nil")
+	}
+	if flaky.statCalls != 1 {
+		t.Errorf("Stat calls = %d, want 1", flaky.statCalls)
+	}
+}
@@ -0,0 +1,37 @@
+package snapshotter
+
+import (
+	"fmt"
+	"os"
+)
+
+// writeSkeleton creates dst as a sparse file of the same size as src,
+// without copying any data, for a [LabelCloneMetadataOnly] clone.
+func (c *copier) writeSkeleton(src, dst string, mode os.FileMode) (retErr error) {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := breakLink(dst); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); cerr != nil && retErr == nil {
+			retErr = cerr
+		}
+	}()
+	if err := out.Truncate(fi.Size()); err != nil {
+		return err
+	}
+	c.fileCopied()
+	if c.durable {
+		if err := c.syncFile(out); err != nil {
+			return fmt.Errorf("sync %s: %w", dst, err)
+		}
+	}
+	return nil
+}
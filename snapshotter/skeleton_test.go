@@ -0,0 +1,106 @@
+package snapshotter_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// TestPrepare_Clone_MetadataOnly verifies that a metadata-only clone has the
+// source's tree, modes and file sizes, while its files read as zeros and
+// allocate no blocks.
+func TestPrepare_Clone_MetadataOnly(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "skel-src", ""); err != nil {
+		t.Fatalf("Prepare skel-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "skel-src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "etc", "app"), 0750); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	files := map[string][]byte{
+		"etc/app/config": []byte("key=value\n"),
+		"data.bin":       bytes.Repeat([]byte{0xab}, 1<<20),
+		"empty":          nil,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), data, 0640); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink("etc/app/config", filepath.Join(srcDir, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "skel-clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:       "skel-src",
+		snapshotter.LabelCloneMetadataOnly: "true",
+	})); err != nil {
+		t.Fatalf("Prepare skel-clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "skel-clone")
+
+	for name, data := range files {
+		path := filepath.Join(cloneDir, name)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("stat %s: %v", name, err)
+			continue
+		}
+		if fi.Size() != int64(len(data)) || fi.Mode().Perm() != 0640 {
+			t.Errorf("%s: size %d mode %v, want size %d mode 0640", name, fi.Size(), fi.Mode().Perm(), len(data))
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if !bytes.Equal(got, make([]byte, len(data))) {
+			t.Errorf("%s: clone has data, want zeros", name)
+		}
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if st.Blocks != 0 {
+			t.Errorf("%s: clone allocates %d blocks, want a sparse file", name, st.Blocks)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(cloneDir, "etc", "app")); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("etc/app: %v, %v, want a 0750 directory", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(cloneDir, "link")); err != nil || target != "etc/app/config" {
+		t.Errorf("link = %q, %v, want etc/app/config", target, err)
+	}
+}
+
+// TestPrepare_Clone_MetadataOnlyConflicts verifies that a metadata-only clone
+// cannot also be lazy or streamed through tar.
+func TestPrepare_Clone_MetadataOnlyConflicts(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "skel-src", ""); err != nil {
+		t.Fatalf("Prepare skel-src: %v", err)
+	}
+	for _, label := range []string{snapshotter.LabelCloneLazy, snapshotter.LabelCloneViaTar} {
+		_, err := sn.Prepare(ctx, "skel-clone", "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:       "skel-src",
+			snapshotter.LabelCloneMetadataOnly: "true",
+			label:                              "true",
+		}))
+		if !errdefs.IsInvalidArgument(err) {
+			t.Errorf("metadata-only with %s: error = %v, want ErrInvalidArgument", label, err)
+		}
+	}
+}
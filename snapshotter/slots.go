@@ -0,0 +1,40 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+)
+
+// acquireSlot waits until fewer than MaxConcurrentClones clones are running
+// and takes a slot, or returns ctx's error.  The returned function frees the
+// slot.
+func (s *CloneSnapshotter) acquireSlot(ctx context.Context) (release func(), err error) {
+	if s.MaxConcurrentClones <= 0 {
+		return func() {}, nil
+	}
+	s.slotsOnce.Do(func() {
+		s.slots = make(chan struct{}, s.MaxConcurrentClones)
+	})
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for one of %d concurrent clone slots: %w", cap(s.slots), ctx.Err())
+	}
+}
+
+// acquireFileSlot waits until a file can be copied within MaxOpenFiles and
+// takes a file slot, or returns ctx's error.  Copying a file holds two
+// descriptors, its source and its copy, so there are half as many slots as
+// MaxOpenFiles, and at least one.  The returned function frees the slot.
+func (s *CloneSnapshotter) acquireFileSlot(ctx context.Context) (release func(), err error) {
+	s.fileSlotsOnce.Do(func() {
+		s.fileSlots = make(chan struct{}, max(s.MaxOpenFiles/2, 1))
+	})
+	select {
+	case s.fileSlots <- struct{}{}:
+		return func() { <-s.fileSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for one of %d open file slots: %w", cap(s.fileSlots), ctx.Err())
+	}
+}
@@ -0,0 +1,168 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_MaxConcurrentClones verifies that no more than
+// MaxConcurrentClones clones copy at the same time, that waiting clones give
+// up with their context, and that plain Prepare calls are not held up.
+func TestPrepare_Clone_MaxConcurrentClones(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	defer sn.Close()
+	sn.MaxConcurrentClones = 2
+	var running, peak atomic.Int32
+	gate := make(chan struct{})
+	sn.copyHook = func(context.Context, string) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-gate
+		return nil
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	const clones = 6
+	var wg sync.WaitGroup
+	errs := make(chan error, clones)
+	for i := 0; i < clones; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sn.Prepare(ctx, fmt.Sprintf("clone-%d", i), "", snapshots.WithLabels(map[string]string{
+				LabelCloneSource: "source",
+			}))
+			errs <- err
+		}()
+	}
+
+	// Wait for the slots to fill up.
+	for deadline := time.Now().Add(5 * time.Second); running.Load() < 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("clones did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// With every slot taken, plain snapshots are still prepared and
+	// another clone gives up with its context.
+	if _, err := sn.Prepare(ctx, "plain", ""); err != nil {
+		t.Errorf("Prepare without a clone: %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := sn.Prepare(waitCtx, "waiting", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Prepare clone waiting for a slot error = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := sn.Stat(ctx, "waiting"); err == nil {
+		t.Error("clone that never got a slot was created")
+	}
+
+	close(gate)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Prepare clone: %v", err)
+		}
+	}
+	if p := peak.Load(); p != 2 {
+		t.Errorf("at most %d clones copied at once, want 2", p)
+	}
+}
+
+// countingWriter tracks, in running and peak, how many writers are in use
+// at once.
+type countingWriter struct {
+	io.Writer
+	running, peak *atomic.Int32
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n := w.running.Add(1)
+	defer w.running.Add(-1)
+	for {
+		p := w.peak.Load()
+		if n <= p || w.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return w.Writer.Write(p)
+}
+
+// TestCopyDir_MaxOpenFiles verifies that concurrent copies succeed with a
+// low open file budget, copying no more files at once than it allows.
+func TestCopyDir_MaxOpenFiles(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(src, fmt.Sprintf("file-%d", i)), []byte("data"), 0644); err != nil {
+			t.Fatalf("write file-%d: %v", i, err)
+		}
+	}
+	sn := &CloneSnapshotter{MaxOpenFiles: 3}
+	var running, peak atomic.Int32
+
+	const copies = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, copies)
+	for i := 0; i < copies; i++ {
+		dst := t.TempDir()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := &copier{
+				acquireFile: sn.acquireFileSlot,
+				wrapDst: func(w io.Writer) io.Writer {
+					return countingWriter{w, &running, &peak}
+				},
+			}
+			errs <- c.copyDir(ctx, src, dst)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("copyDir: %v", err)
+		}
+	}
+	if got := peak.Load(); got != 1 {
+		t.Errorf("peak files copied at once = %d, want 1", got)
+	}
+}
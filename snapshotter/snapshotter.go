@@ -5,19 +5,35 @@
 // existing active snapshot, the new snapshot is initialized with a copy of
 // the source snapshot's writable layer, giving the new container the same
 // filesystem state as the source container.
+//
+// Every clone is labelled with [LabelClonedFrom] (and, for cross-namespace
+// clones, [LabelClonedFromNamespace]) so that Stat and Walk on the wrapped
+// snapshotter expose where each clone came from.
 package snapshotter
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
-	"io/fs"
-	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/identifiers"
 	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
 )
 
 // LabelCloneSource is the snapshot label key used to specify the source
@@ -30,18 +46,609 @@ import (
 //	        LabelCloneSource: "source-container",
 //	    }),
 //	)
+//
+// The value may refer to the key of the new snapshot as ${key}, which
+// Prepare and View replace with that key before looking up the source: a
+// source of "${key}-prev" clones "web-prev" into "web".
 const LabelCloneSource = "containerd.io/snapshot/clone-source"
 
+// sourceKeyVar is the placeholder in [LabelCloneSource] for the key of the
+// new snapshot.
+const sourceKeyVar = "${key}"
+
+// LabelCloneSourceNamespace is the snapshot label key used to name the
+// containerd namespace that holds the clone source.  When absent, the source
+// is looked up in the same namespace as the new snapshot.  Only the source
+// Stat and Mounts calls use this namespace; the clone itself is always
+// prepared in the caller's namespace.
+const LabelCloneSourceNamespace = "containerd.io/snapshot/clone-source-namespace"
+
+// LabelClonedFrom is the snapshot label key recorded on every clone.  Its
+// value is the key of the snapshot the clone was copied from.  Unlike
+// [LabelCloneSource] it is persisted, giving operators an audit trail via
+// Stat and Walk.
+const LabelClonedFrom = "containerd.io/snapshot/cloned-from"
+
+// LabelClonedFromNamespace is recorded alongside [LabelClonedFrom] when the
+// clone source was looked up in another namespace via
+// [LabelCloneSourceNamespace].
+const LabelClonedFromNamespace = "containerd.io/snapshot/cloned-from-namespace"
+
+// LabelClonedFromUpperdir is recorded on clones requested with
+// [LabelCloneOverlayShare].  Its value is the colon-separated list of shared
+// upperdirs, topmost first, that Mounts stacks on the clone's lower
+// directories.
+const LabelClonedFromUpperdir = "containerd.io/snapshot/cloned-from-upperdir"
+
+// LabelCloneExclude is the snapshot label key used to leave paths out of a
+// clone.  Its value is a comma-separated list of [filepath.Match] patterns
+// matched against paths relative to the writable layer root, e.g.
+// "var/log,tmp/*".  A matching directory is skipped together with everything
+// below it.
+const LabelCloneExclude = "containerd.io/snapshot/clone-exclude"
+
+// LabelCloneIncremental is the snapshot label key used to re-clone a source
+// into an existing snapshot.  When set to "true" and the new snapshot's key
+// already names an active snapshot with the same parent as the source, that
+// snapshot is reused: only files whose mode, size or modification time
+// differ are copied and files the source no longer has are deleted, much like
+// rsync.
+// This makes repeatedly resetting a container to a golden source cheap.
+const LabelCloneIncremental = "containerd.io/snapshot/clone-incremental"
+
+// LabelCloneThin is the snapshot label key used to request a thin clone from
+// a bind-mounted backend such as containerd's native snapshotter.  Its
+// writable directory holds the full tree rather than an overlay upperdir,
+// but a new snapshot starts out as a copy of its parent, so when set to
+// "true" only the entries the source changed relative to the parent are
+// copied and those it deleted are removed.  Overlay clones are always thin
+// and ignore the label.
+const LabelCloneThin = "containerd.io/snapshot/clone-thin"
+
+// LabelCloneMerge is the snapshot label key used to merge a source's
+// writable layer over an existing destination instead of replacing it.  When
+// set to "true" the destination is not cleared first: source entries
+// overwrite same-named ones, and everything else already there is kept.  As
+// with [LabelCloneIncremental], an existing active snapshot with the same
+// parent as the source is reused as the destination.
+const LabelCloneMerge = "containerd.io/snapshot/clone-merge"
+
+// LabelCloneLazy is the snapshot label key used to request an experimental
+// lazy clone.  When set to "true", regular files are hard-linked from the
+// source instead of copied, so the clone is nearly free and shares file data
+// and metadata with the source until either side replaces a file.
+//
+// overlayfs writes upperdir files in place, so a lazy clone is only
+// independent of its source for files that are replaced (written to a new
+// file and renamed over the old one) rather than modified in place; the
+// plugin itself always replaces linked files it writes to.  Files that
+// cannot be linked, e.g. across filesystems, are copied.
+const LabelCloneLazy = "containerd.io/snapshot/clone-lazy"
+
+// LabelCloneCrossMounts is the snapshot label key used to copy file systems
+// mounted inside the source's writable layer, such as bind mounts.  By
+// default a clone does not cross mount points: a directory or file on a
+// different device than the writable layer root is skipped, so that foreign
+// or host data is never copied.  Set it to "true" to copy them as well.
+const LabelCloneCrossMounts = "containerd.io/snapshot/clone-cross-mounts"
+
+// Clone statistics labels are set by the plugin on every clone once its
+// writable layer has been copied, so that Stat reveals what the clone took.
+// A later incremental or merge clone into the same snapshot replaces them.
+const (
+	// LabelCloneBytes is the number of file content bytes copied, in
+	// decimal.  Data shared rather than copied, e.g. by a lazy clone or a
+	// clone strategy, is not counted.
+	LabelCloneBytes = "containerd.io/snapshot/clone-bytes"
+
+	// LabelCloneFiles is the number of regular files copied or linked, in
+	// decimal.
+	LabelCloneFiles = "containerd.io/snapshot/clone-files"
+
+	// LabelCloneDuration is how long the clone took, as a
+	// [time.Duration] string such as "1.5s".
+	LabelCloneDuration = "containerd.io/snapshot/clone-duration"
+
+	// LabelCloneSkipped is the number of files a best-effort clone could
+	// not copy, or a fit clone left out, in decimal.  It is only set by
+	// best-effort and fit clones; see [LabelCloneFitBudget].
+	LabelCloneSkipped = "containerd.io/snapshot/clone-skipped"
+)
+
+// LabelCloneBestEffort is the snapshot label key used to request a
+// best-effort clone.  When set to "true", a regular file that cannot be
+// copied, e.g. because of an I/O error, is left out of the clone instead of
+// failing it; each skipped file is logged as a warning and their number is
+// recorded in [LabelCloneSkipped].  Failures that are not specific to one
+// file, such as cancellation or exceeding [CloneSnapshotter.MaxCloneBytes],
+// still fail the clone.
+const LabelCloneBestEffort = "containerd.io/snapshot/clone-best-effort"
+
+// LabelClonePrefixMatch is the snapshot label key used to let
+// [LabelCloneSource] name the source by a prefix of its key, the way ctr
+// accepts short IDs.  When set to "true" and no snapshot has exactly the
+// given key, the source is the only snapshot whose key starts with it; the
+// clone fails if several do.  [LabelClonedFrom] records the full key.
+const LabelClonePrefixMatch = "containerd.io/snapshot/clone-prefix-match"
+
+// LabelCloneOverlayShare is the snapshot label key used to request a clone
+// that shares the source's writable layer instead of copying it.  When set to
+// "true", the source's upperdir becomes the topmost lowerdir of the clone's
+// overlay mounts, so the clone is created almost instantly and takes no
+// space until it is written to.  The source must be an overlay snapshot with
+// a parent.  An overlay-share clone can itself only be cloned this way.
+//
+// The source must outlive the clone, and overlayfs does not define what a
+// mounted clone sees of changes the source makes after it is mounted.
+// Committing the clone only commits its own upperdir.
+const LabelCloneOverlayShare = "containerd.io/snapshot/clone-overlay-share"
+
+// LabelCloneViaTar is the snapshot label key used to copy the source's
+// writable layer by streaming it through a tar archive, as `tar c | tar x`
+// would.  When set to "true", entries are created in the order they are
+// archived and the clone also keeps what a regular copy does not: hard
+// links between files, FIFOs and every extended attribute.  Clone
+// strategies and [LabelCloneThin] are not used, and it cannot be combined
+// with [LabelCloneIncremental], [LabelCloneMerge], [LabelCloneLazy] or
+// [CloneSnapshotter.DirectIO].
+const LabelCloneViaTar = "containerd.io/snapshot/clone-via-tar"
+
+// LabelCloneParent is the snapshot label key used to prepare the clone on a
+// different committed snapshot than the source's parent, e.g. a newer build
+// of the same base image, with the source's writable layer copied on top.
+// The parent must exist in the clone's namespace and be compatible with the
+// source's changes; the plugin cannot check the latter.  Only a source whose
+// writable layer holds just its own changes can be rebased: an overlay
+// snapshot, or one without a parent.  Read-only sources and thin clones
+// cannot be rebased.
+const LabelCloneParent = "containerd.io/snapshot/clone-parent"
+
+// LabelCloneRebase is the snapshot label key used to rebase a running
+// container's changes onto a newer image for a rolling update.  Like
+// [LabelCloneParent], its value is the key of the committed snapshot the
+// clone is prepared on, with the source's writable layer copied on top, and
+// the same sources can be rebased.  In addition, the new parent is checked
+// for compatibility with the source before anything is created: it must be
+// a committed snapshot, whose content no longer changes, rather than an
+// active one or the source itself.  An incompatible parent fails the clone
+// with [errdefs.ErrInvalidArgument].  It cannot be combined with
+// [LabelCloneParent].
+const LabelCloneRebase = "containerd.io/snapshot/clone-rebase"
+
+// LabelClonePathMap is the snapshot label key used to clone only parts of
+// the source's writable layer, each to another place in the clone.  Its
+// value is a comma-separated list of source=destination rules, e.g.
+// "app=app-backup,etc/app.conf=app-backup/app.conf", with paths relative to
+// the layer root.  A source path is copied to the destination of the rule
+// with the longest matching source path; everything not under a rule's
+// source is left out.  [LabelCloneExclude] patterns still match source
+// paths.  Clone strategies and [LabelCloneThin] are not used, and it cannot
+// be combined with [LabelCloneIncremental] or [LabelCloneViaTar].
+const LabelClonePathMap = "containerd.io/snapshot/clone-path-map"
+
+// LabelCloneMetadataOnly is the snapshot label key used to request a
+// skeleton clone for tests and analysis.  When set to "true", the clone has
+// the source's directory tree, names, modes, times, symlinks and device nodes,
+// but every regular file is created as a sparse file of its source's size
+// that reads as zeros; no file data is copied and almost no space is used.
+// It cannot be combined with [LabelCloneLazy] or [LabelCloneViaTar].
+const LabelCloneMetadataOnly = "containerd.io/snapshot/clone-metadata-only"
+
+// LabelCloneResumable is the snapshot label key used to make a long clone
+// restartable.  When set to "true", the clone records the files it has
+// finished copying in a manifest in its staging directory, and a clone that
+// fails is left in place instead of being removed.  Preparing the same key
+// again with the same labels resumes it: files the manifest lists with the
+// size and modification time they still have in the source are not copied
+// again, and files since deleted from the source are removed.  The manifest
+// is deleted once the clone completes.  It needs
+// [CloneSnapshotter.StagingDir] and cannot be combined with
+// [LabelCloneIncremental], [LabelCloneMerge], [LabelCloneLazy],
+// [LabelCloneViaTar] or [LabelClonePathMap].
+const LabelCloneResumable = "containerd.io/snapshot/clone-resumable"
+
+// LabelCloneReaddirOrder is the snapshot label key used to create the
+// entries of each directory of the clone in the order the source's
+// directory lists them, rather than in lexical order.  When set to "true",
+// applications that depend on readdir order and filesystems that lay out
+// directories by creation order see a layout closer to the source's.  POSIX
+// does not guarantee any readdir order, so the clone may still list its
+// entries in another order, e.g. in hash order on ext4 with dir_index.
+const LabelCloneReaddirOrder = "containerd.io/snapshot/clone-readdir-order"
+
+// LabelCloneFromTar is the snapshot label key used to seed a new snapshot
+// from an archive instead of from another snapshot.  Its value is the
+// absolute path of a tar archive, such as an OCI layer, plain or gzip or
+// zstd compressed, which must lie under [CloneSnapshotter.ArchiveDir].  The
+// snapshot is prepared on the parent given to Prepare and the archive is
+// extracted into its writable directory, applying any whiteouts it holds.
+// It cannot be combined with [LabelCloneSource]; of the other clone control
+// labels, none apply.  The extraction is bound by the limits of a clone,
+// such as MaxCloneBytes, the space checks, NamespaceQuotas, CloneTimeout
+// and CopyRateLimit, and can be aborted with AbortClone.
+const LabelCloneFromTar = "containerd.io/snapshot/clone-from-tar"
+
+// LabelCloneFitBudget is the snapshot label key used to request a clone that
+// fits a size budget.  Its value is the budget in bytes, in decimal.  When
+// the regular files of the source's writable layer take more than that,
+// the smallest files are copied, in ascending size order, until the next
+// one would exceed the budget, and every larger file is left out; each
+// skipped file is logged as a warning and their number is recorded in
+// [LabelCloneSkipped].  Sizes are measured before copying, so a live source
+// may still take the clone slightly past the budget.  It cannot be
+// combined with incremental, merge, lazy, metadata-only, tar or resumable
+// clones, and clone strategies are not used.
+const LabelCloneFitBudget = "containerd.io/snapshot/clone-fit-budget"
+
+// LabelCloneSourceByLabel is the snapshot label key used to name the clone
+// source by one of its labels instead of its key, decoupling clones from
+// volatile keys.  Its value is a label key, and [LabelCloneSource] holds the
+// value of that label: the source is the only snapshot, in the source
+// namespace, whose label has that value, e.g. a digest recorded on a golden
+// snapshot.  The clone fails with [errdefs.ErrNotFound] if no snapshot
+// matches and with [errdefs.ErrInvalidArgument] if several do.  It cannot be
+// combined with [LabelClonePrefixMatch].  [LabelClonedFrom] records the key
+// of the snapshot found.
+const LabelCloneSourceByLabel = "containerd.io/snapshot/clone-source-by-label"
+
+// LabelCloneFollowSymlinks is the snapshot label key used to request a deep
+// clone, which copies what the symlinks in the source's writable layer lead
+// to in place of the links.  Set it to "true" to enable it.  Links are
+// resolved inside the writable layer, as if it were the root directory, so
+// a deep clone never copies anything from outside it; dangling links are
+// copied as links.  A link that never resolves, or that leads to a directory
+// it is inside of, fails the clone with [ErrSymlinkLoop].  It cannot be
+// combined with incremental, merge, lazy, metadata-only, tar, path-mapped,
+// resumable or fit clones, and clone strategies are not used.
+const LabelCloneFollowSymlinks = "containerd.io/snapshot/clone-follow-symlinks"
+
+// LabelCloneProvenance is the snapshot label key used to mark cloned files
+// for forensic tracking.  When set to "true", each regular file and
+// directory copied into the clone gets the [ProvenanceXattr] extended
+// attribute, whose value is the key of the source snapshot.  On a
+// filesystem without user extended attributes the files are left unmarked
+// and a warning is logged.  It cannot be combined with lazy or tar clones,
+// and clone strategies are not used.
+const LabelCloneProvenance = "containerd.io/snapshot/clone-provenance"
+
+// LabelCloneFreeze is the snapshot label key used to request a
+// point-in-time clone of a running container.  When set to "true", the
+// filesystem holding the source's writable layer is frozen with the
+// FIFREEZE ioctl while the layer is copied and thawed as soon as the copy
+// ends, successfully or not, so that no file changes mid-copy.  Every
+// write to that filesystem waits in the meantime, including those of other
+// containers and host processes, and if the plugin crashes mid-copy the
+// filesystem stays frozen until thawed with "fsfreeze -u".  Freezing needs
+// CAP_SYS_ADMIN and a filesystem that supports it, and is only possible when
+// the clone is written to another filesystem, which the bundled overlayfs
+// and native backends never do; otherwise the clone fails with
+// [errdefs.ErrFailedPrecondition] rather than copying the layer live.  It
+// cannot be combined with resumable clones.
+const LabelCloneFreeze = "containerd.io/snapshot/clone-freeze"
+
+// DefaultIgnoreFiles are the files clones leave out when
+// [CloneSnapshotter.IgnoreFiles] is nil: those container runtimes manage for
+// each container's network identity.
+var DefaultIgnoreFiles = []string{"etc/hostname", "etc/hosts", "etc/resolv.conf"}
+
+// cloneControlLabels are the labels that configure a clone.  They are
+// consumed by Prepare and never stored on the new snapshot.
+var cloneControlLabels = []string{
+	LabelCloneSource,
+	LabelCloneSourceNamespace,
+	LabelCloneExclude,
+	LabelCloneIncremental,
+	LabelCloneThin,
+	LabelCloneMerge,
+	LabelCloneLazy,
+	LabelCloneCrossMounts,
+	LabelCloneBestEffort,
+	LabelClonePrefixMatch,
+	LabelCloneOverlayShare,
+	LabelCloneViaTar,
+	LabelCloneParent,
+	LabelClonePathMap,
+	LabelCloneMetadataOnly,
+	LabelCloneResumable,
+	LabelCloneReaddirOrder,
+	LabelCloneFromTar,
+	LabelCloneSourceByLabel,
+	LabelCloneFitBudget,
+	LabelCloneFollowSymlinks,
+	LabelCloneRebase,
+	LabelCloneProvenance,
+	LabelCloneFreeze,
+}
+
 // CloneSnapshotter wraps any snapshots.Snapshotter and adds container-cloning
-// capability. All methods are delegated to the inner snapshotter; the only
-// exception is Prepare, which intercepts requests that carry [LabelCloneSource].
+// capability. All methods are delegated to the inner snapshotter, except
+// Prepare, which intercepts requests that carry [LabelCloneSource], and
+// Remove, which also cleans up clone staging directories.
 type CloneSnapshotter struct {
 	snapshots.Snapshotter
+
+	// SpaceMargin is the number of bytes that must remain free on the
+	// destination filesystem once a clone has been copied. Before clearing
+	// the destination, the clone fails with [ErrInsufficientSpace] if the
+	// source's writable layer plus this margin would not fit.  The layer is
+	// measured before it is copied, so for a live source the margin is also
+	// the allowance for growth during the copy.
+	SpaceMargin uint64
+
+	// SkipInodeCheck disables the check, made alongside the space check,
+	// that the destination filesystem has a free inode for every entry of
+	// the source's writable layer.  A clone failing it returns
+	// [ErrInsufficientInodes].
+	SkipInodeCheck bool
+
+	// VerifyChecksums enables end-to-end verification of copied files.  Each
+	// file's CRC-32C is computed while it is read from the source and
+	// compared against a re-read of the destination; a mismatch fails the
+	// clone with [ErrChecksumMismatch].  This roughly doubles the read I/O of
+	// a clone in exchange for catching silent storage corruption.
+	VerifyChecksums bool
+
+	// VerifyStructure enables a check, after each copy, that the clone's
+	// writable layer has the same entries as the source's, with the same
+	// types, mode bits and regular file sizes.  A difference fails the clone
+	// with an error wrapping [ErrStructureMismatch] that lists each
+	// difference.  File data is compared only by VerifyChecksums.  The
+	// source is walked again after the copy, so a source that changes
+	// during the clone may fail the check.
+	VerifyStructure bool
+
+	// MaxCloneBytes caps the size of a clone's writable layer.  A clone whose
+	// source is larger fails with [ErrCloneTooLarge] and is removed; the limit
+	// is enforced both up front and while copying, since a live source can
+	// grow during the copy.  Zero means unlimited.
+	MaxCloneBytes int64
+
+	// Durable makes clones crash-consistent: every copied file and every
+	// directory of the destination is fsynced before Prepare returns, so a
+	// power loss after Prepare cannot lose the clone's data.  This can slow
+	// clones of many small files considerably.
+	Durable bool
+
+	// Strategies are tried in order to clone a writable layer with a
+	// storage-specific mechanism, such as [ZFSStrategy], before falling
+	// back to copying files.  Strategies are bypassed for incremental, merge
+	// and lazy clones and for clones that exclude paths, which only the file
+	// copy can honour.  They clone IgnoreFiles too.
+	Strategies []CloneStrategy
+
+	// IgnoreFiles are [filepath.Match] patterns, relative to the writable
+	// layer root, of files that are never copied into clones because the
+	// container runtime provides them, usually by bind-mounting them, and
+	// copies would carry the source container's identity.  A matching
+	// directory is skipped with everything below it, and incremental clones
+	// keep the clone's own version.  Nil means [DefaultIgnoreFiles]; an empty
+	// slice copies every file.
+	IgnoreFiles []string
+
+	// CloneTimeout bounds how long a single clone may take, from resolving
+	// the source to the end of the copy.  A clone that runs out of time is
+	// removed and Prepare returns an error wrapping
+	// [context.DeadlineExceeded].  Zero means no timeout.
+	CloneTimeout time.Duration
+
+	// CopyBufferSize is the size of the buffers file data is copied
+	// through.  Larger buffers can speed up copies on high-throughput
+	// storage; buffers are pooled and shared by all clones, which bounds
+	// the memory they use.  Zero means 128 KiB.
+	CopyBufferSize int
+
+	// CopyRateLimit caps the rate, in bytes per second, at which file data
+	// is copied, so that large clones do not starve running containers of
+	// disk I/O.  The limit is shared by all clones in progress.  Data shared
+	// through reflinks or hard links is not limited.  Zero means unlimited.
+	CopyRateLimit int64
+
+	// MaxConcurrentClones caps the number of clones copying at the same
+	// time, so that a burst of clones cannot thrash the disk or hold a copy
+	// buffer each.  Further clones wait for a slot, or for their context to
+	// end; CloneTimeout only starts once they have one.  Prepare calls
+	// without a clone are never held up.  It is read when the first clone
+	// starts; zero means unlimited.
+	MaxConcurrentClones int
+
+	// MaxOpenFiles caps the number of file descriptors clones hold open at
+	// the same time to copy file data, across all clones in progress, so
+	// that many concurrent clones do not fail with EMFILE on hosts with a
+	// low RLIMIT_NOFILE.  Copying a file holds two, for its source and its
+	// copy; further files wait.  Directories being walked and clones
+	// through a tar stream are not counted, so leave headroom below the
+	// limit.  It is read when the first file is copied; zero means
+	// unlimited.
+	MaxOpenFiles int
+
+	// DirectIO copies file data with O_DIRECT, bypassing the page cache, so
+	// that cloning large layers does not evict the cached data of running
+	// containers.  Files on filesystems without O_DIRECT support, and the
+	// unaligned tails of files, are copied through the page cache.  Clones
+	// requesting [LabelCloneViaTar] are refused while it is set.
+	DirectIO bool
+
+	// StagingDir is the directory holding temporary per-clone work, in
+	// subdirectories named by [CloneSnapshotter.StagingPath].  Staging
+	// directories are deleted when their snapshot is removed and by
+	// [CloneSnapshotter.SweepStaging].  Empty disables staging.
+	StagingDir string
+
+	// CopyMethod selects how regular files are copied into clones: data
+	// copies, reflinks or hard links.  Empty means [CopyMethodCopy].
+	// Reflinks are also used regardless of it by [CloneSnapshotter.CloneMany]
+	// for every clone but the first.
+	CopyMethod CopyMethod
+
+	// ReplicateInterval is the time between the syncs of
+	// [CloneSnapshotter.ReplicateSource].  Zero means 30 seconds.
+	ReplicateInterval time.Duration
+
+	// AssumeEmptyDestinations skips checking that the writable directory of
+	// a clone freshly prepared by an overlay backend is empty before copying
+	// into it, and probing that it is writable.  The overlay snapshotter
+	// always creates an empty upperdir, so the checks are only a directory
+	// read and a scratch file, but they add up over many clones.  It must
+	// not be set for backends that may prepare a snapshot with content in
+	// its upperdir.
+	AssumeEmptyDestinations bool
+
+	// NamespaceQuotas caps, by containerd namespace, the number of bytes
+	// clones in a namespace may copy, so that one tenant cannot fill the
+	// disk with clones.  A clone that would take its namespace past its
+	// quota fails with [ErrQuotaExceeded] before anything is copied.  The
+	// bytes copied by each clone are counted in memory for as long as the
+	// snapshotter runs, also once the clone is removed; they start from
+	// zero when the process restarts.  Data shared through hard links is
+	// not counted.  Namespaces without an entry are not limited.
+	NamespaceQuotas map[string]int64
+
+	// ArchiveDir is the directory the archives that snapshots are seeded from
+	// with [LabelCloneFromTar] must lie under.  Empty disables seeding from
+	// archives, since it reads files of the host on behalf of clients.
+	ArchiveDir string
+
+	// Retry is applied to the inner Stat and Mounts calls that resolve the
+	// clone source, so that transient failures such as metadata database
+	// contention do not fail the whole clone.
+	Retry RetryPolicy
+
+	// OnCleanupFailure, if set, is called when a clone fails and its
+	// snapshot cannot be removed either, leaving an orphaned snapshot that
+	// has to be reclaimed by hand.  Such failures are always logged at error
+	// level; the callback lets them also be counted, e.g. in a metric.
+	OnCleanupFailure func(key string, err error)
+
+	// OnProgress, if set, is called while a clone's writable layer is
+	// copied, at most once per ProgressInterval and after a file has been
+	// copied, with the progress of the copy and an estimate of when it
+	// completes.  It is called from the goroutine copying, which it holds
+	// up, so it should return quickly.
+	OnProgress func(CloneProgress)
+
+	// ProgressInterval is the minimum time between calls to OnProgress for
+	// a clone; zero means one second.
+	ProgressInterval time.Duration
+
+	// StatCloneSize makes Stat report the disk usage of clones in the
+	// virtual [LabelCloneSize] label.  It costs a walk of the clone's layer
+	// on every Stat, so it is off by default.
+	StatCloneSize bool
+
+	// RemoveOnCommitFailure makes a failed Commit of an active clone remove
+	// the clone, so that a client which gives up does not leak it.  Only
+	// snapshots cloned since this snapshotter was created are affected.  It
+	// is off by default, since a client may want to retry the Commit, e.g.
+	// under another name.
+	RemoveOnCommitFailure bool
+
+	// PostCloneHook is the path of a command run on every clone once its
+	// content is in place and before Prepare returns its mounts, e.g. to
+	// reset the clone's machine-id or clear its logs.  It is run with the
+	// clone's writable directories as arguments and the clone's key, source
+	// key and namespace in the CLONE_KEY, CLONE_SOURCE_KEY and
+	// CLONE_NAMESPACE environment variables, under the clone's context.  A
+	// hook exiting non-zero fails the clone, which is removed like any
+	// failed clone.  Empty means no hook.
+	PostCloneHook string
+
+	tracer trace.Tracer
+
+	// inflight counts the clones in progress; see Drain.
+	inflight sync.WaitGroup
+
+	// background counts the background tasks Close stops by cancelling
+	// closeCtx, which is created by the first of them; see
+	// startBackground.  closed is set once Close has been called.
+	background  sync.WaitGroup
+	closeMu     sync.Mutex
+	closed      bool
+	closeCtx    context.Context
+	closeCancel context.CancelCauseFunc
+	closeOnce   sync.Once
+	closeErr    error
+
+	// sourceRefs counts, by namespace and key, the clones copying each
+	// source; see acquireSource.
+	refMu      sync.Mutex
+	sourceRefs map[string]int
+
+	// active holds, by namespace and key, the clones in progress; see
+	// AbortClone.
+	activeMu sync.Mutex
+	active   map[string]*activeClone
+
+	// clones holds, by namespace and key, the active clones a failed Commit
+	// removes; see RemoveOnCommitFailure.
+	clonesMu sync.Mutex
+	clones   map[string]struct{}
+
+	// quotaUsed counts, by namespace, the bytes charged against
+	// NamespaceQuotas; see reserveQuota.
+	quotaMu   sync.Mutex
+	quotaUsed map[string]int64
+
+	// bufPool holds copy buffers of CopyBufferSize for reuse.
+	bufPool sync.Pool
+
+	// slots holds a token for each clone running under
+	// MaxConcurrentClones; it is created by the first clone.
+	slotsOnce sync.Once
+	slots     chan struct{}
+
+	// fileSlots holds a token for each file being copied under
+	// MaxOpenFiles; it is created by the first file copied.
+	fileSlotsOnce sync.Once
+	fileSlots     chan struct{}
+
+	// limiter enforces CopyRateLimit; it is created by the first clone that
+	// needs it.
+	limiterOnce sync.Once
+	limiter     *rate.Limiter
+
+	// copyHook is passed to every clone's copier; see copier.hook.
+	copyHook func(ctx context.Context, path string) error
+
+	// clearHook is passed to every clone's copier; see copier.clearHook.
+	clearHook func(dir string)
+
+	// verifyHook is passed to every clone's copier; see copier.verifyHook.
+	verifyHook func(dstDir string)
+
+	// statfs, if set, replaces unix.Statfs in the inode check.
+	statfs func(path string, st *unix.Statfs_t) error
+
+	// encryptionPolicy, if set, replaces getEncryptionPolicy.
+	encryptionPolicy func(dir string) ([]byte, error)
+
+	// clock, if set, replaces time.Now in progress reports.
+	clock func() time.Time
+}
+
+// Option configures a CloneSnapshotter at construction time.
+type Option func(*CloneSnapshotter)
+
+// WithTracerProvider makes the snapshotter record OpenTelemetry spans for
+// clones using tp.  Without it, tracing is a no-op.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *CloneSnapshotter) {
+		s.tracer = tp.Tracer(tracerName)
+	}
 }
 
+// tracerName is the instrumentation scope of the spans recorded for clones.
+const tracerName = "github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+
 // New returns a CloneSnapshotter that wraps inner.
-func New(inner snapshots.Snapshotter) *CloneSnapshotter {
-	return &CloneSnapshotter{Snapshotter: inner}
+func New(inner snapshots.Snapshotter, opts ...Option) *CloneSnapshotter {
+	s := &CloneSnapshotter{
+		Snapshotter: inner,
+		tracer:      noop.NewTracerProvider().Tracer(tracerName),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Prepare creates an active snapshot identified by key.
@@ -49,11 +656,35 @@ func New(inner snapshots.Snapshotter) *CloneSnapshotter {
 // If the [LabelCloneSource] label is present in opts, Prepare clones the
 // source snapshot instead of using parent:
 //  1. The source snapshot's info is retrieved to find its parent.
-//  2. A new snapshot is prepared from that same parent.
+//  2. A new snapshot is prepared from that same parent, or from the one
+//     named by [LabelCloneParent] or [LabelCloneRebase].
 //  3. The source's writable layer is copied into the new snapshot.
 //
-// The [LabelCloneSource] label is stripped before the inner Prepare call to
-// avoid infinite recursion and to keep the stored snapshot metadata clean.
+// A committed or view source, such as an image layer, has no writable layer:
+// the clone is prepared on the committed snapshot holding its content and
+// nothing is copied.
+//
+// Cloning the active snapshot of a running container copies its files one
+// at a time while the container may still be writing them.  Each file is
+// copied as it was when read, but the clone as a whole is not a
+// point-in-time copy, and files changing size mid-copy are not an error.
+// Pause the container, or freeze its filesystem with [LabelCloneFreeze], for
+// a consistent clone.
+//
+// If the [LabelCloneFromTar] label is present instead, Prepare prepares the
+// snapshot on parent and extracts the named archive into it.
+//
+// A missing source, or [LabelCloneParent] or [LabelCloneRebase] snapshot,
+// fails with an error matching [errdefs.ErrNotFound].  A source whose parent
+// has since been removed fails with [errdefs.ErrFailedPrecondition] instead,
+// so that NotFound always means a snapshot named by the request does not
+// exist.
+//
+// The clone control labels ([LabelCloneSource] and the labels that tune the
+// clone) are stripped before the inner Prepare call to avoid infinite
+// recursion and to keep the stored snapshot metadata clean.  All other labels
+// in opts are stored on the clone, alongside the lineage labels; see
+// [WithCloneLabels].
 func (s *CloneSnapshotter) Prepare(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
 	info := snapshots.Info{}
 	for _, opt := range opts {
@@ -62,93 +693,800 @@ func (s *CloneSnapshotter) Prepare(ctx context.Context, key, parent string, opts
 		}
 	}
 
-	sourceKey, ok := info.Labels[LabelCloneSource]
-	if !ok {
+	_, clone := info.Labels[LabelCloneSource]
+	if _, ok := info.Labels[LabelCloneFromTar]; ok {
+		if clone {
+			return nil, fmt.Errorf("%s cannot be combined with %s: %w", LabelCloneFromTar, LabelCloneSource, errdefs.ErrInvalidArgument)
+		}
+		return s.tarPrepare(ctx, key, parent, info.Labels, opts)
+	}
+	if !clone {
 		return s.Snapshotter.Prepare(ctx, key, parent, opts...)
 	}
 
-	return s.clonePrepare(ctx, key, sourceKey, opts)
+	return s.clonePrepare(ctx, key, expandCloneSource(info.Labels, key), opts, "")
+}
+
+// WithCloneLabels returns a snapshot option requesting a clone of source
+// that carries labels.  labels may tune the clone with the clone control
+// labels; any other label, such as a team or purpose tag, is stored on the
+// new snapshot.
+func WithCloneLabels(source string, labels map[string]string) snapshots.Opt {
+	merged := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[LabelCloneSource] = source
+	return snapshots.WithLabels(merged)
+}
+
+// expandCloneSource returns labels with the placeholder for the new
+// snapshot's key in [LabelCloneSource] replaced by key.  labels itself is
+// left untouched, as it may belong to the caller.
+func expandCloneSource(labels map[string]string, key string) map[string]string {
+	source := labels[LabelCloneSource]
+	if !strings.Contains(source, sourceKeyVar) {
+		return labels
+	}
+	expanded := make(map[string]string, len(labels))
+	for k, v := range labels {
+		expanded[k] = v
+	}
+	expanded[LabelCloneSource] = strings.ReplaceAll(source, sourceKeyVar, key)
+	return expanded
 }
 
 // clonePrepare implements the clone logic: it prepares a new snapshot with
 // the same parent as the source and then copies the source's writable layer.
-func (s *CloneSnapshotter) clonePrepare(ctx context.Context, key, sourceKey string, opts []snapshots.Opt) ([]mount.Mount, error) {
-	// Retrieve source info to learn its parent snapshot chain.
-	sourceInfo, err := s.Snapshotter.Stat(ctx, sourceKey)
+// labels are the labels requested for the new snapshot, including the clone
+// control labels.  If from is not empty it names the writable directory of an
+// existing clone of the same source, which is copied in place of the source's
+// writable layer.
+//
+// The clone is traced as a "clone_prepare" span with "prepare_snapshot" and
+// "copy_writable_layer" child spans.
+func (s *CloneSnapshotter) clonePrepare(ctx context.Context, key string, labels map[string]string, opts []snapshots.Opt, from string) (_ []mount.Mount, retErr error) {
+	sourceKey := labels[LabelCloneSource]
+	start := time.Now()
+
+	s.inflight.Add(1)
+	defer s.inflight.Done()
+
+	ctx, span := s.tracer.Start(ctx, "clone_prepare", trace.WithAttributes(
+		attribute.String("clone.source", sourceKey),
+		attribute.String("clone.key", key),
+	))
+	defer func() { endSpan(span, retErr) }()
+
+	ctx, unregister := s.registerClone(ctx, key)
+	defer unregister()
+	defer func() {
+		if errors.Is(retErr, context.Canceled) && errors.Is(context.Cause(ctx), errCloneAborted) {
+			retErr = fmt.Errorf("clone into %q aborted: %w", key, retErr)
+		}
+	}()
+
+	release, err := s.acquireSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if s.CloneTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.CloneTimeout)
+		defer cancel()
+		defer func() {
+			if errors.Is(retErr, context.DeadlineExceeded) {
+				retErr = fmt.Errorf("clone of %q timed out after %v: %w", sourceKey, s.CloneTimeout, retErr)
+			}
+		}()
+	}
+
+	c, err := s.newCopier(labels)
+	if err != nil {
+		return nil, err
+	}
+	c.from = from
+	if c.resumable {
+		c.resumeDir = s.StagingPath(ctx, key)
+	}
+	ns, _ := namespaces.Namespace(ctx)
+	if quota, ok := s.NamespaceQuotas[ns]; ok {
+		c.reserve = func(n int64) error { return s.reserveQuota(ns, quota, n) }
+		defer func() { s.settleQuota(ns, c.reserved, c.bytes, retErr == nil) }()
+	}
+	if from != "" {
+		c.reflink = true
+	}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("clone.bytes", c.bytes),
+			attribute.Int64("clone.files", c.files),
+		)
+	}()
+
+	prefixMatch, err := boolLabel(labels, LabelClonePrefixMatch)
+	if err != nil {
+		return nil, err
+	}
+	share, err := boolLabel(labels, LabelCloneOverlayShare)
+	if err != nil {
+		return nil, err
+	}
+	if share && (c.incremental || c.merge) {
+		return nil, fmt.Errorf("overlay-share clones cannot be incremental or merged: %w", errdefs.ErrInvalidArgument)
+	}
+
+	// The source may live in another namespace; only the source lookups use it.
+	sourceCtx := ctx
+	lineage := map[string]string{}
+	if ns, ok := labels[LabelCloneSourceNamespace]; ok {
+		if err := identifiers.Validate(ns); err != nil {
+			return nil, fmt.Errorf("invalid clone source namespace: %w", err)
+		}
+		sourceCtx = namespaces.WithNamespace(ctx, ns)
+		lineage[LabelClonedFromNamespace] = ns
+	}
+
+	if label, ok := labels[LabelCloneSourceByLabel]; ok {
+		if prefixMatch {
+			return nil, fmt.Errorf("%s cannot be combined with %s: %w", LabelCloneSourceByLabel, LabelClonePrefixMatch, errdefs.ErrInvalidArgument)
+		}
+		resolved, err := s.resolveSourceByLabel(sourceCtx, label, sourceKey)
+		if err != nil {
+			return nil, fmt.Errorf("clone source: %w", err)
+		}
+		sourceKey = resolved
+		span.SetAttributes(attribute.String("clone.source", sourceKey))
+	}
+
+	// Retrieve source info to learn its parent snapshot chain.  The source
+	// is protected from removal first, so that it cannot be removed between
+	// the lookup and the copy.
+	defer s.acquireSource(sourceCtx, sourceKey)()
+	var sourceInfo snapshots.Info
+	err = s.Retry.do(ctx, func() (err error) {
+		sourceInfo, err = s.Snapshotter.Stat(sourceCtx, sourceKey)
+		return err
+	})
+	if errdefs.IsNotFound(err) && prefixMatch {
+		var resolved string
+		if resolved, err = s.resolveSourcePrefix(sourceCtx, sourceKey); err == nil {
+			sourceKey = resolved
+			span.SetAttributes(attribute.String("clone.source", sourceKey))
+			defer s.acquireSource(sourceCtx, sourceKey)()
+			err = s.Retry.do(ctx, func() (err error) {
+				sourceInfo, err = s.Snapshotter.Stat(sourceCtx, sourceKey)
+				return err
+			})
+		}
+	}
+	if errdefs.IsNotFound(err) {
+		return nil, fmt.Errorf("clone source snapshot %q does not exist: %w", sourceKey, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("stat source snapshot %q: %w", sourceKey, err)
 	}
+	lineage[LabelClonedFrom] = sourceKey
+	c.source = sourceKey
+
+	// Committed and view snapshots have no writable layer of their own,
+	// e.g. an image layer or a read-only view of one: their content is
+	// entirely that of a committed snapshot, which the clone is prepared on
+	// with nothing to copy.
+	parent := sourceInfo.Parent
+	readOnly := sourceInfo.Kind != snapshots.KindActive
+	if sourceInfo.Kind == snapshots.KindCommitted {
+		parent = sourceKey
+	}
+	if readOnly && (share || c.incremental || c.merge) {
+		return nil, fmt.Errorf("clone source snapshot %q: %v snapshots cannot be cloned incrementally, merged or shared: %w",
+			sourceKey, sourceInfo.Kind, errdefs.ErrInvalidArgument)
+	}
+	newParent, rebase := labels[LabelCloneParent]
+	rebaseLabel := LabelCloneParent
+	if v, ok := labels[LabelCloneRebase]; ok {
+		if rebase {
+			return nil, fmt.Errorf("%s cannot be combined with %s: %w",
+				LabelCloneRebase, LabelCloneParent, errdefs.ErrInvalidArgument)
+		}
+		newParent, rebase, rebaseLabel = v, true, LabelCloneRebase
+	}
+	if rebase {
+		if readOnly || c.thin {
+			return nil, fmt.Errorf("%s cannot be used with %v sources or thin clones: %w",
+				rebaseLabel, sourceInfo.Kind, errdefs.ErrInvalidArgument)
+		}
+		parent = newParent
+	}
+
+	// The clone is prepared on parent in the destination namespace; make
+	// sure it is there so that a missing parent is not reported as a
+	// failure to prepare the clone.  While the source exists the inner
+	// snapshotter refuses to remove its parent, so it cannot disappear
+	// between this check and Prepare.
+	if parent != "" {
+		var parentInfo snapshots.Info
+		err = s.Retry.do(ctx, func() (err error) {
+			parentInfo, err = s.Snapshotter.Stat(ctx, parent)
+			return err
+		})
+		if errdefs.IsNotFound(err) && rebase {
+			return nil, fmt.Errorf("clone parent %q: %w", parent, err)
+		}
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("clone source snapshot %q: source parent %q no longer exists: %w",
+				sourceKey, parent, errdefs.ErrFailedPrecondition)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stat source parent snapshot %q: %w", parent, err)
+		}
+		if rebaseLabel == LabelCloneRebase {
+			if err := checkRebaseParent(sourceKey, parentInfo); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	// Get source mounts to locate the writable directory we need to copy.
-	sourceMounts, err := s.Snapshotter.Mounts(ctx, sourceKey)
-	if err != nil {
-		return nil, fmt.Errorf("get mounts for source snapshot %q: %w", sourceKey, err)
+	var sourceMounts []mount.Mount
+	if !readOnly {
+		err = s.Retry.do(ctx, func() (err error) {
+			sourceMounts, err = s.Snapshotter.Mounts(sourceCtx, sourceKey)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get mounts for source snapshot %q: %w", sourceKey, err)
+		}
+
+		// Refuse backends whose mounts we cannot copy before creating
+		// anything.
+		if err := checkCloneSupported(sourceMounts); err != nil {
+			return nil, fmt.Errorf("clone source snapshot %q: %w", sourceKey, err)
+		}
+		// A bind-mounted snapshot with a parent holds its parent's files
+		// too, which would be copied over the new parent's.
+		if rebase && sourceInfo.Parent != "" && sourceMounts[0].Type == "bind" {
+			return nil, fmt.Errorf("clone source snapshot %q: %s needs an overlay source or one without a parent: %w",
+				sourceKey, rebaseLabel, errdefs.ErrNotImplemented)
+		}
+	}
+	var sharedDir string
+	if share {
+		if sharedDir, err = sharedUpperdir(sourceMounts); err != nil {
+			return nil, fmt.Errorf("clone source snapshot %q: %w", sourceKey, err)
+		}
+		// A source that shares another snapshot's upperdir passes it on.
+		if dirs, ok := sourceInfo.Labels[LabelClonedFromUpperdir]; ok {
+			sharedDir += ":" + dirs
+		}
+		lineage[LabelClonedFromUpperdir] = sharedDir
+	} else if _, ok := sourceInfo.Labels[LabelClonedFromUpperdir]; ok {
+		return nil, fmt.Errorf("clone source snapshot %q: its upperdir only holds part of its files, use %s: %w",
+			sourceKey, LabelCloneOverlayShare, errdefs.ErrFailedPrecondition)
 	}
 
-	// Prepare the new snapshot with the same parent as the source.
-	// The clone label is stripped to prevent infinite recursion and to avoid
-	// storing it on the new snapshot's metadata.
-	innerOpts := withoutLabel(opts, LabelCloneSource)
-	mounts, err := s.Snapshotter.Prepare(ctx, key, sourceInfo.Parent, innerOpts...)
+	// Prepare the new snapshot with the same parent as the source, on a
+	// read-only source's content, or on the requested parent.
+	// The clone labels are stripped to prevent infinite recursion and to
+	// avoid storing them on the new snapshot's metadata; the lineage labels
+	// are recorded in their place.
+	innerOpts := append(withoutLabels(opts, cloneControlLabels...),
+		snapshots.WithLabels(lineage))
+	prepareCtx, prepareSpan := s.tracer.Start(ctx, "prepare_snapshot")
+	mounts, reused, err := s.prepareDestination(prepareCtx, c, key, parent, innerOpts)
+	endSpan(prepareSpan, err)
 	if err != nil {
-		return nil, fmt.Errorf("prepare snapshot %q: %w", key, err)
+		return nil, err
 	}
 
-	// Copy the writable layer from source to the new snapshot.
-	if err := copyWritableLayer(sourceMounts, mounts); err != nil {
-		if removeErr := s.Snapshotter.Remove(ctx, key); removeErr != nil {
+	switch {
+	case readOnly:
+		// The clone's parent already holds everything.
+	case sharedDir != "":
+		// Stack the source's upperdir on the clone instead of copying it.
+		mounts, err = stackUpperdir(mounts, sharedDir)
+	default:
+		// Copy the writable layer from source to the new snapshot.
+		// A bind-mounted clone starts out with the new parent's files,
+		// which a rebased clone must keep.
+		clearFirst := !c.merge && !(rebase && len(mounts) == 1 && mounts[0].Type == "bind")
+		c.freshDest = s.AssumeEmptyDestinations && !reused && len(mounts) > 0 && mounts[0].Type == "overlay"
+		if s.OnProgress != nil {
+			s.trackProgress(c, key, sourceKey)
+		}
+		copyCtx, copySpan := s.tracer.Start(ctx, "copy_writable_layer")
+		err = c.copyWritableLayer(copyCtx, sourceMounts, mounts, clearFirst)
+		endSpan(copySpan, err)
+	}
+	if err == nil && s.PostCloneHook != "" {
+		hookCtx, hookSpan := s.tracer.Start(ctx, "post_clone_hook")
+		err = s.runPostCloneHook(hookCtx, key, sourceKey, mounts)
+		endSpan(hookSpan, err)
+	}
+	if err == nil {
+		err = s.recordStats(ctx, key, c, time.Since(start))
+	}
+	if err != nil && reused {
+		// Never remove a snapshot that existed before this call.
+		return nil, fmt.Errorf("copy writable layer from %q to %q: %w", sourceKey, key, err)
+	}
+	if err != nil && c.resumable {
+		log.G(ctx).WithError(err).WithField("key", key).Warn("resumable clone failed, keeping partial clone")
+		return nil, fmt.Errorf("copy writable layer from %q to %q: %w", sourceKey, key, err)
+	}
+	if err != nil {
+		// The clone's context may be the reason for the failure; clean up
+		// regardless of it.
+		if removeErr := s.Remove(context.WithoutCancel(ctx), key); removeErr != nil {
+			s.cleanupFailed(ctx, key, removeErr)
 			return nil, fmt.Errorf("copy writable layer: %w (cleanup also failed: %v)", err, removeErr)
 		}
 		return nil, fmt.Errorf("copy writable layer from %q to %q: %w", sourceKey, key, err)
 	}
 
+	s.trackClone(ctx, key)
 	return mounts, nil
 }
 
-// withoutLabel returns a single opts function that applies all of the original
-// opts and then deletes the named label, preventing it from being stored on
-// the new snapshot.
-func withoutLabel(opts []snapshots.Opt, label string) []snapshots.Opt {
+// cleanupFailed reports that the snapshot key of a failed clone could not be
+// removed.
+func (s *CloneSnapshotter) cleanupFailed(ctx context.Context, key string, err error) {
+	log.G(ctx).WithError(err).WithField("key", key).Error("clone cleanup failed: snapshot of failed clone left behind")
+	if s.OnCleanupFailure != nil {
+		s.OnCleanupFailure(key, err)
+	}
+}
+
+// recordStats stores the clone statistics labels of the clone key copied by
+// c in duration d.
+func (s *CloneSnapshotter) recordStats(ctx context.Context, key string, c *copier, d time.Duration) error {
+	info := snapshots.Info{
+		Name: key,
+		Labels: map[string]string{
+			LabelCloneBytes:    strconv.FormatInt(c.bytes, 10),
+			LabelCloneFiles:    strconv.FormatInt(c.files, 10),
+			LabelCloneDuration: d.String(),
+		},
+	}
+	if c.bestEffort || c.fitBudget > 0 {
+		info.Labels[LabelCloneSkipped] = strconv.Itoa(len(c.skipped))
+	}
+	fieldpaths := make([]string, 0, len(info.Labels))
+	for label := range info.Labels {
+		fieldpaths = append(fieldpaths, "labels."+label)
+	}
+	if _, err := s.Snapshotter.Update(ctx, info, fieldpaths...); err != nil {
+		return fmt.Errorf("record clone statistics: %w", err)
+	}
+	return nil
+}
+
+// prepareDestination prepares the clone's snapshot on parent.  For an
+// incremental or merge clone whose key already names an active snapshot on
+// the same parent, or a resumable clone that a previous attempt left behind,
+// that snapshot's mounts are returned instead and reused is true.
+func (s *CloneSnapshotter) prepareDestination(ctx context.Context, c *copier, key, parent string, opts []snapshots.Opt) (mounts []mount.Mount, reused bool, err error) {
+	if c.incremental || c.merge || c.resumable && hasResumeManifest(c.resumeDir) {
+		info, err := s.Snapshotter.Stat(ctx, key)
+		switch {
+		case errdefs.IsNotFound(err):
+			// Nothing to reuse; fall through to a regular clone.
+		case err != nil:
+			return nil, false, fmt.Errorf("stat snapshot %q: %w", key, err)
+		case info.Kind != snapshots.KindActive:
+			return nil, false, fmt.Errorf("clone into existing snapshot %q: snapshot is not active: %w", key, errdefs.ErrFailedPrecondition)
+		case info.Parent != parent:
+			return nil, false, fmt.Errorf("clone into existing snapshot %q: parent %q differs from source parent %q: %w",
+				key, info.Parent, parent, errdefs.ErrFailedPrecondition)
+		default:
+			mounts, err := s.Snapshotter.Mounts(ctx, key)
+			if err != nil {
+				return nil, false, fmt.Errorf("get mounts for snapshot %q: %w", key, err)
+			}
+			return mounts, true, nil
+		}
+	}
+
+	mounts, err = s.Snapshotter.Prepare(ctx, key, parent, opts...)
+	if err != nil {
+		return nil, false, fmt.Errorf("prepare snapshot %q: %w", key, err)
+	}
+	return mounts, false, nil
+}
+
+// boolLabel parses the boolean clone control label key.  A missing label is
+// false.
+func boolLabel(labels map[string]string, key string) (bool, error) {
+	v, ok := labels[key]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s label %q: %w", key, v, err)
+	}
+	return b, nil
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withoutLabels returns a single opts function that applies all of the
+// original opts and then deletes the named labels, preventing them from being
+// stored on the new snapshot.
+func withoutLabels(opts []snapshots.Opt, labels ...string) []snapshots.Opt {
 	return []snapshots.Opt{func(info *snapshots.Info) error {
 		for _, opt := range opts {
 			if err := opt(info); err != nil {
 				return err
 			}
 		}
-		delete(info.Labels, label)
+		for _, label := range labels {
+			delete(info.Labels, label)
+		}
 		return nil
 	}}
 }
 
+// newCopier returns a copier configured from the snapshotter's settings and
+// the clone control labels of a single Prepare call.
+func (s *CloneSnapshotter) newCopier(labels map[string]string) (*copier, error) {
+	c := &copier{
+		spaceMargin:     s.SpaceMargin,
+		maxBytes:        s.MaxCloneBytes,
+		verifyChecksums: s.VerifyChecksums,
+		verifyStructure: s.VerifyStructure,
+		verifyHook:      s.verifyHook,
+		durable:         s.Durable,
+		strategies:      s.Strategies,
+		hook:            s.copyHook,
+		clearHook:       s.clearHook,
+		bufSize:         s.CopyBufferSize,
+		bufPool:         &s.bufPool,
+		limiter:         s.copyLimiter(),
+		skipInodeCheck:  s.SkipInodeCheck,
+		directIO:        s.DirectIO,
+		statfs:          s.statfs,
+		encryption:      s.encryptionPolicy,
+	}
+	incremental, err := boolLabel(labels, LabelCloneIncremental)
+	if err != nil {
+		return nil, err
+	}
+	c.incremental = incremental
+	if c.thin, err = boolLabel(labels, LabelCloneThin); err != nil {
+		return nil, err
+	}
+	if c.merge, err = boolLabel(labels, LabelCloneMerge); err != nil {
+		return nil, err
+	}
+	if c.lazy, err = boolLabel(labels, LabelCloneLazy); err != nil {
+		return nil, err
+	}
+	switch s.CopyMethod {
+	case "", CopyMethodCopy:
+	case CopyMethodReflink:
+		c.reflink = true
+	case CopyMethodHardlink:
+		c.lazy = true
+	default:
+		return nil, fmt.Errorf("unknown copy method %q: %w", s.CopyMethod, errdefs.ErrInvalidArgument)
+	}
+	if c.viaTar, err = boolLabel(labels, LabelCloneViaTar); err != nil {
+		return nil, err
+	}
+	if c.viaTar && (c.incremental || c.merge || c.lazy) {
+		return nil, fmt.Errorf("%s cannot be combined with incremental, merge or lazy clones: %w",
+			LabelCloneViaTar, errdefs.ErrInvalidArgument)
+	}
+	if c.viaTar && c.directIO {
+		// The data arrives through a pipe, which O_DIRECT cannot read.
+		return nil, fmt.Errorf("%s cannot be combined with DirectIO: %w",
+			LabelCloneViaTar, errdefs.ErrInvalidArgument)
+	}
+	if c.crossMounts, err = boolLabel(labels, LabelCloneCrossMounts); err != nil {
+		return nil, err
+	}
+	if c.bestEffort, err = boolLabel(labels, LabelCloneBestEffort); err != nil {
+		return nil, err
+	}
+	if c.readdirOrder, err = boolLabel(labels, LabelCloneReaddirOrder); err != nil {
+		return nil, err
+	}
+	if c.metadataOnly, err = boolLabel(labels, LabelCloneMetadataOnly); err != nil {
+		return nil, err
+	}
+	if c.metadataOnly && (c.lazy || c.viaTar) {
+		return nil, fmt.Errorf("%s cannot be combined with lazy or tar clones: %w",
+			LabelCloneMetadataOnly, errdefs.ErrInvalidArgument)
+	}
+	if v, ok := labels[LabelClonePathMap]; ok {
+		pathMap, err := parsePathMap(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s label: %w", LabelClonePathMap, err)
+		}
+		if c.incremental || c.viaTar {
+			return nil, fmt.Errorf("%s cannot be combined with incremental or tar clones: %w",
+				LabelClonePathMap, errdefs.ErrInvalidArgument)
+		}
+		c.pathMap = pathMap
+	}
+	c.ignore = s.IgnoreFiles
+	if c.ignore == nil {
+		c.ignore = DefaultIgnoreFiles
+	}
+	for _, p := range c.ignore {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %v: %w", p, err, errdefs.ErrInvalidArgument)
+		}
+	}
+	if v, ok := labels[LabelCloneExclude]; ok {
+		exclude, err := parseExcludePatterns(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s label: %w", LabelCloneExclude, err)
+		}
+		c.exclude = exclude
+	}
+	if c.resumable, err = boolLabel(labels, LabelCloneResumable); err != nil {
+		return nil, err
+	}
+	if c.resumable && (c.incremental || c.merge || c.lazy || c.viaTar || len(c.pathMap) > 0) {
+		return nil, fmt.Errorf("%s cannot be combined with incremental, merge, lazy, tar or path-mapped clones: %w",
+			LabelCloneResumable, errdefs.ErrInvalidArgument)
+	}
+	if v, ok := labels[LabelCloneFitBudget]; ok {
+		if c.fitBudget, err = parseFitBudget(v); err != nil {
+			return nil, err
+		}
+		if c.incremental || c.merge || c.lazy || c.metadataOnly || c.viaTar || c.resumable {
+			return nil, fmt.Errorf("%s cannot be combined with incremental, merge, lazy, metadata-only, tar or resumable clones: %w",
+				LabelCloneFitBudget, errdefs.ErrInvalidArgument)
+		}
+	}
+	if c.followSymlinks, err = boolLabel(labels, LabelCloneFollowSymlinks); err != nil {
+		return nil, err
+	}
+	if c.followSymlinks && (c.incremental || c.merge || c.lazy || c.metadataOnly || c.viaTar || len(c.pathMap) > 0 || c.resumable || c.fitBudget > 0) {
+		return nil, fmt.Errorf("%s cannot be combined with incremental, merge, lazy, metadata-only, tar, path-mapped, resumable or fit clones: %w",
+			LabelCloneFollowSymlinks, errdefs.ErrInvalidArgument)
+	}
+	if c.provenance, err = boolLabel(labels, LabelCloneProvenance); err != nil {
+		return nil, err
+	}
+	if c.provenance && (c.lazy || c.viaTar) {
+		return nil, fmt.Errorf("%s cannot be combined with lazy or tar clones: %w",
+			LabelCloneProvenance, errdefs.ErrInvalidArgument)
+	}
+	if s.MaxOpenFiles > 0 {
+		c.acquireFile = s.acquireFileSlot
+	}
+	if c.freeze, err = boolLabel(labels, LabelCloneFreeze); err != nil {
+		return nil, err
+	}
+	if c.freeze && c.resumable {
+		// The manifest is written next to the source.
+		return nil, fmt.Errorf("%s cannot be combined with resumable clones: %w",
+			LabelCloneFreeze, errdefs.ErrInvalidArgument)
+	}
+	if c.resumable && s.StagingDir == "" {
+		return nil, fmt.Errorf("%s needs a staging directory: %w", LabelCloneResumable, errdefs.ErrFailedPrecondition)
+	}
+	return c, nil
+}
+
 // copyWritableLayer copies the contents of the source snapshot's writable
 // directory into the destination snapshot's writable directory.
 //
 // For overlay mounts the writable directory is the upperdir= option value.
 // For bind mounts (used by the native snapshotter) it is the mount source.
-// The destination directory is cleared first so that files deleted in the
-// source are not preserved in the clone.
-func copyWritableLayer(srcMounts, dstMounts []mount.Mount) error {
-	srcDir, err := getWritableDir(srcMounts)
-	if err != nil {
-		return fmt.Errorf("source: %w", err)
+// Snapshots whose mounts have several writable directories are copied
+// directory by directory, pairing them in mount order; both snapshots must
+// have the same number.
+// If clearFirst is set the destination directory is cleared first so that
+// files deleted in the source are not preserved in the clone; otherwise the
+// source is merged over the destination's content. Any configured clone
+// strategies get the first chance to clone the directory. Free space is
+// checked before the destination is touched so that a full filesystem does
+// not leave a half-copied snapshot behind; lazy clones, which share file
+// data with the source, skip that check.  The sizes it uses are estimates:
+// the files of a live source may grow or shrink before they are read, which
+// [CloneSnapshotter.SpaceMargin] must allow for.  Files are copied as they
+// are when read, and the byte count and MaxCloneBytes apply to what was
+// actually copied.
+//
+// A destination on a read-only filesystem is refused up front with
+// [ErrReadOnlyDestination].
+//
+// Source and destination must share their fscrypt encryption policy, if any;
+// see [ErrEncryptionMismatch].
+//
+// A thin clone into a bind mount diffs the source against the destination,
+// which still holds the parent's content, instead of clearing it.
+func (c *copier) copyWritableLayer(ctx context.Context, srcMounts, dstMounts []mount.Mount, clearFirst bool) error {
+	var srcDirs []string
+	if c.from != "" {
+		srcDirs = []string{c.from}
+	} else {
+		var err error
+		if srcDirs, err = getWritableDirs(srcMounts); err != nil {
+			debugMountOptions(ctx, "source", srcMounts)
+			return fmt.Errorf("source: %w", err)
+		}
 	}
-	dstDir, err := getWritableDir(dstMounts)
+	dstDirs, err := getWritableDirs(dstMounts)
 	if err != nil {
+		debugMountOptions(ctx, "destination", dstMounts)
 		return fmt.Errorf("destination: %w", err)
 	}
+	if len(srcDirs) != len(dstDirs) {
+		return fmt.Errorf("source has %d writable directories but destination has %d: %w",
+			len(srcDirs), len(dstDirs), ErrUnsupportedBackend)
+	}
+	// A destination the backend has just created is on a filesystem that
+	// was writable a moment ago; probing it costs more than the directory
+	// read freshDest saves.
+	if !c.freshDest {
+		for _, dir := range dstDirs {
+			if err := checkWritable(dir); err != nil {
+				return err
+			}
+		}
+	}
+	if c.thin && !c.viaTar && len(c.pathMap) == 0 && len(dstMounts) == 1 && dstMounts[0].Type == "bind" {
+		// Reconciling against the freshly prepared destination copies
+		// only what differs from the parent.
+		c.incremental = true
+	}
+	if c.resumable {
+		if c.resume, err = openResumeManifest(c.resumeDir, c.durable); err != nil {
+			return fmt.Errorf("open resume manifest: %w", err)
+		}
+		defer c.resume.f.Close()
+	}
+	for i := range srcDirs {
+		if err := c.copyLayerDir(ctx, srcDirs[i], dstDirs[i], clearFirst); err != nil {
+			return err
+		}
+		if err := c.verifyLayerDir(srcDirs[i], dstDirs[i]); err != nil {
+			return err
+		}
+	}
+	if c.resume != nil {
+		return c.resume.remove()
+	}
+	return nil
+}
+
+// copyLayerDir copies the writable directory srcDir into dstDir for
+// copyWritableLayer.
+func (c *copier) copyLayerDir(ctx context.Context, srcDir, dstDir string, clearFirst bool) (retErr error) {
+	if err := checkEncryption(srcDir, dstDir, c.encryption); err != nil {
+		return err
+	}
+
+	if c.freeze {
+		thaw, err := c.freezeSource(ctx, srcDir, dstDir)
+		if err != nil {
+			return err
+		}
+		// Thawed whatever happens, and as soon as the copy is done.
+		defer func() {
+			if err := thaw(); err != nil {
+				if retErr == nil {
+					retErr = err
+				} else {
+					retErr = fmt.Errorf("%w (%v)", retErr, err)
+				}
+			}
+		}()
+	}
+
+	if clearFirst && len(c.exclude) == 0 && len(c.pathMap) == 0 && !c.incremental && !c.lazy && !c.viaTar && !c.metadataOnly && !c.resumable && !c.readdirOrder && c.fitBudget == 0 && !c.followSymlinks && !c.provenance {
+		if done, err := runStrategies(ctx, c.strategies, srcDir, dstDir); done || err != nil {
+			return err
+		}
+	}
+
+	size, entries, err := dirUsage(srcDir)
+	if err != nil {
+		return fmt.Errorf("measure source directory: %w", err)
+	}
+	if c.fitBudget > 0 {
+		// Only the files kept take space.
+		if size, err = c.planFit(srcDir, size); err != nil {
+			return fmt.Errorf("plan fit clone: %w", err)
+		}
+	}
+	if c.maxBytes > 0 && size > uint64(c.maxBytes) && !c.metadataOnly {
+		return fmt.Errorf("%w: source is %d bytes, limit is %d", ErrCloneTooLarge, size, c.maxBytes)
+	}
+	if !c.lazy {
+		if !c.metadataOnly {
+			if err := checkSpace(size, dstDir, c.spaceMargin); err != nil {
+				return err
+			}
+			if c.reserve != nil {
+				if err := c.reserve(int64(size)); err != nil {
+					return err
+				}
+				c.reserved += int64(size)
+			}
+		}
+		if !c.metadataOnly {
+			c.total += int64(size)
+		}
+		if !c.skipInodeCheck {
+			if err := checkInodes(entries, dstDir, c.statfs); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Clear destination first so files deleted in the source are not kept.
-	if err := clearDir(dstDir); err != nil {
-		return fmt.Errorf("clear destination directory: %w", err)
+	// An incremental or resumed clone prunes those files after copying
+	// instead.
+	if clearFirst && !c.incremental && !(c.resume != nil && c.resume.resumed) {
+		if err := c.clearDest(dstDir); err != nil {
+			return fmt.Errorf("clear destination directory: %w", err)
+		}
+	}
+
+	if c.viaTar {
+		return c.copyTar(ctx, srcDir, dstDir)
 	}
+	c.root = srcDir
+	return c.copyDir(ctx, srcDir, dstDir)
+}
 
-	return copyDir(srcDir, dstDir)
+// clearDest clears the destination directory dir for copyLayerDir.  A
+// freshly prepared destination is usually empty, which is checked by
+// reading a single entry before clearing; a destination known to be fresh
+// is not looked at.
+func (c *copier) clearDest(dir string) error {
+	if c.freshDest {
+		return nil
+	}
+	if empty, err := dirEmpty(dir); err != nil || empty {
+		return err
+	}
+	if c.clearHook != nil {
+		c.clearHook(dir)
+	}
+	return c.clearDir(dir)
+}
+
+// WritableDir returns the directory holding the writable layer of the
+// snapshot identified by key, resolved from its mounts exactly as a clone
+// would resolve it: the overlay upperdir or the bind mount source.  It is
+// intended for diagnostics and tooling.
+func (s *CloneSnapshotter) WritableDir(ctx context.Context, key string) (string, error) {
+	mounts, err := s.Snapshotter.Mounts(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("get mounts for snapshot %q: %w", key, err)
+	}
+	dir, err := getWritableDir(mounts)
+	if err != nil {
+		debugMountOptions(ctx, key, mounts)
+		return "", fmt.Errorf("snapshot %q: %w", key, err)
+	}
+	return dir, nil
 }
 
 // getWritableDir extracts the writable directory path from a set of mounts.
 //   - overlay: returns the upperdir= option value
 //   - bind:    returns the mount source path
 func getWritableDir(mounts []mount.Mount) (string, error) {
+	if len(mounts) == 0 {
+		return "", ErrNoMounts
+	}
 	for _, m := range mounts {
 		switch m.Type {
 		case "overlay":
@@ -164,101 +1502,85 @@ func getWritableDir(mounts []mount.Mount) (string, error) {
 	return "", fmt.Errorf("no writable directory found in mounts (types: %s)", joinMountTypes(mounts))
 }
 
-// joinMountTypes returns a comma-separated list of mount types for diagnostics.
-func joinMountTypes(mounts []mount.Mount) string {
-	types := make([]string, len(mounts))
-	for i, m := range mounts {
-		types[i] = m.Type
+// getWritableDirs returns the writable directory of every mount in mounts
+// that has one, in mount order: the upperdir of overlay mounts and the
+// source of bind mounts that are not read-only.
+func getWritableDirs(mounts []mount.Mount) ([]string, error) {
+	if len(mounts) == 0 {
+		return nil, ErrNoMounts
 	}
-	return strings.Join(types, ", ")
-}
-
-// clearDir removes all entries inside dir without removing dir itself.
-func clearDir(dir string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+	var dirs []string
+	for _, m := range mounts {
+		switch m.Type {
+		case "overlay":
+			for _, opt := range m.Options {
+				if val, ok := strings.CutPrefix(opt, "upperdir="); ok {
+					dirs = append(dirs, val)
+					break
+				}
+			}
+		case "bind":
+			if !slices.Contains(m.Options, "ro") {
+				dirs = append(dirs, m.Source)
+			}
 		}
-		return err
 	}
-	for _, e := range entries {
-		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
-			return err
-		}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no writable directory found in mounts (types: %s)", joinMountTypes(mounts))
 	}
-	return nil
+	return dirs, nil
 }
 
-// copyDir recursively copies the contents of srcDir into dstDir, preserving
-// permissions. Symlinks are recreated as symlinks; directories and regular
-// files are copied with their mode bits.
-func copyDir(srcDir, dstDir string) error {
-	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		rel, err := filepath.Rel(srcDir, path)
-		if err != nil {
-			return err
-		}
-
-		// Skip the root entry; dstDir already exists.
-		if rel == "." {
-			return nil
-		}
-
-		dst := filepath.Join(dstDir, rel)
+// ErrUnsupportedBackend is returned (wrapped) by Prepare when the inner
+// snapshotter produces mounts whose writable directory cannot be located,
+// e.g. block-device based backends such as devmapper.
+var ErrUnsupportedBackend = errors.New("snapshotter backend does not support cloning")
 
-		switch {
-		case d.Type()&fs.ModeSymlink != 0:
-			return copySymlink(path, dst)
+// ErrNoMounts is returned (wrapped) when a snapshot that is cloned, or whose
+// writable directory is looked up, has no mounts at all.
+var ErrNoMounts = errors.New("snapshot has no mounts")
 
-		case d.IsDir():
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-			return os.MkdirAll(dst, info.Mode().Perm())
+// supportedMountTypes lists the mount types whose writable directory
+// getWritableDir knows how to locate.
+var supportedMountTypes = []string{"overlay", "bind"}
 
-		default:
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-			return copyFile(path, dst, info.Mode().Perm())
+// checkCloneSupported reports whether mounts come from a backend the clone
+// logic understands.  It is used on the source mounts so that an unsupported
+// backend fails with a descriptive error before the destination is created.
+// A snapshot without mounts fails with [ErrNoMounts] rather than being
+// blamed on the backend.
+func checkCloneSupported(mounts []mount.Mount) error {
+	if len(mounts) == 0 {
+		return ErrNoMounts
+	}
+	for _, m := range mounts {
+		if slices.Contains(supportedMountTypes, m.Type) {
+			return nil
 		}
-	})
-}
-
-// copySymlink creates a symlink at dst pointing to the same target as src.
-func copySymlink(src, dst string) error {
-	target, err := os.Readlink(src)
-	if err != nil {
-		return err
 	}
-	return os.Symlink(target, dst)
+	return fmt.Errorf("%w: mount types %q (supported: %s)",
+		ErrUnsupportedBackend, joinMountTypes(mounts), strings.Join(supportedMountTypes, ", "))
 }
 
-// copyFile copies a regular file from src to dst using the provided mode bits.
-func copyFile(src, dst string, mode os.FileMode) (retErr error) {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// debugMountOptions logs the options of the overlay mounts of the snapshot
+// described by what at debug level, for an error resolving their writable
+// directory, which only names the mount types: the options hold host paths,
+// which are not put in errors returned to clients.
+func debugMountOptions(ctx context.Context, what string, mounts []mount.Mount) {
+	for i, m := range mounts {
+		if m.Type != "overlay" {
+			continue
+		}
+		log.G(ctx).WithField("snapshot", what).WithField("mount", i).WithField("options", strings.Join(m.Options, ",")).
+			Debug("no writable directory found in overlay mount options")
 	}
-	defer in.Close()
+}
 
-	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-	if err != nil {
-		return err
+// joinMountTypes returns a comma-separated list of mount types for diagnostics.
+func joinMountTypes(mounts []mount.Mount) string {
+	types := make([]string, len(mounts))
+	for i, m := range mounts {
+		types[i] = m.Type
 	}
-	defer func() {
-		if cerr := out.Close(); cerr != nil && retErr == nil {
-			retErr = cerr
-		}
-	}()
-
-	_, err = io.Copy(out, in)
-	return err
+	return strings.Join(types, ", ")
 }
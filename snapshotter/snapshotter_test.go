@@ -1,14 +1,22 @@
 package snapshotter_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/snapshots"
 	"github.com/containerd/containerd/snapshots/native"
 	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter/snapshottest"
+	"golang.org/x/sys/unix"
 )
 
 // newTestSnapshotter creates a CloneSnapshotter backed by the native
@@ -129,6 +137,45 @@ func TestPrepare_Clone(t *testing.T) {
 	assertFileContent(t, cloneDir, "container.txt", "container-data")
 }
 
+// TestPrepare_Clone_CustomLabels verifies that labels passed alongside the
+// clone-source label are stored on the clone while the control labels are
+// not.
+func TestPrepare_Clone_CustomLabels(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshotter.WithCloneLabels("source", map[string]string{
+		"team":                           "payments",
+		"purpose":                        "debugging",
+		snapshotter.LabelCloneBestEffort: "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	for k, want := range map[string]string{
+		"team":                      "payments",
+		"purpose":                   "debugging",
+		snapshotter.LabelClonedFrom: "source",
+	} {
+		if got := info.Labels[k]; got != want {
+			t.Errorf("label %s = %q, want %q", k, got, want)
+		}
+	}
+	for _, k := range []string{snapshotter.LabelCloneSource, snapshotter.LabelCloneBestEffort} {
+		if _, ok := info.Labels[k]; ok {
+			t.Errorf("control label %s stored on the clone", k)
+		}
+	}
+}
+
 // TestPrepare_Clone_DeletedFiles verifies that files deleted in the source
 // container are also absent in the clone (not preserved from the parent layer).
 func TestPrepare_Clone_DeletedFiles(t *testing.T) {
@@ -174,8 +221,9 @@ func TestPrepare_Clone_DeletedFiles(t *testing.T) {
 	}
 }
 
-// TestPrepare_Clone_MissingSource verifies that Prepare returns an error when
-// the source snapshot does not exist.
+// TestPrepare_Clone_MissingSource verifies that Prepare returns an error
+// matching errdefs.ErrNotFound and naming the source when the source
+// snapshot does not exist.
 func TestPrepare_Clone_MissingSource(t *testing.T) {
 	ctx := context.Background()
 	sn, cleanup := newTestSnapshotter(t)
@@ -186,8 +234,220 @@ func TestPrepare_Clone_MissingSource(t *testing.T) {
 			snapshotter.LabelCloneSource: "does-not-exist",
 		}),
 	)
-	if err == nil {
-		t.Fatal("expected error for missing source snapshot, got nil")
+	if !errors.Is(err, errdefs.ErrNotFound) {
+		t.Fatalf("Prepare error = %v, want ErrNotFound", err)
+	}
+	if !strings.Contains(err.Error(), `"does-not-exist"`) {
+		t.Errorf("error %q does not name the source snapshot", err)
+	}
+
+	// The same holds for a prefix without matches and for a source in
+	// another namespace.
+	for name, labels := range map[string]map[string]string{
+		"prefix": {
+			snapshotter.LabelCloneSource:      "does-not",
+			snapshotter.LabelClonePrefixMatch: "true",
+		},
+		"namespace": {
+			snapshotter.LabelCloneSource:          "does-not-exist",
+			snapshotter.LabelCloneSourceNamespace: "other",
+		},
+	} {
+		if _, err := sn.Prepare(ctx, "clone-bad", "", snapshots.WithLabels(labels)); !errors.Is(err, errdefs.ErrNotFound) {
+			t.Errorf("%s: Prepare error = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+// TestPrepare_Clone_MissingParent verifies that a clone whose source parent
+// no longer exists fails with a descriptive error before anything is
+// created.
+func TestPrepare_Clone_MissingParent(t *testing.T) {
+	ctx := context.Background()
+	inner := snapshottest.New(t.TempDir())
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+		t.Fatalf("Prepare base: %v", err)
+	}
+	if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+		t.Fatalf("Commit base: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "source", "base"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	// Report base as removed behind the inner snapshotter's back.
+	inner.Fail = snapshottest.FailOn("Stat", "base", fmt.Errorf("snapshot base: %w", errdefs.ErrNotFound))
+
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, errdefs.ErrFailedPrecondition) || !strings.Contains(err.Error(), `source parent "base" no longer exists`) {
+		t.Fatalf("Prepare clone error = %v, want a missing source parent error", err)
+	}
+	if _, err := inner.Stat(ctx, "clone"); err == nil {
+		t.Error("clone snapshot was created although the source parent is missing")
+	}
+}
+
+// TestPrepare_Clone_MountsError verifies that a failure to get the source's
+// mounts is wrapped with the source's key and fails the clone before
+// anything is created.
+func TestPrepare_Clone_MountsError(t *testing.T) {
+	ctx := context.Background()
+	inner := snapshottest.New(t.TempDir())
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	errMounts := errors.New("mounts failed")
+	inner.Fail = snapshottest.FailOn("Mounts", "source", errMounts)
+
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, errMounts) || !strings.Contains(err.Error(), `get mounts for source snapshot "source"`) {
+		t.Fatalf("Prepare clone error = %v, want the mounts error wrapped with the source key", err)
+	}
+	if _, err := inner.Stat(ctx, "clone"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Stat clone error = %v, want ErrNotFound", err)
+	}
+}
+
+// TestPrepare_Clone_CleanupFailure verifies that OnCleanupFailure is called
+// with the clone's key when a failed clone cannot be removed.
+func TestPrepare_Clone_CleanupFailure(t *testing.T) {
+	ctx := context.Background()
+	inner := snapshottest.New(t.TempDir())
+	inner.Fail = snapshottest.FailOn("Remove", "", errors.New("remove failed"))
+	sn := snapshotter.New(inner)
+	defer sn.Close()
+	sn.MaxCloneBytes = 1
+	var failed []string
+	sn.OnCleanupFailure = func(key string, err error) {
+		failed = append(failed, key)
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "source"), "data.txt"), []byte("too large"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, snapshotter.ErrCloneTooLarge) || !strings.Contains(err.Error(), "cleanup also failed") {
+		t.Fatalf("Prepare clone error = %v, want ErrCloneTooLarge with a cleanup failure", err)
+	}
+	if len(failed) != 1 || failed[0] != "clone" {
+		t.Errorf("OnCleanupFailure called for %q, want once for \"clone\"", failed)
+	}
+}
+
+// TestPrepare_Clone_DirTimes verifies that directories keep their
+// modification times, although files are written into them after they are
+// created, and that empty directories are reproduced.
+func TestPrepare_Clone_DirTimes(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	if err := os.MkdirAll(filepath.Join(srcDir, "a", "b"), 0755); err != nil {
+		t.Fatalf("mkdir a/b: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "a", "b", "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(srcDir, "empty"), 0750); err != nil {
+		t.Fatalf("mkdir empty: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, dir := range []string{"a/b", "a", "empty"} {
+		if err := os.Chtimes(filepath.Join(srcDir, dir), mtime, mtime); err != nil {
+			t.Fatalf("chtimes %s: %v", dir, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	dstDir := writableDir(t, sn, "clone")
+	for _, dir := range []string{"a/b", "a", "empty"} {
+		fi, err := os.Stat(filepath.Join(dstDir, dir))
+		if err != nil {
+			t.Fatalf("stat cloned %s: %v", dir, err)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Errorf("cloned %s mtime = %v, want %v", dir, fi.ModTime(), mtime)
+		}
+	}
+	fi, err := os.Stat(filepath.Join(dstDir, "empty"))
+	if err != nil || !fi.IsDir() || fi.Mode().Perm() != 0750 {
+		t.Errorf("cloned empty directory = %v, %v; want a 0750 directory", fi, err)
+	}
+}
+
+// TestPrepare_Clone_ReadOnlySource verifies that committed snapshots, views
+// and active snapshots without writes can be cloned, and that the clone sees
+// the files of the layer they are based on.
+func TestPrepare_Clone_ReadOnlySource(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "image-active", ""); err != nil {
+		t.Fatalf("Prepare image: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "image-active"), "app.conf"), []byte("image"), 0644); err != nil {
+		t.Fatalf("write app.conf: %v", err)
+	}
+	if err := sn.Commit(ctx, "image", "image-active"); err != nil {
+		t.Fatalf("Commit image: %v", err)
+	}
+	if _, err := sn.View(ctx, "image-view", "image"); err != nil {
+		t.Fatalf("View image: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "fresh", "image"); err != nil {
+		t.Fatalf("Prepare fresh: %v", err)
+	}
+
+	for _, source := range []string{"image", "image-view", "fresh"} {
+		key := "clone-of-" + source
+		if _, err := sn.Prepare(ctx, key, "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: source,
+		})); err != nil {
+			t.Fatalf("clone %s: %v", source, err)
+		}
+		assertFileContent(t, writableDir(t, sn, key), "app.conf", "image")
+		info, err := sn.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat %s: %v", key, err)
+		}
+		if info.Kind != snapshots.KindActive || info.Labels[snapshotter.LabelClonedFrom] != source {
+			t.Errorf("clone of %s: kind %v, cloned-from %q; want an active clone of %q",
+				source, info.Kind, info.Labels[snapshotter.LabelClonedFrom], source)
+		}
+	}
+
+	// A read-only source has nothing to reconcile an existing snapshot
+	// with.
+	_, err := sn.Prepare(ctx, "clone-of-image", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:      "image",
+		snapshotter.LabelCloneIncremental: "true",
+	}))
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Errorf("incremental clone of committed source error = %v, want ErrInvalidArgument", err)
 	}
 }
 
@@ -229,6 +489,444 @@ func TestPrepare_Clone_Symlink(t *testing.T) {
 	assertFileContent(t, cloneDir, "real.txt", "real")
 }
 
+// TestPrepare_Clone_SpecialModeBits verifies that setuid, setgid and sticky
+// bits on source files and directories survive the clone.
+func TestPrepare_Clone_SpecialModeBits(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "mode-src", ""); err != nil {
+		t.Fatalf("Prepare mode-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "mode-src")
+	want := map[string]os.FileMode{
+		"suid-bin": 0755 | os.ModeSetuid,
+		"sgid-bin": 0755 | os.ModeSetgid,
+		"tmp":      0777 | os.ModeSticky | os.ModeDir,
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "suid-bin"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("write suid-bin: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "sgid-bin"), []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("write sgid-bin: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(srcDir, "tmp"), 0755); err != nil {
+		t.Fatalf("mkdir tmp: %v", err)
+	}
+	for name, mode := range want {
+		if err := os.Chmod(filepath.Join(srcDir, name), mode); err != nil {
+			t.Fatalf("chmod %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "mode-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "mode-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare mode-clone: %v", err)
+	}
+
+	cloneDir := writableDir(t, sn, "mode-clone")
+	for name, mode := range want {
+		fi, err := os.Stat(filepath.Join(cloneDir, name))
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s: mode = %v, want %v", name, fi.Mode(), mode)
+		}
+	}
+}
+
+// TestPrepare_Clone_IgnoresUmask verifies that a restrictive umask in the
+// snapshotter process does not strip permission bits from cloned entries.
+func TestPrepare_Clone_IgnoresUmask(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "umask-src", ""); err != nil {
+		t.Fatalf("Prepare umask-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "umask-src")
+	want := map[string]os.FileMode{
+		"shared.txt": 0666,
+		"shared":     0777 | os.ModeDir,
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "shared.txt"), []byte("rw for all"), 0644); err != nil {
+		t.Fatalf("write shared.txt: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(srcDir, "shared"), 0755); err != nil {
+		t.Fatalf("mkdir shared: %v", err)
+	}
+	for name, mode := range want {
+		if err := os.Chmod(filepath.Join(srcDir, name), mode); err != nil {
+			t.Fatalf("chmod %s: %v", name, err)
+		}
+	}
+
+	old := unix.Umask(0077)
+	defer unix.Umask(old)
+
+	if _, err := sn.Prepare(ctx, "umask-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "umask-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare umask-clone: %v", err)
+	}
+
+	cloneDir := writableDir(t, sn, "umask-clone")
+	for name, mode := range want {
+		fi, err := os.Stat(filepath.Join(cloneDir, name))
+		if err != nil {
+			t.Fatalf("stat %s: %v", name, err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s: mode = %v, want %v", name, fi.Mode(), mode)
+		}
+	}
+}
+
+// TestPrepare_Clone_Whiteout verifies that an overlay whiteout (a 0/0
+// character device) in the source upperdir is reproduced in the clone so
+// that the deleted lower-layer file stays deleted.
+func TestPrepare_Clone_Whiteout(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "wh-src", ""); err != nil {
+		t.Fatalf("Prepare wh-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "wh-src")
+	if err := unix.Mknod(filepath.Join(srcDir, "deleted.txt"), unix.S_IFCHR|0000, 0); err != nil {
+		t.Skipf("create whiteout: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "wh-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "wh-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare wh-clone: %v", err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Lstat(filepath.Join(writableDir(t, sn, "wh-clone"), "deleted.txt"), &st); err != nil {
+		t.Fatalf("Lstat whiteout in clone: %v", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != 0 {
+		t.Errorf("whiteout in clone: mode %o rdev %d, want character device 0/0", st.Mode, st.Rdev)
+	}
+}
+
+// TestPrepare_Clone_OpaqueDir verifies that the overlay opaque xattr on a
+// source upperdir directory is preserved in the clone.
+func TestPrepare_Clone_OpaqueDir(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "opq-src", ""); err != nil {
+		t.Fatalf("Prepare opq-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "opq-src")
+	opaque := filepath.Join(srcDir, "replaced")
+	if err := os.Mkdir(opaque, 0755); err != nil {
+		t.Fatalf("mkdir replaced: %v", err)
+	}
+	if err := unix.Setxattr(opaque, "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("set trusted.overlay.opaque: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(opaque, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("write new.txt: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "opq-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "opq-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare opq-clone: %v", err)
+	}
+
+	cloneDir := writableDir(t, sn, "opq-clone")
+	buf := make([]byte, 16)
+	n, err := unix.Getxattr(filepath.Join(cloneDir, "replaced"), "trusted.overlay.opaque", buf)
+	if err != nil {
+		t.Fatalf("get trusted.overlay.opaque on clone: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("y")) {
+		t.Errorf("trusted.overlay.opaque = %q, want %q", buf[:n], "y")
+	}
+	assertFileContent(t, filepath.Join(cloneDir, "replaced"), "new.txt", "new")
+}
+
+// TestPrepare_Clone_ACL verifies that a POSIX access ACL granting a
+// secondary group access to a file is preserved in the clone.
+func TestPrepare_Clone_ACL(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "acl-src", ""); err != nil {
+		t.Fatalf("Prepare acl-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "acl-src")
+	shared := filepath.Join(srcDir, "shared.txt")
+	if err := os.WriteFile(shared, []byte("shared"), 0640); err != nil {
+		t.Fatalf("write shared.txt: %v", err)
+	}
+
+	// user::rw- group::r-- group:1234:rw- mask::rw- other::---, in the
+	// kernel's posix_acl_xattr format.
+	acl := []byte{
+		0x02, 0x00, 0x00, 0x00, // version
+		0x01, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff, // ACL_USER_OBJ
+		0x04, 0x00, 0x04, 0x00, 0xff, 0xff, 0xff, 0xff, // ACL_GROUP_OBJ
+		0x08, 0x00, 0x06, 0x00, 0xd2, 0x04, 0x00, 0x00, // ACL_GROUP 1234
+		0x10, 0x00, 0x06, 0x00, 0xff, 0xff, 0xff, 0xff, // ACL_MASK
+		0x20, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, // ACL_OTHER
+	}
+	if err := unix.Setxattr(shared, "system.posix_acl_access", acl, 0); err != nil {
+		t.Skipf("set system.posix_acl_access: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "acl-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "acl-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare acl-clone: %v", err)
+	}
+
+	buf := make([]byte, 128)
+	n, err := unix.Getxattr(filepath.Join(writableDir(t, sn, "acl-clone"), "shared.txt"), "system.posix_acl_access", buf)
+	if err != nil {
+		t.Fatalf("get system.posix_acl_access on clone: %v", err)
+	}
+	if !bytes.Equal(buf[:n], acl) {
+		t.Errorf("system.posix_acl_access = %x, want %x", buf[:n], acl)
+	}
+}
+
+// TestPrepare_Clone_Exclude verifies that paths matching the clone-exclude
+// patterns are left out of the clone while everything else is copied.
+func TestPrepare_Clone_Exclude(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "ex-src", ""); err != nil {
+		t.Fatalf("Prepare ex-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "ex-src")
+	for _, dir := range []string{"cache/sub", "var/log", "app"} {
+		if err := os.MkdirAll(filepath.Join(srcDir, dir), 0755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+	}
+	for _, name := range []string{"cache/a.bin", "cache/sub/b.bin", "var/log/app.log", "app/main.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "ex-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:  "ex-src",
+			snapshotter.LabelCloneExclude: "cache/*, /var/log",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare ex-clone: %v", err)
+	}
+
+	cloneDir := writableDir(t, sn, "ex-clone")
+	for _, name := range []string{"cache/a.bin", "cache/sub", "var/log"} {
+		if _, err := os.Lstat(filepath.Join(cloneDir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be excluded from the clone, stat err = %v", name, err)
+		}
+	}
+	assertFileContent(t, cloneDir, "app/main.txt", "app/main.txt")
+	if fi, err := os.Stat(filepath.Join(cloneDir, "cache")); err != nil || !fi.IsDir() {
+		t.Errorf("expected the cache directory itself to be cloned, stat err = %v", err)
+	}
+
+	info, err := sn.Stat(ctx, "ex-clone")
+	if err != nil {
+		t.Fatalf("Stat ex-clone: %v", err)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneExclude]; ok {
+		t.Error("clone-exclude label should not be stored on the clone")
+	}
+}
+
+// TestPrepare_Clone_ExcludeInvalidPattern verifies that a malformed exclude
+// pattern is rejected before the clone is created.
+func TestPrepare_Clone_ExcludeInvalidPattern(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "ex-src", ""); err != nil {
+		t.Fatalf("Prepare ex-src: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "ex-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:  "ex-src",
+			snapshotter.LabelCloneExclude: "cache/[",
+		}),
+	); err == nil {
+		t.Fatal("expected error for malformed exclude pattern, got nil")
+	}
+	if _, err := sn.Stat(ctx, "ex-clone"); err == nil {
+		t.Error("ex-clone should not have been created")
+	}
+}
+
+// TestPrepare_Clone_VerifyChecksums verifies that a clone with checksum
+// verification enabled succeeds and reproduces the source content.
+func TestPrepare_Clone_VerifyChecksums(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.VerifyChecksums = true
+
+	if _, err := sn.Prepare(ctx, "crc-src", ""); err != nil {
+		t.Fatalf("Prepare crc-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "crc-src")
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("checked"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "crc-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "crc-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare crc-clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "crc-clone"), "data.txt", "checked")
+}
+
+// TestPrepare_Clone_LineageLabel verifies that a clone records the key of its
+// source in the persisted cloned-from label while the clone-source trigger
+// label is not stored.
+func TestPrepare_Clone_LineageLabel(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "lineage-src", ""); err != nil {
+		t.Fatalf("Prepare lineage-src: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "lineage-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "lineage-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare lineage-clone: %v", err)
+	}
+
+	info, err := sn.Stat(ctx, "lineage-clone")
+	if err != nil {
+		t.Fatalf("Stat lineage-clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelClonedFrom]; got != "lineage-src" {
+		t.Errorf("cloned-from label = %q, want %q", got, "lineage-src")
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneSource]; ok {
+		t.Error("clone-source label should not be stored on the clone")
+	}
+	if _, ok := info.Labels[snapshotter.LabelClonedFromNamespace]; ok {
+		t.Error("cloned-from-namespace label should only be set for cross-namespace clones")
+	}
+
+	// The label is also visible while walking.
+	found := false
+	if err := sn.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		if info.Name == "lineage-clone" {
+			found = info.Labels[snapshotter.LabelClonedFrom] == "lineage-src"
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	if !found {
+		t.Error("Walk did not report the cloned-from label on lineage-clone")
+	}
+}
+
+// TestPrepare_Clone_StatsLabels verifies that a clone records how many bytes
+// and files it copied, and how long it took, in labels visible through Stat.
+func TestPrepare_Clone_StatsLabels(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "stats-src", ""); err != nil {
+		t.Fatalf("Prepare stats-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "stats-src")
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("mkdir sub: %v", err)
+	}
+	for name, size := range map[string]int{"a.bin": 1000, "sub/b.bin": 2345} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "stats-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "stats-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare stats-clone: %v", err)
+	}
+
+	info, err := sn.Stat(ctx, "stats-clone")
+	if err != nil {
+		t.Fatalf("Stat stats-clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelCloneBytes]; got != "3345" {
+		t.Errorf("clone-bytes label = %q, want %q", got, "3345")
+	}
+	if got := info.Labels[snapshotter.LabelCloneFiles]; got != "2" {
+		t.Errorf("clone-files label = %q, want %q", got, "2")
+	}
+	if d, err := time.ParseDuration(info.Labels[snapshotter.LabelCloneDuration]); err != nil || d <= 0 {
+		t.Errorf("clone-duration label = %q, want a positive duration", info.Labels[snapshotter.LabelCloneDuration])
+	}
+}
+
+// TestWritableDir verifies that WritableDir resolves the same directory the
+// native snapshotter reports as its bind mount source.
+func TestWritableDir(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "wd", ""); err != nil {
+		t.Fatalf("Prepare wd: %v", err)
+	}
+	got, err := sn.WritableDir(ctx, "wd")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if want := writableDir(t, sn, "wd"); got != want {
+		t.Errorf("WritableDir = %q, want %q", got, want)
+	}
+
+	if _, err := sn.WritableDir(ctx, "does-not-exist"); err == nil {
+		t.Error("expected error for missing snapshot, got nil")
+	}
+}
+
 // assertFileContent reads the file at dir/name and checks its content.
 func assertFileContent(t *testing.T, dir, name, want string) {
 	t.Helper()
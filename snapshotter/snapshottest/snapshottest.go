@@ -0,0 +1,385 @@
+// Package snapshottest provides a fake snapshots.Snapshotter for testing
+// code that wraps a snapshotter, such as the clone snapshotter, including
+// its error paths.
+package snapshottest
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/filters"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// Snapshotter is a snapshots.Snapshotter that keeps its metadata in memory
+// and lets tests inject errors into any operation.  The content of each
+// snapshot is a directory under the root directory given to New, which
+// Mounts returns as a single bind mount, read-only for views.  Unlike real
+// snapshotters it does not stack snapshots on their parents: a snapshot's
+// directory starts out empty and only holds its own files.  Namespaces are
+// ignored, as by the native snapshotter.
+type Snapshotter struct {
+	// Fail, if set, is called at the start of every operation with the
+	// operation's method name, such as "Stat" or "Mounts", and the key it
+	// acts on; Commit passes the key of the active snapshot, and Walk and
+	// Close an empty key.  An error it returns is returned by the
+	// operation, which then does nothing.
+	Fail func(op, key string) error
+
+	root string
+
+	mu        sync.Mutex
+	next      int
+	snapshots map[string]*snapshot
+}
+
+// snapshot is a snapshot of a Snapshotter.
+type snapshot struct {
+	info snapshots.Info
+	dir  string
+}
+
+// New returns a Snapshotter keeping the content of its snapshots in
+// directories under root.
+func New(root string) *Snapshotter {
+	return &Snapshotter{root: root, snapshots: map[string]*snapshot{}}
+}
+
+// FailOn returns a Fail function that fails the operation op on key with
+// err, and on any key if key is empty.
+func FailOn(op, key string, err error) func(op, key string) error {
+	return func(gotOp, gotKey string) error {
+		if gotOp == op && (key == "" || gotKey == key) {
+			return err
+		}
+		return nil
+	}
+}
+
+// fail calls Fail, if set, for the operation op on key.
+func (s *Snapshotter) fail(op, key string) error {
+	if s.Fail == nil {
+		return nil
+	}
+	return s.Fail(op, key)
+}
+
+// get returns the snapshot key.  The caller must hold mu.
+func (s *Snapshotter) get(key string) (*snapshot, error) {
+	sn, ok := s.snapshots[key]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s: %w", key, errdefs.ErrNotFound)
+	}
+	return sn, nil
+}
+
+// Stat returns the info of the snapshot key.
+func (s *Snapshotter) Stat(_ context.Context, key string) (snapshots.Info, error) {
+	if err := s.fail("Stat", key); err != nil {
+		return snapshots.Info{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, err := s.get(key)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+	return copyInfo(sn.info), nil
+}
+
+// Update updates the labels of the snapshot named by info.  Only the
+// "labels" and "labels.<key>" field paths are supported; no field paths
+// replaces all labels.
+func (s *Snapshotter) Update(_ context.Context, info snapshots.Info, fieldpaths ...string) (snapshots.Info, error) {
+	if err := s.fail("Update", info.Name); err != nil {
+		return snapshots.Info{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, err := s.get(info.Name)
+	if err != nil {
+		return snapshots.Info{}, err
+	}
+
+	labels := copyLabels(sn.info.Labels)
+	if len(fieldpaths) == 0 {
+		fieldpaths = []string{"labels"}
+	}
+	for _, path := range fieldpaths {
+		if path == "labels" {
+			labels = copyLabels(info.Labels)
+			continue
+		}
+		label, ok := strings.CutPrefix(path, "labels.")
+		if !ok {
+			return snapshots.Info{}, fmt.Errorf("cannot update %q field on snapshot %q: %w", path, info.Name, errdefs.ErrInvalidArgument)
+		}
+		if v, ok := info.Labels[label]; ok {
+			labels[label] = v
+		} else {
+			delete(labels, label)
+		}
+	}
+	sn.info.Labels = labels
+	sn.info.Updated = time.Now().UTC()
+	return copyInfo(sn.info), nil
+}
+
+// Usage returns the disk usage of the directory of the snapshot key.
+func (s *Snapshotter) Usage(_ context.Context, key string) (snapshots.Usage, error) {
+	if err := s.fail("Usage", key); err != nil {
+		return snapshots.Usage{}, err
+	}
+	s.mu.Lock()
+	sn, err := s.get(key)
+	s.mu.Unlock()
+	if err != nil {
+		return snapshots.Usage{}, err
+	}
+
+	var usage snapshots.Usage
+	err = filepath.WalkDir(sn.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.Inodes++
+		if fi.Mode().IsRegular() {
+			usage.Size += fi.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// Mounts returns the mounts of the snapshot key.
+func (s *Snapshotter) Mounts(_ context.Context, key string) ([]mount.Mount, error) {
+	if err := s.fail("Mounts", key); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return sn.mounts(), nil
+}
+
+// mounts returns the bind mount of the snapshot's directory.
+func (sn *snapshot) mounts() []mount.Mount {
+	mode := "rw"
+	if sn.info.Kind == snapshots.KindView {
+		mode = "ro"
+	}
+	return []mount.Mount{{Type: "bind", Source: sn.dir, Options: []string{"rbind", mode}}}
+}
+
+// Prepare creates the active snapshot key on parent.
+func (s *Snapshotter) Prepare(_ context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	if err := s.fail("Prepare", key); err != nil {
+		return nil, err
+	}
+	return s.create(snapshots.KindActive, key, parent, opts)
+}
+
+// View creates the view snapshot key on parent.
+func (s *Snapshotter) View(_ context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	if err := s.fail("View", key); err != nil {
+		return nil, err
+	}
+	return s.create(snapshots.KindView, key, parent, opts)
+}
+
+// create creates the snapshot key of kind on parent.
+func (s *Snapshotter) create(kind snapshots.Kind, key, parent string, opts []snapshots.Opt) ([]mount.Mount, error) {
+	var info snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snapshots[key]; ok {
+		return nil, fmt.Errorf("snapshot %s: %w", key, errdefs.ErrAlreadyExists)
+	}
+	if parent != "" {
+		p, err := s.get(parent)
+		if err != nil {
+			return nil, fmt.Errorf("parent: %w", err)
+		}
+		if p.info.Kind != snapshots.KindCommitted {
+			return nil, fmt.Errorf("parent %s is not committed: %w", parent, errdefs.ErrInvalidArgument)
+		}
+	}
+
+	s.next++
+	dir := filepath.Join(s.root, strconv.Itoa(s.next))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sn := &snapshot{
+		info: snapshots.Info{
+			Kind:    kind,
+			Name:    key,
+			Parent:  parent,
+			Labels:  copyLabels(info.Labels),
+			Created: now,
+			Updated: now,
+		},
+		dir: dir,
+	}
+	s.snapshots[key] = sn
+	return sn.mounts(), nil
+}
+
+// Commit commits the active snapshot key as the committed snapshot name.
+func (s *Snapshotter) Commit(_ context.Context, name, key string, opts ...snapshots.Opt) error {
+	if err := s.fail("Commit", key); err != nil {
+		return err
+	}
+	var info snapshots.Info
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if sn.info.Kind != snapshots.KindActive {
+		return fmt.Errorf("snapshot %s is not active: %w", key, errdefs.ErrFailedPrecondition)
+	}
+	if _, ok := s.snapshots[name]; ok {
+		return fmt.Errorf("snapshot %s: %w", name, errdefs.ErrAlreadyExists)
+	}
+
+	now := time.Now().UTC()
+	s.snapshots[name] = &snapshot{
+		info: snapshots.Info{
+			Kind:    snapshots.KindCommitted,
+			Name:    name,
+			Parent:  sn.info.Parent,
+			Labels:  copyLabels(info.Labels),
+			Created: now,
+			Updated: now,
+		},
+		dir: sn.dir,
+	}
+	delete(s.snapshots, key)
+	return nil
+}
+
+// Remove removes the snapshot key, which must not have children.
+func (s *Snapshotter) Remove(_ context.Context, key string) error {
+	if err := s.fail("Remove", key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	for _, other := range s.snapshots {
+		if other.info.Parent == key {
+			return fmt.Errorf("snapshot %s has children: %w", key, errdefs.ErrFailedPrecondition)
+		}
+	}
+	if err := os.RemoveAll(sn.dir); err != nil {
+		return err
+	}
+	delete(s.snapshots, key)
+	return nil
+}
+
+// Walk calls fn with the info of every snapshot matching any of the
+// filters, in key order.
+func (s *Snapshotter) Walk(ctx context.Context, fn snapshots.WalkFunc, fs ...string) error {
+	if err := s.fail("Walk", ""); err != nil {
+		return err
+	}
+	filter, err := filters.ParseAll(fs...)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	infos := make([]snapshots.Info, 0, len(s.snapshots))
+	for _, sn := range s.snapshots {
+		if filter.Match(adaptInfo(sn.info)) {
+			infos = append(infos, copyInfo(sn.info))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	for _, info := range infos {
+		if err := fn(ctx, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close does nothing but call Fail.
+func (s *Snapshotter) Close() error {
+	return s.fail("Close", "")
+}
+
+// adaptInfo adapts info for filters, with the fields containerd's metadata
+// store supports.
+func adaptInfo(info snapshots.Info) filters.Adaptor {
+	return filters.AdapterFunc(func(fieldpath []string) (string, bool) {
+		if len(fieldpath) == 0 {
+			return "", false
+		}
+		switch fieldpath[0] {
+		case "kind":
+			return info.Kind.String(), true
+		case "name":
+			return info.Name, true
+		case "parent":
+			return info.Parent, true
+		case "labels":
+			v, ok := info.Labels[strings.Join(fieldpath[1:], ".")]
+			return v, ok
+		}
+		return "", false
+	})
+}
+
+// copyInfo returns a copy of info that does not share its labels.
+func copyInfo(info snapshots.Info) snapshots.Info {
+	info.Labels = copyLabels(info.Labels)
+	return info
+}
+
+// copyLabels returns a copy of labels, which is never nil.
+func copyLabels(labels map[string]string) map[string]string {
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,96 @@
+package snapshottest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// TestSnapshotter verifies the lifecycle of snapshots: preparing, committing,
+// viewing, updating labels, walking with a filter and removing.
+func TestSnapshotter(t *testing.T) {
+	ctx := context.Background()
+	sn := New(t.TempDir())
+
+	mounts, err := sn.Prepare(ctx, "active", "", snapshots.WithLabels(map[string]string{"a": "1"}))
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if len(mounts) != 1 || mounts[0].Type != "bind" {
+		t.Fatalf("Prepare mounts = %+v, want one bind mount", mounts)
+	}
+	if err := os.WriteFile(filepath.Join(mounts[0].Source, "f"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if usage, err := sn.Usage(ctx, "active"); err != nil || usage.Size != 5 || usage.Inodes != 2 {
+		t.Errorf("Usage = %+v, %v, want 5 bytes in 2 inodes", usage, err)
+	}
+	if _, err := sn.Prepare(ctx, "active", ""); !errors.Is(err, errdefs.ErrAlreadyExists) {
+		t.Errorf("second Prepare error = %v, want ErrAlreadyExists", err)
+	}
+
+	if err := sn.Commit(ctx, "base", "active", snapshots.WithLabels(map[string]string{"b": "2"})); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, err := sn.Stat(ctx, "active"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Stat committed key error = %v, want ErrNotFound", err)
+	}
+	if _, err := sn.View(ctx, "view", "base"); err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	if mounts, err := sn.Mounts(ctx, "view"); err != nil || mounts[0].Options[1] != "ro" {
+		t.Errorf("view mounts = %+v, %v, want a read-only mount", mounts, err)
+	}
+
+	info, err := sn.Update(ctx, snapshots.Info{Name: "base", Labels: map[string]string{"c": "3"}}, "labels.c")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if info.Labels["b"] != "2" || info.Labels["c"] != "3" {
+		t.Errorf("updated labels = %v, want b=2 and c=3", info.Labels)
+	}
+
+	var walked []string
+	err = sn.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		walked = append(walked, info.Name)
+		return nil
+	}, `labels.c==3`)
+	if err != nil || len(walked) != 1 || walked[0] != "base" {
+		t.Errorf("Walk = %q, %v, want [base]", walked, err)
+	}
+
+	if err := sn.Remove(ctx, "base"); !errors.Is(err, errdefs.ErrFailedPrecondition) {
+		t.Errorf("Remove parent error = %v, want ErrFailedPrecondition", err)
+	}
+	if err := sn.Remove(ctx, "view"); err != nil {
+		t.Fatalf("Remove view: %v", err)
+	}
+	if err := sn.Remove(ctx, "base"); err != nil {
+		t.Fatalf("Remove base: %v", err)
+	}
+}
+
+// TestSnapshotter_Fail verifies that an error injected with FailOn fails
+// only the operation and key it names, and that the failed operation has no
+// effect.
+func TestSnapshotter_Fail(t *testing.T) {
+	ctx := context.Background()
+	sn := New(t.TempDir())
+	errInjected := errors.New("injected")
+	sn.Fail = FailOn("Prepare", "bad", errInjected)
+
+	if _, err := sn.Prepare(ctx, "bad", ""); !errors.Is(err, errInjected) {
+		t.Errorf("Prepare bad error = %v, want the injected error", err)
+	}
+	if _, err := sn.Stat(ctx, "bad"); !errors.Is(err, errdefs.ErrNotFound) {
+		t.Errorf("Stat bad error = %v, want ErrNotFound", err)
+	}
+	if _, err := sn.Prepare(ctx, "good", ""); err != nil {
+		t.Errorf("Prepare good: %v", err)
+	}
+}
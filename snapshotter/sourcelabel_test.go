@@ -0,0 +1,60 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_SourceByLabel verifies that a source named by the value
+// of one of its labels is found and recorded by its key, and that a value no
+// snapshot or several snapshots carry fails the clone.
+func TestPrepare_Clone_SourceByLabel(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	const digest = "example.com/golden-digest"
+
+	for key, value := range map[string]string{"golden-v2": "sha256:bbb", "golden-v1": "sha256:aaa", "copy-v1": "sha256:aaa"} {
+		if _, err := sn.Prepare(ctx, key, "", snapshots.WithLabels(map[string]string{digest: value})); err != nil {
+			t.Fatalf("Prepare %s: %v", key, err)
+		}
+		if err := os.WriteFile(filepath.Join(writableDir(t, sn, key), "data.txt"), []byte(key), 0644); err != nil {
+			t.Fatalf("write data.txt: %v", err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource:        "sha256:bbb",
+		snapshotter.LabelCloneSourceByLabel: digest,
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "clone"), "data.txt", "golden-v2")
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelClonedFrom]; got != "golden-v2" {
+		t.Errorf("label cloned-from = %q, want %q", got, "golden-v2")
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneSourceByLabel]; ok {
+		t.Error("source-by-label label was stored on the clone")
+	}
+
+	for value, want := range map[string]error{"sha256:ccc": errdefs.ErrNotFound, "sha256:aaa": errdefs.ErrInvalidArgument} {
+		_, err := sn.Prepare(ctx, "clone-"+value[7:], "", snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:        value,
+			snapshotter.LabelCloneSourceByLabel: digest,
+		}))
+		if !errors.Is(err, want) {
+			t.Errorf("clone of %s error = %v, want %v", value, err, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrInsufficientSpace is returned (wrapped) by Prepare when the destination
+// filesystem does not have enough free space to hold a clone.
+var ErrInsufficientSpace = errors.New("insufficient space")
+
+// ErrCloneTooLarge is returned (wrapped) by Prepare when the source's writable
+// layer is larger than [CloneSnapshotter.MaxCloneBytes].
+var ErrCloneTooLarge = errors.New("clone exceeds maximum size")
+
+// ErrInsufficientInodes is returned (wrapped) by Prepare when the
+// destination filesystem does not have enough free inodes to hold a clone.
+var ErrInsufficientInodes = errors.New("insufficient inodes")
+
+// ErrReadOnlyDestination is returned (wrapped) by Prepare when the
+// destination filesystem is read-only, e.g. because it was remounted
+// read-only after errors.
+var ErrReadOnlyDestination = errors.New("destination filesystem is read-only")
+
+// checkWritable verifies that files can be created in dstDir, by creating
+// and removing a scratch file, so that a read-only destination is reported
+// as such before the copy starts rather than as a failure deep in it.
+func checkWritable(dstDir string) error {
+	f, err := os.CreateTemp(dstDir, ".clone-probe-*")
+	if errors.Is(err, unix.EROFS) {
+		return fmt.Errorf("%w: %s", ErrReadOnlyDestination, dstDir)
+	}
+	if err != nil {
+		return fmt.Errorf("probe destination directory: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkSpace verifies that the filesystem holding dstDir can accommodate
+// required bytes plus margin. The destination is cleared before the copy, so
+// whatever it currently holds is counted as available.
+func checkSpace(required uint64, dstDir string, margin uint64) error {
+	reclaimable, err := dirSize(dstDir)
+	if err != nil {
+		return fmt.Errorf("measure destination directory: %w", err)
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(dstDir, &st); err != nil {
+		return fmt.Errorf("statfs %q: %w", dstDir, err)
+	}
+	available := uint64(st.Bavail)*uint64(st.Bsize) + reclaimable
+
+	if required+margin > available {
+		return fmt.Errorf("%w: clone needs %d bytes plus a %d byte margin, destination has %d bytes available",
+			ErrInsufficientSpace, required, margin, available)
+	}
+	return nil
+}
+
+// checkInodes verifies that the filesystem holding dstDir has required free
+// inodes, counting those of the entries dstDir currently holds, which are
+// cleared before the copy, as free.  statfs is unix.Statfs if nil.
+// Filesystems that allocate inodes dynamically, such as btrfs, report no
+// inode counts and always pass.
+func checkInodes(required uint64, dstDir string, statfs func(string, *unix.Statfs_t) error) error {
+	if statfs == nil {
+		statfs = unix.Statfs
+	}
+	_, reclaimable, err := dirUsage(dstDir)
+	if err != nil {
+		return fmt.Errorf("measure destination directory: %w", err)
+	}
+
+	var st unix.Statfs_t
+	if err := statfs(dstDir, &st); err != nil {
+		return fmt.Errorf("statfs %q: %w", dstDir, err)
+	}
+	if st.Files == 0 {
+		return nil
+	}
+	if available := st.Ffree + reclaimable; required > available {
+		return fmt.Errorf("%w: clone needs %d inodes, destination has %d available",
+			ErrInsufficientInodes, required, available)
+	}
+	return nil
+}
+
+// dirSize returns the total size in bytes of the regular files under dir.
+// A missing dir has size zero.
+func dirSize(dir string) (uint64, error) {
+	size, _, err := dirUsage(dir)
+	return size, err
+}
+
+// dirUsage returns the total size in bytes of the regular files under dir
+// and the number of entries below dir.  A missing dir is empty.
+func dirUsage(dir string) (size, entries uint64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		entries++
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	return size, entries, nil
+}
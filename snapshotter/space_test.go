@@ -0,0 +1,138 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// newTmpfsSnapshotter creates a CloneSnapshotter backed by the native
+// snapshotter rooted on a freshly mounted tmpfs of the given size.  The test
+// is skipped when the process is not allowed to mount filesystems.
+func newTmpfsSnapshotter(t *testing.T, size string) *snapshotter.CloneSnapshotter {
+	t.Helper()
+	root := t.TempDir()
+	if err := unix.Mount("tmpfs", root, "tmpfs", 0, "size="+size); err != nil {
+		t.Skipf("mount tmpfs: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := unix.Unmount(root, 0); err != nil {
+			t.Errorf("unmount tmpfs: %v", err)
+		}
+	})
+
+	inner, err := native.NewSnapshotter(root)
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	t.Cleanup(func() { inner.Close() })
+	return snapshotter.New(inner)
+}
+
+// TestPrepare_Clone_InsufficientSpace verifies that a clone which would not
+// fit on the destination filesystem fails before anything is copied.
+func TestPrepare_Clone_InsufficientSpace(t *testing.T) {
+	ctx := context.Background()
+	sn := newTmpfsSnapshotter(t, "4m")
+
+	if _, err := sn.Prepare(ctx, "big-src", ""); err != nil {
+		t.Fatalf("Prepare big-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "big-src")
+	if err := os.WriteFile(filepath.Join(srcDir, "big.bin"), make([]byte, 1<<20), 0644); err != nil {
+		t.Fatalf("write big.bin: %v", err)
+	}
+
+	// 1 MiB of data fits comfortably in the remaining space, but not once a
+	// 4 MiB safety margin is demanded on top.
+	sn.SpaceMargin = 4 << 20
+
+	_, err := sn.Prepare(ctx, "big-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "big-src",
+		}),
+	)
+	if !errors.Is(err, snapshotter.ErrInsufficientSpace) {
+		t.Fatalf("Prepare clone error = %v, want ErrInsufficientSpace", err)
+	}
+
+	// The failed clone must not be left behind.
+	if _, err := sn.Stat(ctx, "big-clone"); err == nil {
+		t.Error("expected big-clone to be removed after the failed clone")
+	}
+}
+
+// TestPrepare_Clone_FitsWithinSpace verifies that the space pre-check does
+// not reject a clone that fits.
+func TestPrepare_Clone_FitsWithinSpace(t *testing.T) {
+	ctx := context.Background()
+	sn := newTmpfsSnapshotter(t, "4m")
+
+	if _, err := sn.Prepare(ctx, "small-src", ""); err != nil {
+		t.Fatalf("Prepare small-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "small-src")
+	if err := os.WriteFile(filepath.Join(srcDir, "small.bin"), make([]byte, 64<<10), 0644); err != nil {
+		t.Fatalf("write small.bin: %v", err)
+	}
+	sn.SpaceMargin = 64 << 10
+
+	if _, err := sn.Prepare(ctx, "small-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "small-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(writableDir(t, sn, "small-clone"), "small.bin")); err != nil || fi.Size() != 64<<10 {
+		t.Errorf("small.bin in clone: %v, %v", fi, err)
+	}
+}
+
+// TestPrepare_Clone_MaxCloneBytes verifies that a clone of a source larger
+// than MaxCloneBytes is aborted and removed.
+func TestPrepare_Clone_MaxCloneBytes(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.MaxCloneBytes = 1024
+
+	if _, err := sn.Prepare(ctx, "max-src", ""); err != nil {
+		t.Fatalf("Prepare max-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "max-src")
+	if err := os.WriteFile(filepath.Join(srcDir, "big.bin"), make([]byte, 4096), 0644); err != nil {
+		t.Fatalf("write big.bin: %v", err)
+	}
+
+	_, err := sn.Prepare(ctx, "max-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "max-src",
+		}),
+	)
+	if !errors.Is(err, snapshotter.ErrCloneTooLarge) {
+		t.Fatalf("Prepare clone error = %v, want ErrCloneTooLarge", err)
+	}
+	if _, err := sn.Stat(ctx, "max-clone"); err == nil {
+		t.Error("expected max-clone to be removed after the aborted clone")
+	}
+
+	// A source within the limit clones normally.
+	if err := os.Truncate(filepath.Join(srcDir, "big.bin"), 512); err != nil {
+		t.Fatalf("truncate big.bin: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "max-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "max-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare clone within limit: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stagingPrefix marks the directories under [CloneSnapshotter.StagingDir]
+// that belong to a clone.  Cleanup only ever touches entries carrying it.
+const stagingPrefix = "clone-staging-"
+
+// StagingPath returns the directory in which in-progress clone work for the
+// snapshot key in the namespace of ctx is kept, or "" if no StagingDir is
+// configured.  The namespace and key are path-escaped together so that the
+// same key in two namespaces, and keys containing slashes, each map to a
+// single directory of their own.
+func (s *CloneSnapshotter) StagingPath(ctx context.Context, key string) string {
+	if s.StagingDir == "" {
+		return ""
+	}
+	return filepath.Join(s.StagingDir, stagingPrefix+url.PathEscape(refKey(ctx, key)))
+}
+
+// Remove removes the snapshot identified by key from the inner snapshotter
+// and then deletes any clone staging directory left behind for it, e.g. by a
+// clone that was interrupted by a crash.  Removing a view clone also removes
+// the committed snapshot backing it.  The source of a clone in progress
+// cannot be removed.
+func (s *CloneSnapshotter) Remove(ctx context.Context, key string) error {
+	s.refMu.Lock()
+	if err := s.checkNotInUse(ctx, key); err != nil {
+		s.refMu.Unlock()
+		return err
+	}
+	info, statErr := s.Snapshotter.Stat(ctx, key)
+	err := s.Snapshotter.Remove(ctx, key)
+	s.refMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.untrackClone(ctx, key)
+	if backing, ok := info.Labels[LabelCloneViewOf]; ok && statErr == nil {
+		if err := s.Snapshotter.Remove(ctx, backing); err != nil {
+			return fmt.Errorf("remove snapshot %q backing view clone %q: %w", backing, key, err)
+		}
+	}
+	if p := s.StagingPath(ctx, key); p != "" {
+		if err := os.RemoveAll(p); err != nil {
+			return fmt.Errorf("remove clone staging directory for %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SweepStaging removes every clone staging directory under StagingDir.  It
+// is meant to be called once at startup, before any clone runs, when all
+// staging directories are leftovers of a previous process.  Entries without
+// the staging prefix are never touched, and neither are the directories of
+// resumable clones, which a later Prepare can still resume.
+func (s *CloneSnapshotter) SweepStaging() error {
+	if s.StagingDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(s.StagingDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), stagingPrefix) {
+			continue
+		}
+		if hasResumeManifest(filepath.Join(s.StagingDir, e.Name())) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.StagingDir, e.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+)
+
+// TestRemove_CleansStaging verifies that removing a snapshot deletes its
+// leftover clone staging directory and nothing else.
+func TestRemove_CleansStaging(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.StagingDir = t.TempDir()
+
+	const key = "k8s.io/42/stage-me"
+	if _, err := sn.Prepare(ctx, key, ""); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+
+	staging := sn.StagingPath(ctx, key)
+	if filepath.Dir(staging) != sn.StagingDir || strings.Contains(filepath.Base(staging), "/") {
+		t.Fatalf("StagingPath(%q) = %q, want a single entry under %q", key, staging, sn.StagingDir)
+	}
+	if err := os.MkdirAll(filepath.Join(staging, "partial"), 0700); err != nil {
+		t.Fatalf("create staging dir: %v", err)
+	}
+	other := sn.StagingPath(ctx, "other")
+	if err := os.Mkdir(other, 0700); err != nil {
+		t.Fatalf("create other staging dir: %v", err)
+	}
+
+	if err := sn.Remove(ctx, key); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("staging dir for %q still exists, stat err = %v", key, err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("staging dir of another key was removed: %v", err)
+	}
+}
+
+// TestRemove_CleansStagingOfNamespace verifies that removing a snapshot
+// leaves the staging directory of the same key in another namespace alone.
+func TestRemove_CleansStagingOfNamespace(t *testing.T) {
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.StagingDir = t.TempDir()
+
+	nsA := namespaces.WithNamespace(context.Background(), "a")
+	nsB := namespaces.WithNamespace(context.Background(), "b")
+	if _, err := sn.Prepare(nsA, "x", ""); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	stagingA, stagingB := sn.StagingPath(nsA, "x"), sn.StagingPath(nsB, "x")
+	if stagingA == stagingB {
+		t.Fatalf("StagingPath is %q in both namespaces", stagingA)
+	}
+	for _, dir := range []string{stagingA, stagingB} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatalf("create staging dir: %v", err)
+		}
+	}
+
+	if err := sn.Remove(nsA, "x"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(stagingA); !os.IsNotExist(err) {
+		t.Errorf("staging dir of the removed snapshot still exists, stat err = %v", err)
+	}
+	if _, err := os.Stat(stagingB); err != nil {
+		t.Errorf("staging dir of the same key in another namespace was removed: %v", err)
+	}
+}
+
+// TestSweepStaging verifies that the startup sweep removes staging
+// directories but leaves unrelated entries alone.
+func TestSweepStaging(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.StagingDir = t.TempDir()
+
+	for _, key := range []string{"a", "b/c"} {
+		if err := os.Mkdir(sn.StagingPath(ctx, key), 0700); err != nil {
+			t.Fatalf("create staging dir for %q: %v", key, err)
+		}
+	}
+	unrelated := filepath.Join(sn.StagingDir, "snapshots")
+	if err := os.Mkdir(unrelated, 0700); err != nil {
+		t.Fatalf("create unrelated dir: %v", err)
+	}
+
+	if err := sn.SweepStaging(); err != nil {
+		t.Fatalf("SweepStaging: %v", err)
+	}
+	entries, err := os.ReadDir(sn.StagingDir)
+	if err != nil {
+		t.Fatalf("read staging dir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "snapshots" {
+		t.Errorf("entries after sweep = %v, want only the unrelated directory", entries)
+	}
+}
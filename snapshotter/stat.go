@@ -0,0 +1,41 @@
+package snapshotter
+
+import (
+	"context"
+	"maps"
+	"strconv"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/log"
+)
+
+// LabelCloneSize is a virtual label that Stat adds to clones when
+// [CloneSnapshotter.StatCloneSize] is set: the current disk usage of the
+// clone's own layer in bytes, in decimal, as reported by the inner
+// snapshotter's Usage.  It is computed on every Stat and never stored:
+// Update drops it from an update replacing all labels, and rejects an update
+// naming it in its field paths as an invalid argument.
+const LabelCloneSize = "containerd.io/snapshot/clone-size"
+
+// Stat returns the inner snapshotter's info for key.  With StatCloneSize set
+// a clone, that is a snapshot carrying [LabelClonedFrom], additionally gets
+// [LabelCloneSize]; nothing else differs from the inner Stat.
+func (s *CloneSnapshotter) Stat(ctx context.Context, key string) (snapshots.Info, error) {
+	info, err := s.Snapshotter.Stat(ctx, key)
+	if err != nil || !s.StatCloneSize {
+		return info, err
+	}
+	if _, ok := info.Labels[LabelClonedFrom]; !ok {
+		return info, nil
+	}
+	usage, err := s.Snapshotter.Usage(ctx, key)
+	if err != nil {
+		// The size is a convenience; Stat itself succeeded.
+		log.G(ctx).WithError(err).WithField("key", key).Debug("cannot compute clone size")
+		return info, nil
+	}
+	// The labels map may be shared with the inner snapshotter.
+	info.Labels = maps.Clone(info.Labels)
+	info.Labels[LabelCloneSize] = strconv.FormatInt(usage.Size, 10)
+	return info, nil
+}
@@ -0,0 +1,74 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestStat_CloneSize verifies that, with StatCloneSize set, Stat reports the
+// size of a clone but not of a snapshot that is no clone, and that the
+// virtual label is not stored by an Update replacing all labels and cannot
+// be set by an Update naming it.
+func TestStat_CloneSize(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "source"), "data.bin"), make([]byte, 64<<10), 0644); err != nil {
+		t.Fatalf("write data.bin: %v", err)
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneSize]; ok {
+		t.Errorf("Stat reports %s with StatCloneSize unset", snapshotter.LabelCloneSize)
+	}
+
+	sn.StatCloneSize = true
+	info, err = sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	size, err := strconv.ParseInt(info.Labels[snapshotter.LabelCloneSize], 10, 64)
+	if err != nil || size < 64<<10 {
+		t.Errorf("%s = %q, want at least %d", snapshotter.LabelCloneSize, info.Labels[snapshotter.LabelCloneSize], 64<<10)
+	}
+	if src, err := sn.Stat(ctx, "source"); err != nil {
+		t.Fatalf("Stat source: %v", err)
+	} else if _, ok := src.Labels[snapshotter.LabelCloneSize]; ok {
+		t.Errorf("Stat reports %s on a snapshot that is no clone", snapshotter.LabelCloneSize)
+	}
+
+	if _, err := sn.Update(ctx, info); err != nil {
+		t.Fatalf("Update clone: %v", err)
+	}
+	sn.StatCloneSize = false
+	if info, err = sn.Stat(ctx, "clone"); err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneSize]; ok {
+		t.Errorf("Update stored the virtual %s label", snapshotter.LabelCloneSize)
+	}
+
+	info.Labels = map[string]string{snapshotter.LabelCloneSize: "1"}
+	if _, err := sn.Update(ctx, info, "labels."+snapshotter.LabelCloneSize); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("Update naming %s: error = %v, want InvalidArgument", snapshotter.LabelCloneSize, err)
+	}
+}
@@ -0,0 +1,40 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrStrategyNotApplicable is returned by a [CloneStrategy] that cannot
+// handle a particular pair of directories, e.g. because they are not on the
+// filesystem it targets.  The next strategy, and finally the regular file
+// copy, is tried instead.
+var ErrStrategyNotApplicable = errors.New("clone strategy not applicable")
+
+// CloneStrategy populates a clone's writable directory from its source using
+// a mechanism specific to the underlying storage, such as a filesystem-level
+// snapshot.  Strategies are tried in order before falling back to copying
+// files one by one.
+type CloneStrategy interface {
+	// Clone makes dstDir an independent copy of srcDir.  dstDir is the
+	// freshly prepared, empty writable directory of the new snapshot.  Clone
+	// returns [ErrStrategyNotApplicable] (possibly wrapped) without changing
+	// anything if it cannot handle the directories.
+	Clone(ctx context.Context, srcDir, dstDir string) error
+}
+
+// runStrategies tries each strategy in turn.  It reports whether one of
+// them cloned srcDir into dstDir.
+func runStrategies(ctx context.Context, strategies []CloneStrategy, srcDir, dstDir string) (bool, error) {
+	for _, st := range strategies {
+		err := st.Clone(ctx, srcDir, dstDir)
+		if errors.Is(err, ErrStrategyNotApplicable) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// markerStrategy is a CloneStrategy that writes a marker file instead of
+// copying, so tests can tell whether it ran.
+type markerStrategy struct{ calls int }
+
+func (m *markerStrategy) Clone(_ context.Context, _, dstDir string) error {
+	m.calls++
+	return os.WriteFile(filepath.Join(dstDir, "strategy-marker"), nil, 0644)
+}
+
+// prepareStrategySource creates an active snapshot named key containing
+// data.txt.
+func prepareStrategySource(t *testing.T, sn *snapshotter.CloneSnapshotter, key string) {
+	t.Helper()
+	if _, err := sn.Prepare(context.Background(), key, ""); err != nil {
+		t.Fatalf("Prepare %s: %v", key, err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, key), "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+}
+
+// TestPrepare_Clone_ZFSFallback verifies that the ZFS strategy steps aside
+// on a non-ZFS filesystem and the clone falls back to copying files.
+func TestPrepare_Clone_ZFSFallback(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	// A missing zfs binary proves the strategy never got as far as running it.
+	sn.Strategies = []snapshotter.CloneStrategy{&snapshotter.ZFSStrategy{Command: "/nonexistent/zfs"}}
+	prepareStrategySource(t, sn, "zfs-src")
+
+	if _, err := sn.Prepare(ctx, "zfs-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "zfs-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare zfs-clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "zfs-clone"), "data.txt", "data")
+}
+
+// TestPrepare_Clone_StrategyOrder verifies that the first applicable
+// strategy replaces the file copy, and that strategies are bypassed when the
+// clone excludes paths.
+func TestPrepare_Clone_StrategyOrder(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	marker := &markerStrategy{}
+	sn.Strategies = []snapshotter.CloneStrategy{&snapshotter.ZFSStrategy{Command: "/nonexistent/zfs"}, marker}
+	prepareStrategySource(t, sn, "st-src")
+
+	if _, err := sn.Prepare(ctx, "st-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "st-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare st-clone: %v", err)
+	}
+	cloneDir := writableDir(t, sn, "st-clone")
+	if _, err := os.Stat(filepath.Join(cloneDir, "strategy-marker")); err != nil {
+		t.Errorf("expected the marker strategy to run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cloneDir, "data.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected no file copy after a strategy succeeded, stat err = %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "st-clone-excl", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource:  "st-src",
+			snapshotter.LabelCloneExclude: "tmp",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare st-clone-excl: %v", err)
+	}
+	if marker.calls != 1 {
+		t.Errorf("marker strategy calls = %d, want 1", marker.calls)
+	}
+	assertFileContent(t, writableDir(t, sn, "st-clone-excl"), "data.txt", "data")
+}
@@ -0,0 +1,354 @@
+package snapshotter
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// paxXattrPrefix prefixes the PAX records holding extended attributes, as
+// written by GNU tar and containerd's archive package.
+const paxXattrPrefix = "SCHILY.xattr."
+
+// copyTar copies the contents of srcDir into dstDir for a clone requested
+// with [LabelCloneViaTar], by streaming srcDir through a tar archive that is
+// extracted into dstDir as it is written.  Unlike copyDir it preserves
+// hard links between files, FIFOs and all extended attributes.  Ownership,
+// inode flags, the rate limit, the open file limit and checksum
+// verification are handled as by copyDir.
+//
+// A failure is reported as a [*CloneCopyError].
+func (c *copier) copyTar(ctx context.Context, srcDir, dstDir string) error {
+	if err := copyXattrs(srcDir, dstDir, overlayOpaqueXattrs); err != nil {
+		return &CloneCopyError{Path: srcDir, Err: err}
+	}
+
+	pr, pw := io.Pipe()
+	werr := make(chan error, 1)
+	go func() {
+		err := c.writeTar(ctx, srcDir, pw)
+		pw.CloseWithError(err)
+		werr <- err
+	}()
+
+	current, err := c.extractTar(ctx, tar.NewReader(pr), srcDir, dstDir)
+	// Unblock the writer if extraction stopped early.
+	pr.CloseWithError(errors.Join(err, io.ErrClosedPipe))
+	if wErr := <-werr; err == nil && wErr != nil {
+		current, err = srcDir, wErr
+	}
+	if err == nil {
+		current, err = dstDir, copyTimes(srcDir, dstDir)
+	}
+	if err != nil {
+		return &CloneCopyError{Path: current, Files: c.files, Bytes: c.bytes, Err: err}
+	}
+	return nil
+}
+
+// writeTar writes the entries below srcDir to w as a tar archive.  Excluded
+// entries and, unless c.crossMounts is set, other file systems are left out
+// as by copyDir.
+func (c *copier) writeTar(ctx context.Context, srcDir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// links maps the inodes of regular files with several links to the
+	// first name they were archived under.
+	links := map[uint64]string{}
+	var rootDev uint64
+	err := c.walk(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rootDev = c.device(info)
+			return nil
+		}
+		if c.excluded(rel) || (!c.crossMounts && c.device(info) != rootDev) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		var target string
+		if d.Type()&fs.ModeSymlink != 0 {
+			if target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Format = tar.FormatPAX
+		// Names are resolved again on extraction; the numeric IDs are
+		// what the clone must keep.
+		hdr.Uname, hdr.Gname = "", ""
+		names, err := llistxattr(path)
+		if err != nil && !errors.Is(err, unix.ENOTSUP) {
+			return fmt.Errorf("list xattrs: %w", err)
+		}
+		for _, name := range names {
+			val, err := lgetxattr(path, name)
+			if errors.Is(err, unix.ENODATA) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("get xattr %s: %w", name, err)
+			}
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = map[string]string{}
+			}
+			hdr.PAXRecords[paxXattrPrefix+name] = string(val)
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+			if first, ok := links[st.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				links[st.Ino] = hdr.Name
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		if c.hook != nil {
+			if err := c.hook(ctx, path); err != nil {
+				return err
+			}
+		}
+		// The slot covers both the source file and the destination file
+		// extracted from the archive while it is written.
+		if c.acquireFile != nil {
+			release, err := c.acquireFile(ctx)
+			if err != nil {
+				return err
+			}
+			defer release()
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// The size is fixed by the header.  A live source file that grew
+		// since is truncated to it, and one that shrank is padded with
+		// zeros, as GNU tar does, rather than failing the clone.
+		n, err := io.Copy(tw, io.LimitReader(ctxReader{ctx, f}, hdr.Size))
+		if err != nil {
+			return err
+		}
+		if n < hdr.Size {
+			log.G(ctx).WithField("path", path).WithField("missing", hdr.Size-n).Warn("tar clone: file shrank while being copied, padding with zeros")
+			_, err = io.CopyN(tw, zeroReader{}, hdr.Size-n)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar extracts the archive of srcDir read by tr into dstDir.  It
+// returns the path being extracted when it failed.
+func (c *copier) extractTar(ctx context.Context, tr *tar.Reader, srcDir, dstDir string) (string, error) {
+	type dirTimes struct {
+		path         string
+		atime, mtime time.Time
+	}
+	var dirs []dirTimes
+	// flagged are the names of the files and directories whose inode
+	// flags, which the archive does not carry, are copied from srcDir.
+	var flagged []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dstDir, err
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return dstDir, fmt.Errorf("tar entry %q is outside the destination", hdr.Name)
+		}
+		path := filepath.Join(dstDir, hdr.Name)
+		if err := c.extractEntry(ctx, tr, hdr, dstDir, path); err != nil {
+			return path, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTimes{path, hdr.AccessTime, hdr.ModTime})
+		}
+		if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tar.TypeReg {
+			flagged = append(flagged, hdr.Name)
+		}
+	}
+
+	// Directory times are set last, deepest first, once nothing is
+	// added to them any more.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirs[i].path, dirs[i].atime, dirs[i].mtime); err != nil {
+			return dirs[i].path, err
+		}
+	}
+	// Inode flags are applied last, deepest first, because an immutable
+	// file can no longer be linked to and an immutable directory can no
+	// longer have entries added or its times set.
+	for i := len(flagged) - 1; i >= 0; i-- {
+		path := filepath.Join(dstDir, flagged[i])
+		if err := copyInodeFlags(filepath.Join(srcDir, flagged[i]), path); err != nil {
+			return path, err
+		}
+	}
+	if c.durable {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := c.syncPath(dirs[i].path); err != nil {
+				return dirs[i].path, fmt.Errorf("sync directory: %w", err)
+			}
+		}
+		if err := c.syncPath(dstDir); err != nil {
+			return dstDir, fmt.Errorf("sync directory: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// extractEntry creates the archive entry hdr at path, reading a regular
+// file's content from r.  Hard links are resolved against dstDir.
+func (c *copier) extractEntry(ctx context.Context, r io.Reader, hdr *tar.Header, dstDir, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// The permission, setuid, setgid and sticky bits, as the kernel takes
+	// them; os.FileMode keeps the latter three elsewhere.
+	mode := uint32(hdr.Mode) & 07777
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0700); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		r = ctxReader{ctx, r}
+		if c.limiter != nil {
+			r = rateReader{ctx, r, c.limiter}
+		}
+		var h hash.Hash32
+		if c.verifyChecksums {
+			h = crc32.New(castagnoli)
+			r = io.TeeReader(r, h)
+		}
+		buf := c.buffer()
+		n, err := io.CopyBuffer(struct{ io.Writer }{f}, r, *buf)
+		c.putBuffer(buf)
+		c.bytes += n
+		if err == nil && c.durable {
+			err = c.syncFile(f)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if c.maxBytes > 0 && c.bytes > c.maxBytes {
+			return fmt.Errorf("%w: copied more than %d bytes", ErrCloneTooLarge, c.maxBytes)
+		}
+		if h != nil {
+			got, err := fileChecksum(path)
+			if err != nil {
+				return fmt.Errorf("verify %s: %w", path, err)
+			}
+			if got != h.Sum32() {
+				return fmt.Errorf("%w: %s: source crc32c %08x, destination crc32c %08x", ErrChecksumMismatch, path, h.Sum32(), got)
+			}
+		}
+		c.fileCopied()
+	case tar.TypeLink:
+		if !filepath.IsLocal(hdr.Linkname) {
+			return fmt.Errorf("tar link target %q is outside the destination", hdr.Linkname)
+		}
+		// The link shares the inode, and so the metadata, of its target.
+		c.fileCopied()
+		return os.Link(filepath.Join(dstDir, hdr.Linkname), path)
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		kind := map[byte]uint32{tar.TypeChar: unix.S_IFCHR, tar.TypeBlock: unix.S_IFBLK, tar.TypeFifo: unix.S_IFIFO}[hdr.Typeflag]
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, kind|mode&^(unix.S_ISUID|unix.S_ISGID), int(dev)); err != nil {
+			return fmt.Errorf("mknod %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+
+	owned, err := c.chown(path, hdr.Uid, hdr.Gid)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		mode &^= unix.S_ISUID | unix.S_ISGID
+	}
+	// The mode is set after the chown, which clears the setuid and setgid
+	// bits, and before the xattrs, since a chmod rewrites the ACL mask.
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := unix.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	for key, val := range hdr.PAXRecords {
+		name, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok {
+			continue
+		}
+		if err := unix.Lsetxattr(path, name, []byte(val), 0); err != nil {
+			return fmt.Errorf("set xattr %s: %w", name, err)
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return os.Chtimes(path, hdr.AccessTime, hdr.ModTime)
+}
+
+// zeroReader reads an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,150 @@
+package snapshotter_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"golang.org/x/sys/unix"
+)
+
+// TestPrepare_Clone_ViaTar verifies that a tar-streamed clone reproduces
+// every kind of entry together with its ownership and extended attributes,
+// and keeps hard-linked files linked.
+func TestPrepare_Clone_ViaTar(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	join := func(name string) string { return filepath.Join(srcDir, name) }
+	if err := os.MkdirAll(join("etc/empty"), 0755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(join("etc/app.conf"), []byte("config"), 0640); err != nil {
+		t.Fatalf("write app.conf: %v", err)
+	}
+	if err := os.Link(join("etc/app.conf"), join("app.conf")); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if err := os.Symlink("etc/app.conf", join("conf")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := unix.Mknod(join("null"), unix.S_IFCHR|0666, int(unix.Mkdev(1, 3))); err != nil {
+		t.Skipf("mknod not permitted: %v", err)
+	}
+	if err := unix.Mkfifo(join("fifo"), 0600); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	if err := os.Lchown(join("etc/app.conf"), 1000, 1001); err != nil {
+		t.Fatalf("chown: %v", err)
+	}
+	if err := unix.Lsetxattr(join("etc/app.conf"), "user.origin", []byte("golden"), 0); err != nil {
+		t.Skipf("user xattrs not supported: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneViaTar: "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	dstDir := writableDir(t, sn, "clone")
+	clone := func(name string) string { return filepath.Join(dstDir, name) }
+
+	data, err := os.ReadFile(clone("etc/app.conf"))
+	if err != nil || string(data) != "config" {
+		t.Errorf("cloned app.conf = %q, %v; want %q", data, err, "config")
+	}
+	var st unix.Stat_t
+	if err := unix.Lstat(clone("etc/app.conf"), &st); err != nil {
+		t.Fatalf("stat cloned app.conf: %v", err)
+	}
+	if st.Uid != 1000 || st.Gid != 1001 || st.Mode&0777 != 0640 {
+		t.Errorf("cloned app.conf owner %d:%d mode %o, want 1000:1001 640", st.Uid, st.Gid, st.Mode&0777)
+	}
+	a, errA := os.Stat(clone("etc/app.conf"))
+	b, errB := os.Stat(clone("app.conf"))
+	if errA != nil || errB != nil || !os.SameFile(a, b) {
+		t.Errorf("cloned app.conf and etc/app.conf are not hard-linked")
+	}
+	if target, err := os.Readlink(clone("conf")); err != nil || target != "etc/app.conf" {
+		t.Errorf("cloned symlink = %q, %v; want etc/app.conf", target, err)
+	}
+	if fi, err := os.Stat(clone("etc/empty")); err != nil || !fi.IsDir() {
+		t.Errorf("cloned empty directory = %v, %v", fi, err)
+	}
+	if err := unix.Lstat(clone("null"), &st); err != nil {
+		t.Errorf("stat cloned device: %v", err)
+	} else if st.Mode&unix.S_IFMT != unix.S_IFCHR || st.Rdev != unix.Mkdev(1, 3) {
+		t.Errorf("cloned device mode %o rdev %d, want a 1:3 character device", st.Mode, st.Rdev)
+	}
+	if fi, err := os.Lstat(clone("fifo")); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("cloned fifo = %v, %v; want a named pipe", fi, err)
+	}
+	buf := make([]byte, 64)
+	n, err := unix.Lgetxattr(clone("etc/app.conf"), "user.origin", buf)
+	if err != nil || string(buf[:n]) != "golden" {
+		t.Errorf("cloned xattr = %q, %v; want %q", buf[:max(n, 0)], err, "golden")
+	}
+
+	info, err := sn.Stat(ctx, "clone")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if _, ok := info.Labels[snapshotter.LabelCloneViaTar]; ok {
+		t.Errorf("clone kept the %s control label", snapshotter.LabelCloneViaTar)
+	}
+	// Both names of the hard-linked file count as files.
+	if got := info.Labels[snapshotter.LabelCloneFiles]; got != "2" {
+		t.Errorf("%s = %q, want 2", snapshotter.LabelCloneFiles, got)
+	}
+}
+
+// TestPrepare_Clone_ViaTarLazy verifies that a tar-streamed clone cannot
+// also be lazy.
+func TestPrepare_Clone_ViaTarLazy(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneViaTar: "true",
+		snapshotter.LabelCloneLazy:   "true",
+	}))
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Fatalf("Prepare error = %v, want ErrInvalidArgument", err)
+	}
+}
+
+// TestPrepare_Clone_ViaTarDirectIO verifies that a tar-streamed clone is
+// refused while DirectIO is set, which it could not honour.
+func TestPrepare_Clone_ViaTarDirectIO(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+	sn.DirectIO = true
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	_, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+		snapshotter.LabelCloneViaTar: "true",
+	}))
+	if !errors.Is(err, errdefs.ErrInvalidArgument) {
+		t.Fatalf("Prepare error = %v, want ErrInvalidArgument", err)
+	}
+}
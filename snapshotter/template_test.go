@@ -0,0 +1,44 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestPrepare_Clone_TemplatedSource verifies that ${key} in the source label
+// expands to the new snapshot's key, that the expanded key is recorded as the
+// source and that the caller's labels are left untouched.
+func TestPrepare_Clone_TemplatedSource(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "web-prev", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(writableDir(t, sn, "web-prev"), "data.txt"), []byte("previous"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	labels := map[string]string{snapshotter.LabelCloneSource: "${key}-prev"}
+	if _, err := sn.Prepare(ctx, "web", "", snapshots.WithLabels(labels)); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+	assertFileContent(t, writableDir(t, sn, "web"), "data.txt", "previous")
+
+	info, err := sn.Stat(ctx, "web")
+	if err != nil {
+		t.Fatalf("Stat clone: %v", err)
+	}
+	if got := info.Labels[snapshotter.LabelClonedFrom]; got != "web-prev" {
+		t.Errorf("label cloned-from = %q, want %q", got, "web-prev")
+	}
+	if got := labels[snapshotter.LabelCloneSource]; got != "${key}-prev" {
+		t.Errorf("caller's source label changed to %q", got)
+	}
+}
@@ -0,0 +1,98 @@
+package snapshotter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_Thin verifies that a thin clone on the native backend
+// copies only the files the source changed relative to its parent, yet
+// still ends up with the source's complete tree.
+func TestPrepare_Clone_Thin(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+
+	if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+		t.Fatalf("Prepare base-active: %v", err)
+	}
+	baseDir, err := sn.WritableDir(ctx, "base-active")
+	if err != nil {
+		t.Fatalf("WritableDir base-active: %v", err)
+	}
+	for _, name := range []string{"same.txt", "modified.txt", "deleted.txt", "chmodded.txt"} {
+		if err := os.WriteFile(filepath.Join(baseDir, name), []byte("base "+name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+		t.Fatalf("Commit base: %v", err)
+	}
+
+	if _, err := sn.Prepare(ctx, "source", "base"); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir source: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "modified.txt"), []byte("changed in source"), 0644); err != nil {
+		t.Fatalf("modify modified.txt: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "added.txt"), []byte("added"), 0644); err != nil {
+		t.Fatalf("write added.txt: %v", err)
+	}
+	if err := os.Remove(filepath.Join(srcDir, "deleted.txt")); err != nil {
+		t.Fatalf("remove deleted.txt: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(srcDir, "chmodded.txt"), 0600); err != nil {
+		t.Fatalf("chmod chmodded.txt: %v", err)
+	}
+
+	var copied []string
+	sn.copyHook = func(_ context.Context, path string) error {
+		copied = append(copied, filepath.Base(path))
+		return nil
+	}
+	if _, err := sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+		LabelCloneThin:   "true",
+	})); err != nil {
+		t.Fatalf("Prepare clone: %v", err)
+	}
+
+	slices.Sort(copied)
+	if want := []string{"added.txt", "chmodded.txt", "modified.txt"}; !slices.Equal(copied, want) {
+		t.Errorf("copied %v, want only %v", copied, want)
+	}
+
+	cloneDir, err := sn.WritableDir(ctx, "clone")
+	if err != nil {
+		t.Fatalf("WritableDir clone: %v", err)
+	}
+	for name, want := range map[string]string{
+		"same.txt":     "base same.txt",
+		"modified.txt": "changed in source",
+		"added.txt":    "added",
+	} {
+		got, err := os.ReadFile(filepath.Join(cloneDir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(cloneDir, "deleted.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted.txt still present in clone, lstat err = %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(cloneDir, "chmodded.txt")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("chmodded.txt: stat = %v, %v; want mode 0600", fi, err)
+	}
+}
@@ -0,0 +1,56 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_Timeout verifies that a clone whose copy outlives
+// CloneTimeout is cancelled, reports context.DeadlineExceeded and leaves no
+// destination snapshot behind.
+func TestPrepare_Clone_Timeout(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	sn.CloneTimeout = 50 * time.Millisecond
+	sn.copyHook = func(ctx context.Context, _ string) error {
+		// Simulate a copy stuck on a slow source.
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write source file: %v", err)
+	}
+
+	start := time.Now()
+	_, err = sn.Prepare(ctx, "clone", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Prepare clone error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("clone took %v to time out", elapsed)
+	}
+	if _, err := sn.Stat(ctx, "clone"); err == nil {
+		t.Error("timed-out clone snapshot was not removed")
+	}
+}
@@ -0,0 +1,83 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestPrepare_Clone_Tracing verifies that a clone records a clone_prepare
+// span carrying the keys and copy totals, with child spans for the prepare
+// and copy phases.
+func TestPrepare_Clone_Tracing(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := snapshotter.New(inner, snapshotter.WithTracerProvider(tp))
+
+	if _, err := sn.Prepare(ctx, "trace-src", ""); err != nil {
+		t.Fatalf("Prepare trace-src: %v", err)
+	}
+	srcDir := writableDir(t, sn, "trace-src")
+	for name, size := range map[string]int{"a.bin": 100, "b.bin": 250} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), make([]byte, size), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "trace-clone", "",
+		snapshots.WithLabels(map[string]string{
+			snapshotter.LabelCloneSource: "trace-src",
+		}),
+	); err != nil {
+		t.Fatalf("Prepare trace-clone: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	root, ok := spans["clone_prepare"]
+	if !ok {
+		t.Fatalf("no clone_prepare span recorded, got %v", spans)
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range root.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	for key, want := range map[attribute.Key]attribute.Value{
+		"clone.source": attribute.StringValue("trace-src"),
+		"clone.key":    attribute.StringValue("trace-clone"),
+		"clone.bytes":  attribute.Int64Value(350),
+		"clone.files":  attribute.Int64Value(2),
+	} {
+		if got := attrs[key]; got != want {
+			t.Errorf("attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+
+	for _, name := range []string{"prepare_snapshot", "copy_writable_layer"} {
+		child, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span recorded", name)
+			continue
+		}
+		if child.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("%s span is not a child of clone_prepare", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package snapshotter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrStructureMismatch is returned (wrapped) by Prepare when
+// [CloneSnapshotter.VerifyStructure] is enabled and the copied writable layer
+// does not have the same tree as its source.  The error wrapping it is a
+// [*StructureMismatchError] listing the differences.
+var ErrStructureMismatch = errors.New("structure mismatch")
+
+// maxReportedMismatches caps the differences spelled out in the message of
+// a StructureMismatchError; all of them are kept in Mismatches.
+const maxReportedMismatches = 10
+
+// StructureMismatchError reports how a clone's writable layer differs from
+// its source's; use [errors.As] to retrieve it.
+type StructureMismatchError struct {
+	// Source and Destination are the writable directories compared.
+	Source      string
+	Destination string
+
+	// SourceEntries and DestinationEntries are the number of entries below
+	// each directory.
+	SourceEntries      int
+	DestinationEntries int
+
+	// Mismatches describes each difference, in path order, as in
+	// "etc/hosts: missing from clone" or "bin/sh: mode -rwxr-xr-x, clone has
+	// -rw-r--r--".
+	Mismatches []string
+}
+
+func (e *StructureMismatchError) Error() string {
+	shown := e.Mismatches
+	if len(shown) > maxReportedMismatches {
+		shown = shown[:maxReportedMismatches]
+	}
+	msg := fmt.Sprintf("%v between %s (%d entries) and %s (%d entries): %d differences: %s",
+		ErrStructureMismatch, e.Source, e.SourceEntries, e.Destination, e.DestinationEntries,
+		len(e.Mismatches), strings.Join(shown, "; "))
+	if len(shown) < len(e.Mismatches) {
+		msg += "; ..."
+	}
+	return msg
+}
+
+func (e *StructureMismatchError) Unwrap() error { return ErrStructureMismatch }
+
+// treeEntry is what structure verification compares of a file.
+type treeEntry struct {
+	mode fs.FileMode
+	size int64
+}
+
+// verifyLayerDir checks, when structure verification is enabled, that the
+// copied writable directory dstDir has the same entries as srcDir, with the
+// same types, mode bits and, for regular files, sizes.  File data is left to
+// checksum verification.  Clones that leave files out or keep extra ones on
+// purpose, through exclusions, path maps, merging or skipped files, or that
+// replace symlinks with what they lead to are not verified.
+func (c *copier) verifyLayerDir(srcDir, dstDir string) error {
+	if !c.verifyStructure || len(c.exclude) > 0 || len(c.pathMap) > 0 || c.merge || len(c.skipped) > 0 || c.followSymlinks {
+		return nil
+	}
+	if c.verifyHook != nil {
+		c.verifyHook(dstDir)
+	}
+
+	src, err := c.readTree(srcDir, !c.crossMounts)
+	if err != nil {
+		return fmt.Errorf("verify structure: source: %w", err)
+	}
+	dst, err := c.readTree(dstDir, false)
+	if err != nil {
+		return fmt.Errorf("verify structure: destination: %w", err)
+	}
+
+	var mismatches []string
+	for rel, want := range src {
+		got, ok := dst[rel]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, rel+": missing from clone")
+		case got.mode != want.mode:
+			mismatches = append(mismatches, fmt.Sprintf("%s: mode %v, clone has %v", rel, want.mode, got.mode))
+		case got.size != want.size:
+			mismatches = append(mismatches, fmt.Sprintf("%s: size %d, clone has %d", rel, want.size, got.size))
+		}
+	}
+	for rel := range dst {
+		if _, ok := src[rel]; !ok {
+			mismatches = append(mismatches, rel+": not in source")
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	sort.Strings(mismatches)
+	return &StructureMismatchError{
+		Source:             srcDir,
+		Destination:        dstDir,
+		SourceEntries:      len(src),
+		DestinationEntries: len(dst),
+		Mismatches:         mismatches,
+	}
+}
+
+// readTree returns the entries below root by path relative to it, leaving
+// out the ignored ones.  If sameDevice is set, mount points below root are
+// left out too, as copyDir does not cross them.
+func (c *copier) readTree(root string, sameDevice bool) (map[string]treeEntry, error) {
+	fi, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+	rootDev := c.device(fi)
+
+	entries := map[string]treeEntry{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if sameDevice && c.device(info) != rootDev {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if matchAny(c.ignore, rel) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		e := treeEntry{mode: info.Mode()}
+		if info.Mode().IsRegular() {
+			e.size = info.Size()
+		}
+		entries[rel] = e
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,76 @@
+package snapshotter
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/containerd/containerd/snapshots/native"
+)
+
+// TestPrepare_Clone_VerifyStructure verifies that a faithful clone passes
+// structure verification, and that a clone missing a file or with a changed
+// mode fails it with a report of each difference and is removed.
+func TestPrepare_Clone_VerifyStructure(t *testing.T) {
+	ctx := context.Background()
+	inner, err := native.NewSnapshotter(t.TempDir())
+	if err != nil {
+		t.Fatalf("create native snapshotter: %v", err)
+	}
+	sn := New(inner)
+	sn.VerifyStructure = true
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir, err := sn.WritableDir(ctx, "source")
+	if err != nil {
+		t.Fatalf("WritableDir source: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("create sub: %v", err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if _, err := sn.Prepare(ctx, "good", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	})); err != nil {
+		t.Fatalf("Prepare good clone: %v", err)
+	}
+
+	sn.verifyHook = func(dstDir string) {
+		if err := os.Remove(filepath.Join(dstDir, "a.txt")); err != nil {
+			t.Errorf("remove a.txt: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(dstDir, "sub", "b.txt"), 0600); err != nil {
+			t.Errorf("chmod b.txt: %v", err)
+		}
+	}
+	_, err = sn.Prepare(ctx, "bad", "", snapshots.WithLabels(map[string]string{
+		LabelCloneSource: "source",
+	}))
+	var mismatch *StructureMismatchError
+	if !errors.As(err, &mismatch) || !errors.Is(err, ErrStructureMismatch) {
+		t.Fatalf("Prepare bad clone error = %v, want a StructureMismatchError", err)
+	}
+	want := []string{
+		"a.txt: missing from clone",
+		"sub/b.txt: mode -rw-r--r--, clone has -rw-------",
+	}
+	if len(mismatch.Mismatches) != len(want) || mismatch.Mismatches[0] != want[0] || mismatch.Mismatches[1] != want[1] {
+		t.Errorf("mismatches = %q, want %q", mismatch.Mismatches, want)
+	}
+	if mismatch.SourceEntries != 3 || mismatch.DestinationEntries != 2 {
+		t.Errorf("entries = %d and %d, want 3 and 2", mismatch.SourceEntries, mismatch.DestinationEntries)
+	}
+	if _, err := inner.Stat(ctx, "bad"); err == nil {
+		t.Error("clone failing structure verification was not removed")
+	}
+}
@@ -0,0 +1,72 @@
+package snapshotter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshots"
+)
+
+// LabelCloneViewOf is recorded on view clones, created by View with
+// [LabelCloneSource].  Its value is the key of the hidden committed snapshot
+// holding the copy of the source that the view is a view of; Remove removes
+// it together with the view.
+const LabelCloneViewOf = "containerd.io/snapshot/clone-view-of"
+
+// viewBackingPrefix prefixes the keys of the committed snapshots backing
+// view clones.
+const viewBackingPrefix = "clone-view-backing:"
+
+// View creates a read-only snapshot identified by key.
+//
+// If the [LabelCloneSource] label is present in opts, View creates a
+// read-only clone of the source instead of a view of parent: the source is
+// cloned as by Prepare into a temporary active snapshot, which is committed
+// and viewed.  The view mirrors the source at the time of the call, and the
+// source is left untouched, which makes it suitable for inspecting a running
+// container.  The committed copy is removed when the view is.
+func (s *CloneSnapshotter) View(ctx context.Context, key, parent string, opts ...snapshots.Opt) ([]mount.Mount, error) {
+	info := snapshots.Info{}
+	for _, opt := range opts {
+		if err := opt(&info); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := info.Labels[LabelCloneSource]; !ok {
+		return s.Snapshotter.View(ctx, key, parent, opts...)
+	}
+
+	backing := viewBackingPrefix + key
+	active := backing + ":active"
+	if _, err := s.clonePrepare(ctx, active, expandCloneSource(info.Labels, key), opts, ""); err != nil {
+		return nil, err
+	}
+	// The view carries the labels, including lineage and statistics, the
+	// clone was created with.
+	cloneInfo, err := s.Snapshotter.Stat(ctx, active)
+	if err == nil {
+		err = s.Snapshotter.Commit(ctx, backing, active)
+	}
+	s.untrackClone(ctx, active)
+	if err != nil {
+		if removeErr := s.Remove(context.WithoutCancel(ctx), active); removeErr != nil {
+			s.cleanupFailed(ctx, active, removeErr)
+		}
+		return nil, fmt.Errorf("commit view clone %q: %w", key, err)
+	}
+
+	labels := map[string]string{LabelCloneViewOf: backing}
+	for k, v := range cloneInfo.Labels {
+		labels[k] = v
+	}
+	mounts, err := s.Snapshotter.View(ctx, key, backing, snapshots.WithLabels(labels))
+	if err != nil {
+		if removeErr := s.Remove(context.WithoutCancel(ctx), backing); removeErr != nil {
+			s.cleanupFailed(ctx, backing, removeErr)
+		}
+		return nil, fmt.Errorf("view snapshot %q: %w", key, err)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,91 @@
+package snapshotter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fengqi-dev/containerd-clone-snapshotter/snapshotter"
+)
+
+// TestView_Clone verifies that View with the clone-source label creates a
+// read-only snapshot holding the source's files at the time of the call, and
+// that removing it leaves no snapshot behind.
+func TestView_Clone(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "source", ""); err != nil {
+		t.Fatalf("Prepare source: %v", err)
+	}
+	srcDir := writableDir(t, sn, "source")
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("data"), 0644); err != nil {
+		t.Fatalf("write data.txt: %v", err)
+	}
+
+	mounts, err := sn.View(ctx, "inspect", "", snapshots.WithLabels(map[string]string{
+		snapshotter.LabelCloneSource: "source",
+	}))
+	if err != nil {
+		t.Fatalf("View clone: %v", err)
+	}
+	if len(mounts) != 1 || !slices.Contains(mounts[0].Options, "ro") {
+		t.Fatalf("view mounts = %+v, want a single read-only mount", mounts)
+	}
+	info, err := sn.Stat(ctx, "inspect")
+	if err != nil {
+		t.Fatalf("Stat view: %v", err)
+	}
+	if info.Kind != snapshots.KindView {
+		t.Errorf("view kind = %v, want %v", info.Kind, snapshots.KindView)
+	}
+	if got := info.Labels[snapshotter.LabelClonedFrom]; got != "source" {
+		t.Errorf("label cloned-from = %q, want %q", got, "source")
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, "data.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("modify source: %v", err)
+	}
+	assertFileContent(t, mounts[0].Source, "data.txt", "data")
+
+	if err := sn.Remove(ctx, "inspect"); err != nil {
+		t.Fatalf("Remove view: %v", err)
+	}
+	if err := sn.Walk(ctx, func(_ context.Context, info snapshots.Info) error {
+		if info.Name != "source" {
+			t.Errorf("snapshot %q left behind after removing the view", info.Name)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+}
+
+// TestView_NormalDelegation verifies that View without the clone-source
+// label is passed through unchanged.
+func TestView_NormalDelegation(t *testing.T) {
+	ctx := context.Background()
+	sn, cleanup := newTestSnapshotter(t)
+	defer cleanup()
+
+	if _, err := sn.Prepare(ctx, "base-active", ""); err != nil {
+		t.Fatalf("Prepare base: %v", err)
+	}
+	if err := sn.Commit(ctx, "base", "base-active"); err != nil {
+		t.Fatalf("Commit base: %v", err)
+	}
+	if _, err := sn.View(ctx, "view", "base"); err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	info, err := sn.Stat(ctx, "view")
+	if err != nil {
+		t.Fatalf("Stat view: %v", err)
+	}
+	if info.Parent != "base" || len(info.Labels) != 0 {
+		t.Errorf("view info = %+v, want parent base and no labels", info)
+	}
+}
@@ -0,0 +1,85 @@
+package snapshotter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// overlayOpaqueXattrs are the extended attributes overlayfs uses to mark an
+// upperdir directory as opaque, hiding the contents of the same directory in
+// the lower layers.  The user.* form is used when overlay is mounted with the
+// userxattr option.
+var overlayOpaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// aclXattrs are the extended attributes holding an entry's POSIX ACLs in the
+// kernel's binary format.  The default ACL is only ever set on directories.
+var aclXattrs = []string{"system.posix_acl_access", "system.posix_acl_default"}
+
+// lgetxattr returns the value of the extended attribute name on path without
+// following symlinks.  It returns unix.ENODATA if the attribute is not set.
+func lgetxattr(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// The value grew between the two calls; try again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// copyXattrs copies the named extended attributes from src to dst, skipping
+// any that are not set on src or not supported by its filesystem.
+func copyXattrs(src, dst string, names []string) error {
+	for _, name := range names {
+		val, err := lgetxattr(src, name)
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get xattr %s on %s: %w", name, src, err)
+		}
+		if err := unix.Lsetxattr(dst, name, val, 0); err != nil {
+			return fmt.Errorf("set xattr %s on %s: %w", name, dst, err)
+		}
+	}
+	return nil
+}
+
+// llistxattr returns the names of the extended attributes set on path
+// without following symlinks.
+func llistxattr(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			// The list grew between the two calls; try again.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
@@ -0,0 +1,148 @@
+package snapshotter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/containerd/log"
+	"golang.org/x/sys/unix"
+)
+
+// zfsSuperMagic is the f_type reported by statfs for ZFS filesystems.
+const zfsSuperMagic = 0x2fc12fc1
+
+// ZFSStrategy clones writable directories that are ZFS dataset mountpoints
+// with "zfs snapshot" and "zfs clone", which is instantaneous and shares all
+// blocks with the source until either side writes.
+//
+// Both the source and destination directory must be the mountpoint of their
+// own dataset; otherwise the strategy is not applicable.  The overlayfs
+// upperdirs and native snapshot directories of the bundled backends are
+// plain directories inside the snapshotter root, never dataset mountpoints,
+// so with them this strategy always steps aside; it is meant for backends
+// that give each snapshot its own dataset.
+//
+// The source is snapshotted as "<source>@clone-<unix nanoseconds>" and the
+// snapshot cloned into a temporary dataset next to the destination.  Only
+// once that has succeeded is the freshly prepared, empty destination dataset
+// renamed aside and the clone renamed into its place and mounted at the
+// same path.  A failure at any step before the empty dataset is destroyed
+// undoes the earlier steps, leaving the destination and the source as they
+// were.  The snapshot cannot be destroyed while the clone exists.
+type ZFSStrategy struct {
+	// Command is the zfs binary to run.  Defaults to "zfs" in $PATH.
+	Command string
+}
+
+// Clone implements [CloneStrategy].
+func (z *ZFSStrategy) Clone(ctx context.Context, srcDir, dstDir string) (retErr error) {
+	for _, dir := range []string{srcDir, dstDir} {
+		var st unix.Statfs_t
+		if err := unix.Statfs(dir, &st); err != nil {
+			return fmt.Errorf("statfs %q: %w", dir, err)
+		}
+		if st.Type != zfsSuperMagic {
+			return fmt.Errorf("%w: %s is not on zfs", ErrStrategyNotApplicable, dir)
+		}
+	}
+
+	datasets, err := z.mountedDatasets(ctx)
+	if err != nil {
+		return err
+	}
+	srcDS, ok := datasets[srcDir]
+	if !ok {
+		return fmt.Errorf("%w: %s is not a zfs dataset mountpoint", ErrStrategyNotApplicable, srcDir)
+	}
+	dstDS, ok := datasets[dstDir]
+	if !ok {
+		return fmt.Errorf("%w: %s is not a zfs dataset mountpoint", ErrStrategyNotApplicable, dstDir)
+	}
+
+	// undo holds the zfs commands reverting the steps taken so far, run in
+	// reverse order if a later step fails.
+	var undo [][]string
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		cleanupCtx := context.WithoutCancel(ctx)
+		for i := len(undo) - 1; i >= 0; i-- {
+			if _, err := z.run(cleanupCtx, undo[i]...); err != nil {
+				log.G(ctx).WithError(err).Warn("zfs clone: failed to undo step")
+			}
+		}
+	}()
+
+	id := time.Now().UnixNano()
+	snap := fmt.Sprintf("%s@clone-%d", srcDS, id)
+	tmpDS := fmt.Sprintf("%s-clone-%d", dstDS, id)
+	oldDS := fmt.Sprintf("%s-old-%d", dstDS, id)
+	if _, err := z.run(ctx, "snapshot", snap); err != nil {
+		return err
+	}
+	undo = append(undo, []string{"destroy", snap})
+	// The clone is not mounted until it has replaced the destination,
+	// whose dataset is still mounted at dstDir.
+	if _, err := z.run(ctx, "clone", "-o", "canmount=noauto", "-o", "mountpoint="+dstDir, snap, tmpDS); err != nil {
+		return err
+	}
+	undo = append(undo, []string{"destroy", tmpDS})
+	if _, err := z.run(ctx, "rename", dstDS, oldDS); err != nil {
+		return err
+	}
+	undo = append(undo, []string{"rename", oldDS, dstDS})
+	if _, err := z.run(ctx, "rename", tmpDS, dstDS); err != nil {
+		return err
+	}
+	undo = append(undo, []string{"rename", dstDS, tmpDS})
+	if _, err := z.run(ctx, "destroy", oldDS); err != nil {
+		return err
+	}
+	undo = nil
+	if _, err := z.run(ctx, "set", "canmount=on", dstDS); err != nil {
+		return err
+	}
+	if _, err := z.run(ctx, "mount", dstDS); err != nil {
+		return err
+	}
+	return nil
+}
+
+// mountedDatasets returns the mounted zfs datasets keyed by mountpoint.
+func (z *ZFSStrategy) mountedDatasets(ctx context.Context) (map[string]string, error) {
+	out, err := z.run(ctx, "list", "-H", "-o", "name,mountpoint", "-t", "filesystem")
+	if err != nil {
+		return nil, err
+	}
+	datasets := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		name, mountpoint, ok := strings.Cut(sc.Text(), "\t")
+		if ok && strings.HasPrefix(mountpoint, "/") {
+			datasets[mountpoint] = name
+		}
+	}
+	return datasets, sc.Err()
+}
+
+// run executes the zfs command with args and returns its standard output.
+func (z *ZFSStrategy) run(ctx context.Context, args ...string) ([]byte, error) {
+	command := z.Command
+	if command == "" {
+		command = "zfs"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("zfs %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}